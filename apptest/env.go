@@ -0,0 +1,17 @@
+/*
+Environment of the tests, imported blank by the test files of packages using the database.
+Packages are initialized in the order of their import paths, so this one runs before backend/config connects to the database.
+Tests run from their package directory without the .env file, they read the environment only and default to a local database.
+*/
+package apptest
+
+import (
+	"os"
+)
+
+func init() {
+	os.Setenv("PRODUCTION", "TRUE")
+	if os.Getenv("MONGOURI") == "" {
+		os.Setenv("MONGOURI", "mongodb://localhost:27017")
+	}
+}
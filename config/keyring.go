@@ -0,0 +1,541 @@
+package config
+
+import (
+	"backend/model"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Keys are read again from DB after this interval, so every instance picks up a rotation
+var keyringRefreshInterval = time.Minute
+
+// Unknown key IDs trigger a reload at most this often, so made up key IDs cannot flood the DB
+var keyringMinRefreshInterval = 5 * time.Second
+
+var keyringCollection = GetCollection(DB, "keyring")
+
+type loadedKey struct {
+	secret []byte
+	status string
+}
+
+// Keys of every purpose by key ID, loaded from DB and the environment
+var keyring = struct {
+	mutex    sync.RWMutex
+	keys     map[string]map[string]loadedKey
+	primary  map[string]string
+	loadedAt time.Time
+}{}
+
+/*
+Get the primary key of a purpose, used for new tokens
+
+params: purpose string model.KeyPurposeSigning or model.KeyPurposeEncryption
+
+return: string The key ID
+
+[]byte The key
+
+error The error if the keyring cannot be loaded or has no primary key
+*/
+func PrimaryKey(purpose string) (string, []byte, error) {
+	if loadErr := refreshKeyring(false); loadErr != nil {
+		return "", nil, loadErr
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	kid, exists := keyring.primary[purpose]
+	if !exists {
+		return "", nil, fmt.Errorf("no primary %s key", purpose)
+	}
+
+	return kid, keyring.keys[purpose][kid].secret, nil
+}
+
+/*
+Get a key that is still accepted, to verify or decrypt a token
+
+params: purpose string model.KeyPurposeSigning or model.KeyPurposeEncryption
+
+kid string The key ID, empty for tokens created before key IDs existed
+
+return: []byte The key
+
+error The error if the key does not exist or is retired
+*/
+func LookupKey(purpose, kid string) ([]byte, error) {
+	if kid == "" {
+		kid = model.LegacyKeyId
+	}
+
+	if loadErr := refreshKeyring(false); loadErr != nil {
+		return nil, loadErr
+	}
+
+	keyring.mutex.RLock()
+	key, exists := keyring.keys[purpose][kid]
+	loadedAt := keyring.loadedAt
+	keyring.mutex.RUnlock()
+
+	// The key may have been created by another instance since the last load
+	if !exists && time.Since(loadedAt) > keyringMinRefreshInterval {
+		if loadErr := refreshKeyring(true); loadErr != nil {
+			return nil, loadErr
+		}
+
+		keyring.mutex.RLock()
+		key, exists = keyring.keys[purpose][kid]
+		keyring.mutex.RUnlock()
+	}
+
+	if !exists {
+		return nil, fmt.Errorf("unknown %s key %q", purpose, kid)
+	}
+
+	return key.secret, nil
+}
+
+/*
+Encrypt data with the primary encryption key
+
+params: plaintext []byte The data to encrypt
+
+return: string The key ID and the encrypted data encoded as base64, separated by a dot
+
+error The error if the encryption fails
+*/
+func EncryptWithKeyring(plaintext []byte) (string, error) {
+	kid, key, keyErr := PrimaryKey(model.KeyPurposeEncryption)
+	if keyErr != nil {
+		return "", keyErr
+	}
+
+	ciphertext, encryptErr := seal(plaintext, key)
+	if encryptErr != nil {
+		return "", encryptErr
+	}
+
+	return kid + "." + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+/*
+Decrypt data encrypted by EncryptWithKeyring, or encrypted with ENCRYPTION_KEY before key IDs existed
+
+params: value string The encrypted data
+
+return: []byte The decrypted data
+
+error The error if the key is retired or the decryption fails
+*/
+func DecryptWithKeyring(value string) ([]byte, error) {
+	// Base64 has no dot, data without key ID was encrypted with the legacy key
+	kid, encoded := model.LegacyKeyId, value
+	if separator := strings.Index(value, "."); separator >= 0 {
+		kid, encoded = value[:separator], value[separator+1:]
+	}
+
+	key, keyErr := LookupKey(model.KeyPurposeEncryption, kid)
+	if keyErr != nil {
+		return nil, keyErr
+	}
+
+	ciphertext, decodeErr := base64.StdEncoding.DecodeString(encoded)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	return open(ciphertext, key)
+}
+
+/*
+Get the key ID of data encrypted by EncryptWithKeyring
+
+params: value string The encrypted data
+
+return: string The key ID
+*/
+func EncryptionKeyId(value string) string {
+	if separator := strings.Index(value, "."); separator >= 0 {
+		return value[:separator]
+	}
+
+	return model.LegacyKeyId
+}
+
+/*
+Create a new primary key for a purpose, the previous primary key stays accepted until it is retired
+
+params: ctx context.Context Context of the DB operations
+
+purpose string model.KeyPurposeSigning or model.KeyPurposeEncryption
+
+return: model.KeyringKey The new key
+
+error The error if KEYRING_MASTER_KEY is not set or the DB operations fail
+*/
+func RotateKey(ctx context.Context, purpose string) (model.KeyringKey, error) {
+	if purpose != model.KeyPurposeSigning && purpose != model.KeyPurposeEncryption {
+		return model.KeyringKey{}, fmt.Errorf("unknown key purpose %q", purpose)
+	}
+
+	masterKey, masterErr := keyringMasterKey()
+	if masterErr != nil {
+		return model.KeyringKey{}, masterErr
+	}
+
+	// AES-256 needs 32 bytes, HS512 should have at least 64
+	secretSize := 32
+	if purpose == model.KeyPurposeSigning {
+		secretSize = 64
+	}
+	secret := make([]byte, secretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return model.KeyringKey{}, err
+	}
+
+	encryptedSecret, encryptErr := seal(secret, masterKey)
+	if encryptErr != nil {
+		return model.KeyringKey{}, encryptErr
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := io.ReadFull(rand.Reader, suffix); err != nil {
+		return model.KeyringKey{}, err
+	}
+
+	key := model.KeyringKey{
+		Kid:       purpose[:3] + "-" + time.Now().Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Purpose:   purpose,
+		Secret:    base64.StdEncoding.EncodeToString(encryptedSecret),
+		Status:    model.KeyStatusPrimary,
+		CreatedAt: time.Now().Unix(),
+		UpdatedAt: time.Now().Unix(),
+	}
+
+	// The previous primary key is kept to verify and decrypt the tokens it created
+	_, demoteErr := keyringCollection.UpdateMany(
+		ctx,
+		bson.D{
+			{Key: "purpose", Value: purpose},
+			{Key: "status", Value: model.KeyStatusPrimary},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: model.KeyStatusActive},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if demoteErr != nil {
+		return model.KeyringKey{}, demoteErr
+	}
+
+	insertResult, insertErr := keyringCollection.InsertOne(ctx, key)
+	if insertErr != nil {
+		return model.KeyringKey{}, insertErr
+	}
+	key.Id, _ = insertResult.InsertedID.(primitive.ObjectID)
+
+	return key, refreshKeyring(true)
+}
+
+/*
+Retire a key that is not primary, tokens and data encrypted with it are not accepted anymore
+
+params: ctx context.Context Context of the DB operations
+
+purpose string model.KeyPurposeSigning or model.KeyPurposeEncryption
+
+kid string The key ID
+
+return: error The error if the key is primary, unknown or the DB operations fail
+*/
+func RetireKey(ctx context.Context, purpose, kid string) error {
+	if loadErr := refreshKeyring(true); loadErr != nil {
+		return loadErr
+	}
+
+	keyring.mutex.RLock()
+	primaryKid := keyring.primary[purpose]
+	_, exists := keyring.keys[purpose][kid]
+	keyring.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("unknown %s key %q", purpose, kid)
+	}
+	if kid == primaryKid {
+		return errors.New("the primary key cannot be retired, rotate first")
+	}
+
+	// The legacy key is not stored in DB, record its retirement
+	_, updateErr := keyringCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "purpose", Value: purpose},
+			{Key: "kid", Value: kid},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: model.KeyStatusRetired},
+				{Key: "retiredAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "createdAt", Value: time.Now().Unix()},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if updateErr != nil {
+		return updateErr
+	}
+
+	return refreshKeyring(true)
+}
+
+/*
+Get every key of the keyring without the key material, including the legacy keys from the environment
+
+params: ctx context.Context Context of the DB operations
+
+return: []model.KeyringKey The keys
+
+error The error of the DB query
+*/
+func ListKeys(ctx context.Context) ([]model.KeyringKey, error) {
+	var keys []model.KeyringKey
+	result, queryErr := keyringCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	decodeErr := result.All(ctx, &keys)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	if loadErr := refreshKeyring(true); loadErr != nil {
+		return nil, loadErr
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+	for _, purpose := range []string{model.KeyPurposeSigning, model.KeyPurposeEncryption} {
+		if legacyKey, exists := keyring.keys[purpose][model.LegacyKeyId]; exists {
+			keys = append(keys, model.KeyringKey{
+				Kid:     model.LegacyKeyId,
+				Purpose: purpose,
+				Status:  legacyKey.status,
+			})
+		}
+	}
+
+	return keys, nil
+}
+
+/*
+Load the keys from DB and the environment when they are older than keyringRefreshInterval
+
+params: force bool True to load even if the keys are recent
+
+return: error The error if the keys cannot be loaded
+*/
+func refreshKeyring(force bool) error {
+	keyring.mutex.RLock()
+	fresh := keyring.keys != nil && time.Since(keyring.loadedAt) < keyringRefreshInterval
+	keyring.mutex.RUnlock()
+	if fresh && !force {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var storedKeys []model.KeyringKey
+	result, queryErr := keyringCollection.Find(ctx, bson.M{})
+	if queryErr != nil {
+		return queryErr
+	}
+	decodeErr := result.All(ctx, &storedKeys)
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	keys := map[string]map[string]loadedKey{
+		model.KeyPurposeSigning:    {},
+		model.KeyPurposeEncryption: {},
+	}
+	primary := map[string]string{}
+	retiredLegacy := map[string]bool{}
+
+	for _, storedKey := range storedKeys {
+		if storedKey.Status == model.KeyStatusRetired {
+			if storedKey.Kid == model.LegacyKeyId {
+				retiredLegacy[storedKey.Purpose] = true
+			}
+			continue
+		}
+
+		secret, secretErr := decryptStoredSecret(storedKey.Secret)
+		if secretErr != nil {
+			log.Printf("[KEYRING] Cannot decrypt %s key %s: %v\n", storedKey.Purpose, storedKey.Kid, secretErr)
+			continue
+		}
+
+		if keys[storedKey.Purpose] == nil {
+			continue
+		}
+		keys[storedKey.Purpose][storedKey.Kid] = loadedKey{secret: secret, status: storedKey.Status}
+		if storedKey.Status == model.KeyStatusPrimary {
+			primary[storedKey.Purpose] = storedKey.Kid
+		}
+	}
+
+	// The keys from the environment stay accepted until retired, and are primary until the first rotation
+	legacySecrets := map[string]string{
+		model.KeyPurposeSigning:    os.Getenv("JWT_SECRET"),
+		model.KeyPurposeEncryption: os.Getenv("ENCRYPTION_KEY"),
+	}
+	for purpose, legacySecret := range legacySecrets {
+		if legacySecret == "" || retiredLegacy[purpose] {
+			continue
+		}
+
+		status := model.KeyStatusActive
+		if _, hasPrimary := primary[purpose]; !hasPrimary {
+			status = model.KeyStatusPrimary
+			primary[purpose] = model.LegacyKeyId
+		}
+		keys[purpose][model.LegacyKeyId] = loadedKey{secret: []byte(legacySecret), status: status}
+	}
+
+	keyring.mutex.Lock()
+	keyring.keys = keys
+	keyring.primary = primary
+	keyring.loadedAt = time.Now()
+	keyring.mutex.Unlock()
+
+	return nil
+}
+
+/*
+Decrypt the key material of a key stored in DB with the master key
+
+params: encryptedSecret string The key material encrypted and encoded as base64
+
+return: []byte The key material
+
+error The error if the master key is not set or the decryption fails
+*/
+func decryptStoredSecret(encryptedSecret string) ([]byte, error) {
+	masterKey, masterErr := keyringMasterKey()
+	if masterErr != nil {
+		return nil, masterErr
+	}
+
+	ciphertext, decodeErr := base64.StdEncoding.DecodeString(encryptedSecret)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	return open(ciphertext, masterKey)
+}
+
+/*
+Get the key that encrypts the key material stored in DB
+
+params: None
+
+return: []byte The SHA-256 of KEYRING_MASTER_KEY, usable as an AES-256 key
+
+error The error if KEYRING_MASTER_KEY is not set
+*/
+func keyringMasterKey() ([]byte, error) {
+	masterKey := os.Getenv("KEYRING_MASTER_KEY")
+	if masterKey == "" {
+		return nil, errors.New("KEYRING_MASTER_KEY is not set")
+	}
+
+	hashedMasterKey := sha256.Sum256([]byte(masterKey))
+	return hashedMasterKey[:], nil
+}
+
+/*
+Encrypt data with AES-GCM, the nonce is put before the ciphertext
+
+params: plaintext []byte The data to encrypt
+
+key []byte The AES key
+
+return: []byte The nonce and ciphertext
+
+error The error if the encryption fails
+*/
+func seal(plaintext, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aesGCM.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+/*
+Decrypt data encrypted by seal
+
+params: ciphertext []byte The nonce and ciphertext
+
+key []byte The AES key
+
+return: []byte The decrypted data
+
+error The error if the decryption fails
+*/
+func open(ciphertext, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aesGCM.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return aesGCM.Open(nil, nonce, ciphertext, nil)
+}
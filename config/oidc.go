@@ -0,0 +1,421 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// How long the discovery document and signing keys of the provider are cached
+var oidcRefreshInterval = time.Hour
+
+// Minimum time between two reloads of the signing keys for an unknown key ID
+var oidcMinRefreshInterval = 10 * time.Second
+
+// Allowed difference between the clocks of the provider and the server
+var oidcClockSkew = time.Minute
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Identity of the user returned by the provider in a verified ID token
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Cached discovery document and signing keys of the provider
+var oidcProvider = struct {
+	sync.RWMutex
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+	keys                  map[string]interface{}
+	loadedAt              time.Time
+	keysLoadedAt          time.Time
+}{}
+
+/*
+Check if single sign-on is configured with OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL
+
+params: None
+
+return: bool True if the provider is configured
+*/
+func OIDCEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != "" && os.Getenv("OIDC_CLIENT_ID") != "" && os.Getenv("OIDC_REDIRECT_URL") != ""
+}
+
+/*
+Build the URL of the provider to send the browser to, for the authorization code flow with PKCE
+
+params: ctx context.Context Context of the discovery request
+
+state string Random value returned to the callback, tying it to the browser
+
+nonce string Random value the provider puts in the ID token
+
+codeChallenge string Base64 URL encoded SHA-256 of the code verifier
+
+return: string The authorization URL
+
+error The error if the provider cannot be discovered
+*/
+func OIDCAuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discoveryErr := discoverOIDCProvider(ctx, false)
+	if discoveryErr != nil {
+		return "", discoveryErr
+	}
+
+	oidcProvider.RLock()
+	authorizationEndpoint := oidcProvider.authorizationEndpoint
+	oidcProvider.RUnlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {os.Getenv("OIDC_CLIENT_ID")},
+		"redirect_uri":          {os.Getenv("OIDC_REDIRECT_URL")},
+		"scope":                 {oidcScopes()},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return authorizationEndpoint + separator + query.Encode(), nil
+}
+
+/*
+Exchange an authorization code for tokens and verify the ID token
+
+params: ctx context.Context Context of the requests to the provider
+
+code string The authorization code from the callback
+
+codeVerifier string The PKCE code verifier of the login
+
+nonce string The nonce sent in the authorization URL
+
+return: OIDCIdentity The identity in the verified ID token
+
+error The error if the exchange fails or the ID token is invalid
+*/
+func OIDCExchangeCode(ctx context.Context, code, codeVerifier, nonce string) (OIDCIdentity, error) {
+	discoveryErr := discoverOIDCProvider(ctx, false)
+	if discoveryErr != nil {
+		return OIDCIdentity{}, discoveryErr
+	}
+
+	oidcProvider.RLock()
+	tokenEndpoint := oidcProvider.tokenEndpoint
+	oidcProvider.RUnlock()
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {os.Getenv("OIDC_REDIRECT_URL")},
+		"client_id":     {os.Getenv("OIDC_CLIENT_ID")},
+		"code_verifier": {codeVerifier},
+	}
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if requestErr != nil {
+		return OIDCIdentity{}, requestErr
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	// Confidential clients authenticate with their secret, public clients rely on PKCE alone
+	if clientSecret := os.Getenv("OIDC_CLIENT_SECRET"); clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(os.Getenv("OIDC_CLIENT_ID")), url.QueryEscape(clientSecret))
+	}
+
+	response, responseErr := oidcHTTPClient.Do(request)
+	if responseErr != nil {
+		return OIDCIdentity{}, responseErr
+	}
+	defer response.Body.Close()
+
+	var tokenResponse struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	decodeErr := json.NewDecoder(response.Body).Decode(&tokenResponse)
+	if decodeErr != nil {
+		return OIDCIdentity{}, fmt.Errorf("invalid token response: %w", decodeErr)
+	}
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return OIDCIdentity{}, fmt.Errorf("token request failed: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IdToken == "" {
+		return OIDCIdentity{}, errors.New("no ID token in token response")
+	}
+
+	return verifyOIDCIdToken(ctx, tokenResponse.IdToken, nonce)
+}
+
+/*
+Verify the signature and claims of an ID token
+
+params: ctx context.Context Context of the request reloading the signing keys
+
+idToken string The ID token
+
+nonce string The nonce sent in the authorization URL
+
+return: OIDCIdentity The identity in the ID token
+
+error The error if the ID token is invalid
+*/
+func verifyOIDCIdToken(ctx context.Context, idToken, nonce string) (OIDCIdentity, error) {
+	// The claims are checked below with an allowance for clock skew
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, parsingErr := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return lookupOIDCKey(ctx, kid)
+	})
+	if parsingErr != nil {
+		return OIDCIdentity{}, parsingErr
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return OIDCIdentity{}, errors.New("invalid ID token")
+	}
+
+	now := time.Now()
+	if !claims.VerifyIssuer(os.Getenv("OIDC_ISSUER"), true) {
+		return OIDCIdentity{}, errors.New("ID token issuer mismatch")
+	}
+	if !claims.VerifyAudience(os.Getenv("OIDC_CLIENT_ID"), true) {
+		return OIDCIdentity{}, errors.New("ID token audience mismatch")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-oidcClockSkew).Unix(), true) {
+		return OIDCIdentity{}, errors.New("ID token expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(oidcClockSkew).Unix(), false) || !claims.VerifyNotBefore(now.Add(oidcClockSkew).Unix(), false) {
+		return OIDCIdentity{}, errors.New("ID token not valid yet")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return OIDCIdentity{}, errors.New("ID token nonce mismatch")
+	}
+
+	identity := OIDCIdentity{Issuer: os.Getenv("OIDC_ISSUER")}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch emailVerified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = emailVerified
+	case string:
+		identity.EmailVerified = emailVerified == "true"
+	}
+	if identity.Subject == "" {
+		return OIDCIdentity{}, errors.New("ID token has no subject")
+	}
+
+	return identity, nil
+}
+
+/*
+Get the public key of the provider with a key ID, reloading the keys once if it is unknown
+
+params: ctx context.Context Context of the request reloading the keys
+
+kid string The key ID, empty if the provider has a single key
+
+return: interface{} The RSA or ECDSA public key
+
+error The error if the key is unknown
+*/
+func lookupOIDCKey(ctx context.Context, kid string) (interface{}, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		oidcProvider.RLock()
+		key, found := oidcProvider.keys[kid]
+		if !found && kid == "" && len(oidcProvider.keys) == 1 {
+			for _, onlyKey := range oidcProvider.keys {
+				key, found = onlyKey, true
+			}
+		}
+		recentlyLoaded := time.Since(oidcProvider.keysLoadedAt) < oidcMinRefreshInterval
+		oidcProvider.RUnlock()
+
+		if found {
+			return key, nil
+		}
+		// The provider may have rotated its keys since they were loaded
+		if attempt > 0 || recentlyLoaded {
+			break
+		}
+		if reloadErr := discoverOIDCProvider(ctx, true); reloadErr != nil {
+			return nil, reloadErr
+		}
+	}
+
+	return nil, fmt.Errorf("unknown ID token key: %s", kid)
+}
+
+/*
+Load the discovery document and signing keys of the provider when they are missing or stale
+
+params: ctx context.Context Context of the requests to the provider
+
+force bool Reload even if the cache is fresh
+
+return: error The error if the provider cannot be reached or returns invalid documents
+*/
+func discoverOIDCProvider(ctx context.Context, force bool) error {
+	oidcProvider.RLock()
+	fresh := oidcProvider.jwksURI != "" && time.Since(oidcProvider.loadedAt) < oidcRefreshInterval
+	oidcProvider.RUnlock()
+	if fresh && !force {
+		return nil
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksURI               string `json:"jwks_uri"`
+	}
+	discoveryErr := getOIDCDocument(ctx, issuer+"/.well-known/openid-configuration", &discovery)
+	if discoveryErr != nil {
+		return discoveryErr
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return fmt.Errorf("discovery issuer %q does not match OIDC_ISSUER", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return errors.New("incomplete discovery document")
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	jwksErr := getOIDCDocument(ctx, discovery.JwksURI, &jwks)
+	if jwksErr != nil {
+		return jwksErr
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			modulus, modulusErr := base64.RawURLEncoding.DecodeString(jwk.N)
+			exponent, exponentErr := base64.RawURLEncoding.DecodeString(jwk.E)
+			if modulusErr != nil || exponentErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(modulus),
+				E: int(new(big.Int).SetBytes(exponent).Int64()),
+			}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, knownCurve := curves[jwk.Crv]
+			x, xErr := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, yErr := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if !knownCurve || xErr != nil || yErr != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+
+	oidcProvider.Lock()
+	oidcProvider.authorizationEndpoint = discovery.AuthorizationEndpoint
+	oidcProvider.tokenEndpoint = discovery.TokenEndpoint
+	oidcProvider.jwksURI = discovery.JwksURI
+	oidcProvider.keys = keys
+	oidcProvider.loadedAt = time.Now()
+	oidcProvider.keysLoadedAt = time.Now()
+	oidcProvider.Unlock()
+
+	return nil
+}
+
+/*
+Get a JSON document from the provider
+
+params: ctx context.Context Context of the request
+
+documentURL string URL of the document
+
+document interface{} Pointer to decode the document to
+
+return: error The error if the request fails or the document is not valid JSON
+*/
+func getOIDCDocument(ctx context.Context, documentURL string, document interface{}) error {
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, documentURL, nil)
+	if requestErr != nil {
+		return requestErr
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, responseErr := oidcHTTPClient.Do(request)
+	if responseErr != nil {
+		return responseErr
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", documentURL, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(document)
+}
+
+/*
+Get the scopes to request from the provider, OIDC_SCOPES or "openid email profile"
+
+params: None
+
+return: string The scopes separated by spaces
+*/
+func oidcScopes() string {
+	if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+		return scopes
+	}
+	return "openid email profile"
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Marks an encrypted personal data field, the rest is the output of EncryptWithKeyring
+const piiPrefix = "pii:"
+
+// Personal data fields encrypted when PII_ENCRYPTED_FIELDS is not set
+var defaultPIIFields = []string{"email", "phone", "address"}
+
+var errPIIIndexKey = errors.New("PII_INDEX_KEY is not set, encrypted fields could not be looked up")
+
+/*
+Check if a personal data field is encrypted before it is stored, PII_ENCRYPTION=true turns encryption on
+
+params: field string The BSON name of the field, like "email"
+
+return: bool True if new values of the field are encrypted
+*/
+func PIIFieldEncrypted(field string) bool {
+	if os.Getenv("PII_ENCRYPTION") != "true" {
+		return false
+	}
+
+	fields := defaultPIIFields
+	if configured := strings.TrimSpace(os.Getenv("PII_ENCRYPTED_FIELDS")); configured != "" {
+		fields = strings.Split(configured, ",")
+	}
+	for _, encryptedField := range fields {
+		if strings.TrimSpace(encryptedField) == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Encrypt the value of a personal data field with the primary encryption key, if the field is configured to be encrypted
+
+params: field string The BSON name of the field
+
+value string The plaintext value
+
+return: string The value to store, unchanged if the field is not encrypted, empty or already encrypted
+
+error The error if the encryption fails
+*/
+func EncryptPII(field, value string) (string, error) {
+	if value == "" || IsEncryptedPII(value) || !PIIFieldEncrypted(field) {
+		return value, nil
+	}
+	if os.Getenv("PII_INDEX_KEY") == "" && (field == "email" || field == "phone") {
+		return "", errPIIIndexKey
+	}
+
+	encrypted, encryptErr := EncryptWithKeyring([]byte(value))
+	if encryptErr != nil {
+		return "", encryptErr
+	}
+
+	return piiPrefix + encrypted, nil
+}
+
+/*
+Decrypt the value of a personal data field, values stored before encryption was turned on are returned as they are
+
+params: value string The stored value
+
+return: string The plaintext value
+
+error The error if the key is retired or the decryption fails
+*/
+func DecryptPII(value string) (string, error) {
+	if !IsEncryptedPII(value) {
+		return value, nil
+	}
+
+	plaintext, decryptErr := DecryptWithKeyring(strings.TrimPrefix(value, piiPrefix))
+	if decryptErr != nil {
+		return "", decryptErr
+	}
+
+	return string(plaintext), nil
+}
+
+/*
+Check if a stored value was encrypted by EncryptPII
+
+params: value string The stored value
+
+return: bool True if the value is encrypted
+*/
+func IsEncryptedPII(value string) bool {
+	return strings.HasPrefix(value, piiPrefix)
+}
+
+/*
+Get the key ID of a value encrypted by EncryptPII
+
+params: value string The stored value
+
+return: string The key ID, empty if the value is not encrypted
+*/
+func PIIKeyId(value string) string {
+	if !IsEncryptedPII(value) {
+		return ""
+	}
+
+	return EncryptionKeyId(strings.TrimPrefix(value, piiPrefix))
+}
+
+/*
+Compute the blind index of a personal data value, an HMAC with PII_INDEX_KEY that finds exact matches without decrypting
+
+params: field string The BSON name of the field, so equal values of different fields do not share an index
+
+value string The plaintext value, emails are compared ignoring case and phones by their digits only
+
+return: string The index encoded as hex, empty if PII_INDEX_KEY is not set or the value is empty
+*/
+func BlindIndex(field, value string) string {
+	indexKey := os.Getenv("PII_INDEX_KEY")
+	normalized := normalizePII(field, value)
+	if indexKey == "" || normalized == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(indexKey))
+	mac.Write([]byte(field + ":" + normalized))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+Bring a personal data value to the form compared by its blind index
+
+params: field string The BSON name of the field
+
+value string The plaintext value
+
+return: string The normalized value
+*/
+func normalizePII(field, value string) string {
+	value = strings.TrimSpace(value)
+
+	switch field {
+	case "email":
+		return strings.ToLower(value)
+	case "phone":
+		return strings.Map(func(char rune) rune {
+			if unicode.IsDigit(char) {
+				return char
+			}
+			return -1
+		}, value)
+	}
+
+	return value
+}
@@ -20,21 +20,20 @@ func ConnectDB() *mongo.Client {
 		log.Fatal(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	//ping the database
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	return client
 }
 
 // Client instance
 var DB *mongo.Client = ConnectDB()
 
+// Ping the database once at startup, the clients above only connect on their first query
+func PingDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return DB.Ping(ctx, nil)
+}
+
 // getting database collections
 func GetCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 	dbname := EnvDBName()
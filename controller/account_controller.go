@@ -3,24 +3,18 @@ package controller
 import (
 	"backend/model"
 	"context"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func LoginHandler() gin.HandlerFunc {
@@ -42,6 +36,11 @@ func LoginHandler() gin.HandlerFunc {
 		loginCredentials.Username = strings.TrimSpace(loginCredentials.Username)
 		loginCredentials.Password = strings.TrimSpace(loginCredentials.Password)
 
+		// Refuse the attempt while the username or the IP is backing off or locked out
+		if !AllowLoginAttempt(ctx, c, loginCredentials.Username, model.AuditLogin) {
+			return
+		}
+
 		// Find account by username and join with authorizations collection
 		var account gin.H
 		pipeline := mongo.Pipeline{
@@ -63,9 +62,14 @@ func LoginHandler() gin.HandlerFunc {
 					{Key: "username", Value: 1},
 					{Key: "password", Value: 1},
 					{Key: "account_name", Value: 1},
+					{Key: "passwordChangedAt", Value: 1},
+					{Key: "createdAt", Value: 1},
 					{Key: "levelName", Value: bson.D{
 						{Key: "$arrayElemAt", Value: bson.A{"$levelName.levelName", 0}},
 					}},
+					{Key: "require_two_factor", Value: bson.D{
+						{Key: "$arrayElemAt", Value: bson.A{"$levelName.require_two_factor", 0}},
+					}},
 				}},
 			},
 		}
@@ -84,11 +88,15 @@ func LoginHandler() gin.HandlerFunc {
 
 		// If username does not exist
 		if account == nil {
+			// Spend the same time as a password check and answer like a wrong password, to not reveal which usernames exist
+			VerifyDummyPassword(loginCredentials.Password)
+			RecordLoginFailure(ctx, loginCredentials.Username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", "", gin.H{"username": loginCredentials.Username, "reason": "unknown_username"})
+
 			// Send response to the client
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "User account does not exist",
-				"field":   "email",
 				"success": false,
+				"message": "Incorrect username or password",
 			})
 		} else {
 			// Compare entered password with hashed password in database
@@ -96,45 +104,79 @@ func LoginHandler() gin.HandlerFunc {
 
 			// If passwords match
 			if comparePasswordSuccess {
-				// Create token with user ID and level name in payload
-				token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
-					"account_id": account["_id"].(primitive.ObjectID).Hex(),
-					"level":      account["levelName"].(string),
-					"exp":        time.Now().Add(time.Hour * 24).Unix(),
-				})
+				// Invited accounts log in once the invitation is accepted
+				pending, pendingErr := AccountPending(ctx, account["_id"].(primitive.ObjectID))
+				if pendingErr != nil {
+					c.JSON(http.StatusInternalServerError, "Error querying employee: "+pendingErr.Error())
+					return
+				}
+				if pending {
+					RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", account["_id"].(primitive.ObjectID).Hex(), gin.H{"username": loginCredentials.Username, "reason": "account_pending"})
+					c.JSON(http.StatusForbidden, gin.H{
+						"success": false,
+						"message": "Accept your invitation to activate the account",
+						"reason":  "account_pending",
+					})
+					return
+				}
+
+				// Ask for a new password first if the current one is older than the policy allows
+				policy, policyErr := LoadPasswordPolicy(ctx)
+				if policyErr != nil {
+					c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+					return
+				}
+				passwordChangedAt, _ := account["passwordChangedAt"].(int64)
+				createdAt, _ := account["createdAt"].(int64)
+				if PasswordExpired(policy, model.Account{PasswordChangedAt: passwordChangedAt, CreatedAt: createdAt}) {
+					challengeToken, challengeErr := CreateLoginChallenge(ctx, account["_id"].(primitive.ObjectID), model.LoginChallengePasswordChange)
+					if challengeErr != nil {
+						c.JSON(http.StatusInternalServerError, "Error creating login challenge: "+challengeErr.Error())
+						return
+					}
+
+					RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", account["_id"].(primitive.ObjectID).Hex(), gin.H{"username": loginCredentials.Username, "reason": "password_expired"})
 
-				// Create token with secret key
-				signedToken, signingErr := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
-				if signingErr != nil {
-					c.JSON(http.StatusInternalServerError, "Error signing token: "+signingErr.Error())
+					// Send the challenge to client, the login continues on /login/change-password
+					c.JSON(http.StatusAccepted, gin.H{
+						"success":         false,
+						"message":         "Password expired, a new password is required",
+						"passwordExpired": true,
+						"challengeToken":  challengeToken,
+					})
 					return
 				}
 
-				// Encrypt the token with encryption key
-				var encryptedToken, encryptErr = Encrypt([]byte(signedToken), []byte(os.Getenv("ENCRYPTION_KEY")))
-				if encryptErr != nil {
-					c.JSON(http.StatusInternalServerError, "Error encrypting token: "+encryptErr.Error())
+				// Ask for the second factor before creating a session
+				requireTwoFactor, _ := account["require_two_factor"].(bool)
+				challengeKind := GetLoginChallengeKind(ctx, account["_id"].(primitive.ObjectID), requireTwoFactor)
+				if challengeKind != "" {
+					challengeToken, challengeErr := CreateLoginChallenge(ctx, account["_id"].(primitive.ObjectID), challengeKind)
+					if challengeErr != nil {
+						c.JSON(http.StatusInternalServerError, "Error creating login challenge: "+challengeErr.Error())
+						return
+					}
+
+					// Send the challenge to client, the login continues on /login/two-factor
+					c.JSON(http.StatusAccepted, gin.H{
+						"success":        false,
+						"message":        "Two-factor authentication required",
+						"twoFactor":      challengeKind,
+						"challengeToken": challengeToken,
+					})
 					return
 				}
 
-				// Encode the encrypted token to base64 and to URL safe format
-				encodedBase64 := base64.StdEncoding.EncodeToString([]byte(encryptedToken))
-				encodedToken := url.QueryEscape(encodedBase64)
-
-				// Create an HTTP-only cookie
-				cookie := &http.Cookie{
-					Name:     "access_token",
-					Value:    encodedToken,
-					Expires:  time.Now().Add(24 * time.Hour), // Adjust the expiration time as needed
-					HttpOnly: true,
-					Secure:   false,                // Set to true to ensure the cookie is sent only over HTTPS
-					SameSite: http.SameSiteLaxMode, // Set the SameSite attribute for CSRF protection
-					Path:     "/",                  // Set the path attribute to restrict the cookie to a specific path
-					//removed cause unecessary..?
+				// Create a session and send the token cookies to client
+				sessionErr := CreateSession(ctx, c, account["_id"].(primitive.ObjectID), account["levelName"].(string))
+				if sessionErr != nil {
+					c.JSON(http.StatusInternalServerError, "Error creating session: "+sessionErr.Error())
+					return
 				}
 
-				// Send the cookie to client
-				http.SetCookie(c.Writer, cookie)
+				// The attempts are forgotten only once the login completes, a second factor is still counted on the username
+				ResetLoginFailures(ctx, loginCredentials.Username, c.ClientIP())
+				RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeSuccess, "account", account["_id"].(primitive.ObjectID).Hex(), gin.H{"username": loginCredentials.Username})
 
 				// Send the response to client
 				c.JSON(http.StatusOK, gin.H{
@@ -144,11 +186,14 @@ func LoginHandler() gin.HandlerFunc {
 				})
 				return
 			} else {
+				RecordLoginFailure(ctx, loginCredentials.Username, c.ClientIP())
+				RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", account["_id"].(primitive.ObjectID).Hex(), gin.H{"username": loginCredentials.Username, "reason": "wrong_password"})
+				go CheckLoginFailures(c.Copy(), account["_id"].(primitive.ObjectID))
+
 				// Send response to the client for incrorrect username or password
 				c.JSON(http.StatusUnauthorized, gin.H{
 					"success": false,
-					"message": "Incorrect password",
-					"field":   "password",
+					"message": "Incorrect username or password",
 				})
 			}
 		}
@@ -157,20 +202,32 @@ func LoginHandler() gin.HandlerFunc {
 
 func LogOutHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Create an HTTP-only cookie
-		cookie := &http.Cookie{
-			Name:     "access_token",
-			Value:    "",
-			Expires:  time.Now().Add(-time.Hour), // Adjust the expiration time as needed
-			HttpOnly: true,
-			Secure:   false,                   // Set to true to ensure the cookie is sent only over HTTPS
-			SameSite: http.SameSiteStrictMode, // Set the SameSite attribute for CSRF protection
-			Path:     "/",                     // Set the path attribute to restrict the cookie to a specific path
-			Domain:   "",                      // Set the domain attribute to restrict the cookie to a specific domain
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Revoke every session of the logged in account
+		currentAccount := c.MustGet("currentAccount").(gin.H)
+		if _, impersonating := c.Get("impersonator"); impersonating {
+			// An impersonation only ends its own session, the sessions of the account belong to its owner
+			if currentSession, exists := c.Get("currentSession"); exists {
+				_, revokeErr := revokeSessions(ctx, bson.D{{Key: "_id", Value: currentSession.(primitive.ObjectID)}})
+				if revokeErr != nil {
+					c.JSON(http.StatusInternalServerError, "Error revoking session: "+revokeErr.Error())
+					return
+				}
+			}
+		} else {
+			revokeErr := RevokeAccountSessions(ctx, currentAccount["account_id"].(primitive.ObjectID))
+			if revokeErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+				return
+			}
 		}
 
-		// Send the cookie to client
-		http.SetCookie(c.Writer, cookie)
+		RecordAuditEvent(c, model.AuditLogout, model.AuditOutcomeSuccess, "account", currentAccount["account_id"].(primitive.ObjectID).Hex(), nil)
+
+		// Remove the token cookies from client
+		ClearTokenCookies(c)
 
 		// Send the response to client
 		c.JSON(http.StatusOK, gin.H{
@@ -180,16 +237,23 @@ func LogOutHandler() gin.HandlerFunc {
 	}
 }
 
+type accountAdd_struct struct {
+	Username                 string             `json:"username"`
+	Account_Name             string             `json:"Account_Name"`
+	Account_Authorization_Id primitive.ObjectID `json:"Account_Authorization_Id"`
+	Email                    string             `json:"email"`
+}
+
 func AccountAdd() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
 		defer cancel()
 
-		// Create an instace of the Account model
-		var account model.Account
+		// Create an instace of the request struct
+		var request accountAdd_struct
 
-		// Bind the request body to the project model
-		bindingErr := c.BindJSON(&account)
+		// Bind the request body to the request struct
+		bindingErr := c.BindJSON(&request)
 		if bindingErr != nil {
 			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
 			return
@@ -197,7 +261,7 @@ func AccountAdd() gin.HandlerFunc {
 
 		// Check if the authorization level is valid
 		var authorization model.Authorization
-		authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": account.Account_Authorization_Id}).Decode(&authorization)
+		authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": request.Account_Authorization_Id}).Decode(&authorization)
 		if authorizationQueryErr != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
@@ -206,26 +270,36 @@ func AccountAdd() gin.HandlerFunc {
 			return
 		}
 
-		// Trim the received username, password, and account name
-		account.Username = strings.TrimSpace(account.Username)
-		account.Password = strings.TrimSpace(account.Password)
-		account.Account_Name = strings.TrimSpace(account.Account_Name)
+		// Trim the received username, account name and email
+		account := model.Account{
+			Username:                 strings.TrimSpace(request.Username),
+			Account_Name:             strings.TrimSpace(request.Account_Name),
+			Account_Authorization_Id: request.Account_Authorization_Id,
+		}
+		email := strings.TrimSpace(request.Email)
+		if email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Email is required",
+			})
+			return
+		}
 
 		// Check if the username already exists in database
 		var existingAccount model.Account
 		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"username": account.Username}).Decode(&existingAccount)
 		// If username does not exist
 		if accountQueryErr != nil {
-			// Hash the password
-			hashedPassword, hashingErr := bcrypt.GenerateFromPassword([]byte(account.Password), 14)
-			if hashingErr != nil {
-				c.JSON(http.StatusInternalServerError, "Error hashing password: "+hashingErr.Error())
+			// The invitee sets the password, the generated one is never sent
+			hashedPassword, _ := GenerateAndHashPassword()
+			if hashedPassword == "" {
+				c.JSON(http.StatusInternalServerError, "Error generating password")
 				return
 			}
 
 			// Add Id, password and timestamp for the account object
 			account.Id = primitive.NewObjectID()
-			account.Password = string(hashedPassword)
+			account.Password = hashedPassword
 			account.CreatedAt = time.Now().Unix()
 			account.UpdatedAt = time.Now().Unix()
 
@@ -241,10 +315,17 @@ func AccountAdd() gin.HandlerFunc {
 				userInfor := model.UserInfor{
 					Id:            primitive.NewObjectID(),
 					Profile_Image: "profile_image_default.jpg",
+					Email:         email,
+					CreatedAt:     time.Now().Unix(),
+					UpdatedAt:     time.Now().Unix(),
 				}
 
-				// Insert the default user information values into database
-				userInforInsertResult, userInforInsertErr := userInforCollection.InsertOne(ctx, userInfor)
+				// Insert the default user information values into database, with the personal data encrypted
+				userInforInsertErr := ProtectUserInfor(&userInfor)
+				var userInforInsertResult *mongo.InsertOneResult
+				if userInforInsertErr == nil {
+					userInforInsertResult, userInforInsertErr = userInforCollection.InsertOne(ctx, userInfor)
+				}
 				if userInforInsertErr != nil {
 					// If error during inserting user information, delete the account just created
 					accountDeleteResult, accountDeleteErr := accountCollection.DeleteOne(ctx, bson.M{"_id": accountInsertResult.InsertedID})
@@ -262,7 +343,7 @@ func AccountAdd() gin.HandlerFunc {
 					// Create the employee object
 					employee := model.Employee{
 						Id:          primitive.NewObjectID(),
-						State:       0,
+						State:       model.EmployeeStatePending,
 						AccountID:   account.Id,
 						UserInforId: userInfor.Id,
 						CreatedAt:   time.Now().Unix(),
@@ -293,12 +374,22 @@ func AccountAdd() gin.HandlerFunc {
 						return
 					} else {
 						fmt.Println("Inserted employee into Database with ID:", employeeInsertResult.InsertedID)
+
+						// Send invitation to the owner of the account
+						invitationErr := CreateInvitation(ctx, account.Id, employee.Id, email, account.Account_Name, CurrentEmployeeId(c))
+						if invitationErr != nil {
+							c.JSON(http.StatusInternalServerError, "Error sending emails: "+invitationErr.Error())
+							return
+						}
+
+						RecordAuditEvent(c, model.AuditAccountCreate, model.AuditOutcomeSuccess, "account", account.Id.Hex(), gin.H{"username": account.Username, "authorization": account.Account_Authorization_Id.Hex()})
+
 						c.JSON(http.StatusCreated, gin.H{
 							"success":   true,
 							"account":   accountInsertResult.InsertedID,
 							"userInfor": userInforInsertResult.InsertedID,
 							"employee":  employeeInsertResult.InsertedID,
-							"message":   "Account created",
+							"message":   "Account created, invitation sent",
 						})
 					}
 				}
@@ -317,7 +408,6 @@ func AccountAdd() gin.HandlerFunc {
 type accountWithRoleName_struct struct {
 	Id                       primitive.ObjectID `bson:"_id,omitempty"`
 	Username                 string             `bson:"username,omitempty"`
-	Password                 string             `bson:"password"`
 	Account_Name             string             `bson:"account_name"`
 	Role_Name                string             `bson:"role_name"`
 	Account_Authorization_Id primitive.ObjectID `bson:"account_authorization_id,omitempty"`
@@ -383,7 +473,7 @@ func AccountGetAll() gin.HandlerFunc {
 		// 	accounts = append(accounts, singleAccount)
 		// }
 
-		c.JSON(http.StatusOK, accounts)
+		c.JSON(http.StatusOK, Redact(c, accounts, model.Account{}))
 	}
 }
 
@@ -394,9 +484,11 @@ func AccountDeleteAll() gin.HandlerFunc {
 
 		err := accountCollection.Drop(ctx)
 		if err != nil {
+			RecordAuditEvent(c, model.AuditAccountDeleteAll, model.AuditOutcomeFailure, "account", "", gin.H{"error": err.Error()})
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		} else {
+			RecordAuditEvent(c, model.AuditAccountDeleteAll, model.AuditOutcomeSuccess, "account", "", nil)
 			c.JSON(http.StatusOK, "Delete Account Collection  successful!!!")
 			c.JSON(http.StatusCreated, gin.H{
 				"state": "success",
@@ -423,9 +515,11 @@ func AccountDeleteOne() gin.HandlerFunc {
 		id, _ := primitive.ObjectIDFromHex(c.Param("id"))
 		filter := bson.D{{Key: "_id", Value: id}}
 		if DeleteAccountResult1, err1 := accountCollection.DeleteOne(ctx, filter, opts); err1 != nil {
+			RecordAuditEvent(c, model.AuditAccountDelete, model.AuditOutcomeFailure, "account", c.Param("id"), gin.H{"error": err1.Error()})
 			c.JSON(http.StatusInternalServerError, "Error deleting account"+err1.Error())
 			return
 		} else {
+			RecordAuditEvent(c, model.AuditAccountDelete, model.AuditOutcomeSuccess, "account", c.Param("id"), gin.H{"deleted": DeleteAccountResult1.DeletedCount})
 			c.JSON(http.StatusOK, gin.H{
 				"message": "deleted account successfully",
 			})
@@ -460,26 +554,60 @@ func AccountUpdate() gin.HandlerFunc {
 			return
 		}
 		fmt.Println("Get Username to update:", getAccountUpdate.Username)
-		fmt.Println("Get Password to update:", getAccountUpdate.Password)
 		fmt.Println("Get Authorization to update:", getAccountUpdate.Account_Authorization_Id)
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(getAccountUpdate.Password), bcrypt.DefaultCost)
-		if err != nil {
-			// Handle error
-			return
+		// A new password follows the password policy like any other, leave it empty to keep the current one
+		if getAccountUpdate.Password != "" {
+			var currentAccount model.Account
+			accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&currentAccount)
+			if accountQueryErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "Invalid account Id",
+				})
+				return
+			}
+
+			policy, violations, policyErr := ValidateNewPassword(ctx, getAccountUpdate.Password, currentAccount)
+			if policyErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+				return
+			}
+			if len(violations) > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success":    false,
+					"message":    "*New password does not meet requirements",
+					"violations": violations,
+				})
+				return
+			}
+
+			hashedPasswordHex, hashingErr := HashPassword(getAccountUpdate.Password)
+			if hashingErr != nil {
+				c.JSON(http.StatusInternalServerError, "Failed to hash password")
+				return
+			}
+
+			setErr := SetAccountPassword(ctx, currentAccount, hashedPasswordHex, policy)
+			if setErr != nil {
+				c.JSON(http.StatusInternalServerError, "Failed to update password: "+setErr.Error())
+				return
+			}
 		}
+
 		filter := bson.D{{Key: "_id", Value: id}}
 		update := bson.D{{Key: "$set", Value: bson.D{
 			{Key: "username", Value: getAccountUpdate.Username},
-			{Key: "password", Value: string(hashedPassword)},
 			{Key: "account_name", Value: getAccountUpdate.Account_Name},
 			{Key: "account_authorization_id", Value: getAccountUpdate.Account_Authorization_Id},
 		}}} // "password", "asdfadfafs",
 
 		if err1 := accountCollection.FindOneAndUpdate(ctx, filter, update).Decode(&accountUpdate); err1 != nil {
+			RecordAuditEvent(c, model.AuditAccountUpdate, model.AuditOutcomeFailure, "account", GetAccountID, gin.H{"error": err1.Error()})
 			c.JSON(http.StatusInternalServerError, "Error updating project"+err1.Error())
 			return
 		} else {
+			RecordAuditEvent(c, model.AuditAccountUpdate, model.AuditOutcomeSuccess, "account", GetAccountID, gin.H{"username": getAccountUpdate.Username, "authorization": getAccountUpdate.Account_Authorization_Id.Hex()})
 			c.JSON(http.StatusOK, gin.H{
 				"message": "Updated account successfully",
 			})
@@ -491,11 +619,19 @@ func IsAuthorized() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		currentAccount := c.MustGet("currentAccount").(gin.H)
 		if currentAccount != nil && currentAccount["authorization"] != nil && currentAccount["authorization"].(string) != "" {
-			c.JSON(http.StatusOK, gin.H{
+			response := gin.H{
 				"success":     true,
 				"message":     "Authorized",
-				"currentUser": currentAccount,
-			})
+				"currentUser": Redact(c, currentAccount, model.Employee{}),
+			}
+			// Let the client show that an admin is acting as the account
+			if impersonator, impersonating := c.Get("impersonator"); impersonating {
+				response["impersonator"] = gin.H{
+					"account_id": impersonator.(gin.H)["account_id"],
+					"username":   impersonator.(gin.H)["username"],
+				}
+			}
+			c.JSON(http.StatusOK, response)
 			return
 		} else {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -521,61 +657,14 @@ func ResetPassword() gin.HandlerFunc {
 			return
 		}
 
-		var account gin.H
-		pipeline := mongo.Pipeline{
-			bson.D{
-				{Key: "$match", Value: bson.D{
-					{Key: "account_id", Value: accountId},
-				}},
-			},
-			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "user_infor"},
-					{Key: "localField", Value: "userinfor_id"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "userinfor"},
-				}},
-			},
-			bson.D{
-				{Key: "$project", Value: bson.D{
-					{Key: "fullname", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.fullname", 0}},
-					}},
-					{Key: "email", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.email", 0}},
-					}},
-				}},
-			},
-		}
-		accountQueryResult, aggregateErr := employeeCollection.Aggregate(ctx, pipeline)
-		if aggregateErr != nil {
-			fmt.Print(aggregateErr.Error())
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"message": "Unauthorized",
-			})
-			c.Abort()
-			return
-		}
-		if accountQueryResult.Next(ctx) {
-			decodeErr := accountQueryResult.Decode(&account)
-			if decodeErr != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"success": false,
-					"message": "Unauthorized",
-				})
-				c.Abort()
-				return
-			}
-		}
-
-		var password, unhashedPassword = GenerateAndHashPassword()
+		// Replace the password with a random one nobody knows, the owner sets a new one with the emailed link
+		var password, _ = GenerateAndHashPassword()
 		if password == "" {
 			c.JSON(http.StatusInternalServerError, "Error generating password")
 			return
 		}
 
-		accountCollection.FindOneAndUpdate(
+		updateResult := accountCollection.FindOneAndUpdate(
 			ctx,
 			bson.D{{Key: "_id", Value: accountId}},
 			bson.D{
@@ -585,13 +674,29 @@ func ResetPassword() gin.HandlerFunc {
 				}},
 			},
 		)
+		if updateResult.Err() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid account Id",
+			})
+			return
+		}
+
+		// Log the account out everywhere, the old password must not keep sessions alive
+		revokeErr := RevokeAccountSessions(ctx, accountId)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
 
-		sendEmailSuccess := SendEmailResetPassword(account["email"].(string), account["fullname"].(string), unhashedPassword)
-		if !sendEmailSuccess {
-			c.JSON(http.StatusInternalServerError, "Error sending emails")
+		resetErr := StartPasswordReset(ctx, accountId)
+		if resetErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error sending emails: "+resetErr.Error())
 			return
 		}
 
+		RecordAuditEvent(c, model.AuditAccountPasswordReset, model.AuditOutcomeSuccess, "account", accountString, nil)
+
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"message": "Account password reset",
@@ -613,6 +718,17 @@ func ChangePassword() gin.HandlerFunc {
 			return
 		}
 
+		// Only the logged in account changes its own password, others would guess passwords through the old password check
+		currentAccount := c.MustGet("currentAccount").(gin.H)
+		if currentAccount["account_id"].(primitive.ObjectID) != accountId {
+			RecordAuditEvent(c, model.AuditPasswordChange, model.AuditOutcomeFailure, "account", accountString, gin.H{"reason": "other_account"})
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Cannot change the password of another account",
+			})
+			return
+		}
+
 		var account model.Account
 
 		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
@@ -642,57 +758,70 @@ func ChangePassword() gin.HandlerFunc {
 			dataMap[key] = value
 		}
 
-		var oldPW = dataMap["old_password"].(string)
-		var newPW = dataMap["new_password"].(string)
-		var renewPW = dataMap["renew_password"].(string)
-		var hashedPasswordHex string
-		var updatePasswordResult *mongo.SingleResult
+		oldPW, _ := dataMap["old_password"].(string)
+		newPW, _ := dataMap["new_password"].(string)
+		renewPW, _ := dataMap["renew_password"].(string)
 
-		if (oldPW != "" && newPW != "" && renewPW != "") && (newPW == renewPW) {
-			// Check if the old password is correct
-			if VerifyPassword(oldPW, account.Password) {
-				if CheckPassword(newPW) {
-					combined := os.Getenv("PEPPER1") + newPW + os.Getenv("PEPPER2")
+		if oldPW == "" || newPW == "" || newPW != renewPW {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "*Passwords do not match",
+			})
+			return
+		}
 
-					// Hash the generated password
-					hashedPassword, errhashedPassword := bcrypt.GenerateFromPassword([]byte(combined), 15)
-					if errhashedPassword != nil {
-						c.JSON(http.StatusInternalServerError, "Failed to hash password")
-						return
-					}
+		// Check if the old password is correct, guesses count like failed logins
+		if !AllowLoginAttempt(ctx, c, account.Username, model.AuditPasswordChange) {
+			return
+		}
+		if !VerifyPassword(oldPW, account.Password) {
+			RecordLoginFailure(ctx, account.Username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditPasswordChange, model.AuditOutcomeFailure, "account", accountString, gin.H{"reason": "wrong_password"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "*Incorrect password",
+			})
+			return
+		}
 
-					hashedPasswordHex = hex.EncodeToString(hashedPassword)
-
-					updatePasswordResult = accountCollection.FindOneAndUpdate(
-						ctx,
-						bson.D{{Key: "_id", Value: accountId}},
-						bson.D{
-							{Key: "$set", Value: bson.D{
-								{Key: "password", Value: hashedPasswordHex},
-								{Key: "updatedAt", Value: time.Now().Unix()},
-							}},
-						},
-					)
-				} else {
-					c.JSON(http.StatusBadRequest, gin.H{
-						"success": false,
-						"message": "*New password does not meet requirements",
-					})
-					return
-				}
-			} else {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"success": false,
-					"message": "*Incorrect password",
-				})
-				return
-			}
+		ResetLoginFailures(ctx, account.Username, c.ClientIP())
+
+		policy, violations, policyErr := ValidateNewPassword(ctx, newPW, account)
+		if policyErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+			return
+		}
+		if len(violations) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":    false,
+				"message":    "*New password does not meet requirements",
+				"violations": violations,
+			})
+			return
+		}
+
+		// Hash the new password
+		hashedPasswordHex, errhashedPassword := HashPassword(newPW)
+		if errhashedPassword != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to hash password")
+			return
 		}
 
-		if updatePasswordResult.Err() != nil {
+		updatePasswordErr := SetAccountPassword(ctx, account, hashedPasswordHex, policy)
+		if updatePasswordErr != nil {
 			c.JSON(http.StatusInternalServerError, "Failed to update password")
 			return
 		} else {
+			// Log the account out everywhere after the password changed
+			revokeErr := RevokeAccountSessions(ctx, accountId)
+			if revokeErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+				return
+			}
+			ClearTokenCookies(c)
+
+			RecordAuditEvent(c, model.AuditPasswordChange, model.AuditOutcomeSuccess, "account", accountString, nil)
+
 			c.JSON(http.StatusOK, gin.H{
 				"success": true,
 				"message": "Password updated",
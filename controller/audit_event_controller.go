@@ -0,0 +1,312 @@
+/*
+Controller for handling data with AuditEvent model in DB
+
+1. GetAuditEvents: Get a page of audit events matching the filters
+
+2. ExportAuditEvents: Download every audit event matching the filters as CSV or NDJSON
+
+3. RecordAuditEvent: Record an action in the audit log
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Get a page of audit events, newest first
+
+Query parameters: action, outcome, actor, impersonator, target_type, target_id, ip, from and to (unix time), page (from 1) and limit (up to 200)
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the audit events
+*/
+func GetAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		filter, filterErr := auditEventFilter(c)
+		if filterErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": filterErr.Error(),
+			})
+			return
+		}
+
+		page, _ := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+		if page < 1 {
+			page = 1
+		}
+		limit, _ := strconv.ParseInt(c.DefaultQuery("limit", "50"), 10, 64)
+		if limit < 1 || limit > 200 {
+			limit = 50
+		}
+
+		total, countErr := auditEventCollection.CountDocuments(ctx, filter)
+		if countErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error counting audit events: "+countErr.Error())
+			return
+		}
+
+		// Create an array of the AuditEvent model
+		var events []model.AuditEvent
+
+		result, queryErr := auditEventCollection.Find(
+			ctx,
+			filter,
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).SetSkip((page-1)*limit).SetLimit(limit),
+		)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying audit events: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the events array
+		decodeErr := result.All(ctx, &events)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding audit events: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"total":   total,
+			"page":    page,
+			"limit":   limit,
+			"events":  Redact(c, events, model.AuditEvent{}),
+		})
+	}
+}
+
+/*
+Download every audit event matching the filters of GetAuditEvents, oldest first
+
+Query parameter format: csv (default) or ndjson
+
+params: None
+
+return: gin.HandlerFunc Handler function to export the audit events
+*/
+func ExportAuditEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		filter, filterErr := auditEventFilter(c)
+		if filterErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": filterErr.Error(),
+			})
+			return
+		}
+
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "ndjson" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid format",
+			})
+			return
+		}
+
+		cursor, queryErr := auditEventCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying audit events: "+queryErr.Error())
+			return
+		}
+		defer cursor.Close(ctx)
+
+		// Stream the events, an export can be larger than what fits in memory
+		fileName := "audit-events-" + time.Now().Format("20060102-150405") + "." + format
+		c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+
+		var csvWriter *csv.Writer
+		if format == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			csvWriter = csv.NewWriter(c.Writer)
+			_ = csvWriter.Write([]string{"id", "time", "action", "outcome", "actor_account_id", "actor_username", "impersonator_account_id", "impersonator_username", "target_type", "target_id", "ip", "user_agent", "details"})
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+		}
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for cursor.Next(ctx) {
+			var event model.AuditEvent
+			if decodeErr := cursor.Decode(&event); decodeErr != nil {
+				log.Println("[AUDIT] Error decoding audit event: " + decodeErr.Error())
+				continue
+			}
+
+			if format == "ndjson" {
+				_ = encoder.Encode(event)
+				continue
+			}
+
+			actorAccountId := ""
+			if !event.ActorAccountId.IsZero() {
+				actorAccountId = event.ActorAccountId.Hex()
+			}
+			impersonatorId := ""
+			if !event.ImpersonatorId.IsZero() {
+				impersonatorId = event.ImpersonatorId.Hex()
+			}
+			details, _ := json.Marshal(event.Details)
+			record := []string{
+				event.Id.Hex(),
+				time.Unix(event.CreatedAt, 0).UTC().Format(time.RFC3339),
+				event.Action,
+				event.Outcome,
+				actorAccountId,
+				event.ActorUsername,
+				impersonatorId,
+				event.Impersonator,
+				event.TargetType,
+				event.TargetId,
+				event.IP,
+				event.UserAgent,
+				string(details),
+			}
+			for i := range record {
+				record[i] = csvCell(record[i])
+			}
+			_ = csvWriter.Write(record)
+		}
+
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+	}
+}
+
+/*
+Make a CSV cell that spreadsheets show as text, values like usernames and user agents are chosen by the client and must not run as formulas
+
+params: value string The value of the cell
+
+return: string The value, with a quote first if it starts like a formula
+*/
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+/*
+Record an action in the audit log, with the logged in account as actor and the IP and user agent of the request, a failure to record is logged and does not fail the request
+
+params: c *gin.Context Context of the request
+
+action string One of the model.Audit actions
+
+outcome string model.AuditOutcomeSuccess or model.AuditOutcomeFailure
+
+targetType string Type of the object acted on, like "account"
+
+targetId string ID of the object acted on
+
+details gin.H Additional information, nil if none
+*/
+func RecordAuditEvent(c *gin.Context, action, outcome, targetType, targetId string, details gin.H) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+	defer cancel()
+
+	event := model.AuditEvent{
+		Id:         primitive.NewObjectID(),
+		Action:     action,
+		Outcome:    outcome,
+		TargetType: targetType,
+		TargetId:   targetId,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Details:    details,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	if currentAccount, exists := c.Get("currentAccount"); exists && currentAccount != nil {
+		event.ActorAccountId, _ = currentAccount.(gin.H)["account_id"].(primitive.ObjectID)
+		event.ActorUsername, _ = currentAccount.(gin.H)["username"].(string)
+	}
+	// Tag actions taken by an admin acting as the account
+	if impersonator, exists := c.Get("impersonator"); exists {
+		event.ImpersonatorId, _ = impersonator.(gin.H)["account_id"].(primitive.ObjectID)
+		event.Impersonator, _ = impersonator.(gin.H)["username"].(string)
+	}
+	if accessTokenId, exists := c.Get("currentAccessToken"); exists {
+		if event.Details == nil {
+			event.Details = gin.H{}
+		}
+		event.Details["access_token_id"] = accessTokenId
+	}
+
+	_, insertErr := auditEventCollection.InsertOne(ctx, event)
+	if insertErr != nil {
+		log.Println("[AUDIT] Error recording " + action + ": " + insertErr.Error())
+	}
+}
+
+/*
+Build the DB filter of the audit event query parameters
+
+params: c *gin.Context Context of the request
+
+return: bson.D The filter
+
+error The error if a parameter is invalid
+*/
+func auditEventFilter(c *gin.Context) (bson.D, error) {
+	filter := bson.D{}
+
+	for _, field := range []string{"action", "outcome", "target_type", "target_id", "ip"} {
+		if value := c.Query(field); value != "" {
+			filter = append(filter, bson.E{Key: field, Value: value})
+		}
+	}
+
+	for parameter, field := range map[string]string{"actor": "actor_account_id", "impersonator": "impersonator_account_id"} {
+		if value := c.Query(parameter); value != "" {
+			accountId, convertErr := primitive.ObjectIDFromHex(value)
+			if convertErr != nil {
+				return nil, fmt.Errorf("invalid %s", parameter)
+			}
+			filter = append(filter, bson.E{Key: field, Value: accountId})
+		}
+	}
+
+	createdAt := bson.D{}
+	for parameter, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if value := c.Query(parameter); value != "" {
+			unixTime, convertErr := strconv.ParseInt(value, 10, 64)
+			if convertErr != nil {
+				return nil, fmt.Errorf("invalid %s", parameter)
+			}
+			createdAt = append(createdAt, bson.E{Key: operator, Value: unixTime})
+		}
+	}
+	if len(createdAt) > 0 {
+		filter = append(filter, bson.E{Key: "createdAt", Value: createdAt})
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"testing"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"jdoe", "jdoe"},
+		{"Mozilla/5.0 (X11; Linux x86_64)", "Mozilla/5.0 (X11; Linux x86_64)"},
+		{`{"reason":"=1+1"}`, `{"reason":"=1+1"}`},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+	}
+
+	for _, test := range tests {
+		if got := csvCell(test.value); got != test.want {
+			t.Errorf("csvCell(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
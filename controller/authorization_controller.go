@@ -16,8 +16,11 @@ import (
 )
 
 type data_struct struct {
-	LevelName   string `json:"levelName"`
-	Description string `json:"description"`
+	LevelName        string   `json:"levelName"`
+	Description      string   `json:"description"`
+	Permissions      []string `json:"permissions"`
+	RequireTwoFactor bool     `json:"require_two_factor"`
+	AllowMagicLink   bool     `json:"allow_magic_link"`
 }
 
 func AuthorizationAdd() gin.HandlerFunc {
@@ -40,12 +43,28 @@ func AuthorizationAdd() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
 		defer cancel()
 
+		// Reject permissions that do not exist
+		if unknownPermissions := FindUnknownPermissions(jsonData.Permissions); len(unknownPermissions) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":     false,
+				"message":     "Unknown permissions",
+				"permissions": unknownPermissions,
+			})
+			return
+		}
+		if jsonData.Permissions == nil {
+			jsonData.Permissions = []string{}
+		}
+
 		newAuthorization := model.Authorization{
-			Id:          primitive.NewObjectID(),
-			LevelName:   jsonData.LevelName,
-			Description: jsonData.Description,
-			CreatedAt:   time.Now().Unix(),
-			UpdatedAt:   time.Now().Unix(),
+			Id:               primitive.NewObjectID(),
+			LevelName:        jsonData.LevelName,
+			Description:      jsonData.Description,
+			Permissions:      jsonData.Permissions,
+			RequireTwoFactor: jsonData.RequireTwoFactor,
+			AllowMagicLink:   jsonData.AllowMagicLink,
+			CreatedAt:        time.Now().Unix(),
+			UpdatedAt:        time.Now().Unix(),
 		}
 
 		var authorizationModel model.Authorization
@@ -61,6 +80,7 @@ func AuthorizationAdd() gin.HandlerFunc {
 			} else {
 				fmt.Println("Insert Authorization Successful ID:", result)
 			}
+			RecordAuditEvent(c, model.AuditAuthorizationCreate, model.AuditOutcomeSuccess, "authorization", newAuthorization.Id.Hex(), gin.H{"levelName": newAuthorization.LevelName, "permissions": newAuthorization.Permissions})
 
 			c.JSON(http.StatusCreated, gin.H{
 				"success": true,
@@ -113,7 +133,7 @@ func AuthorizationGetAll() gin.HandlerFunc {
 
 		c.JSON(http.StatusOK, gin.H{
 			"success":        true,
-			"authorizations": authorizations,
+			"authorizations": Redact(c, authorizations, model.Authorization{}),
 		})
 	}
 }
@@ -152,7 +172,7 @@ func GetAuthorizationById() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":       true,
-			"authorization": authorization,
+			"authorization": Redact(c, authorization, model.Authorization{}),
 		})
 	}
 }
@@ -192,6 +212,16 @@ func UpdateAuthorization() gin.HandlerFunc {
 			return
 		}
 
+		// Reject permissions that do not exist
+		if unknownPermissions := FindUnknownPermissions(authorization.Permissions); len(unknownPermissions) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":     false,
+				"message":     "Unknown permissions",
+				"permissions": unknownPermissions,
+			})
+			return
+		}
+
 		// Temp variable to decode the FindOne result
 		var tempResult bson.M
 		// Validate the ID existence in DB
@@ -205,12 +235,19 @@ func UpdateAuthorization() gin.HandlerFunc {
 		}
 
 		// Update the fields of the authorization in DB
+		fields := bson.M{
+			"levelName":          authorization.LevelName,
+			"description":        authorization.Description,
+			"require_two_factor": authorization.RequireTwoFactor,
+			"allow_magic_link":   authorization.AllowMagicLink,
+			"updatedAt":          time.Now().Unix(),
+		}
+		// Only replace the permission set when it is specified
+		if authorization.Permissions != nil {
+			fields["permissions"] = authorization.Permissions
+		}
 		update := bson.M{
-			"$set": bson.M{
-				"levelName":   authorization.LevelName,
-				"description": authorization.Description,
-				"updatedAt":   time.Now().Unix(),
-			},
+			"$set": fields,
 		}
 
 		// Find and update the authorization in DB
@@ -220,6 +257,8 @@ func UpdateAuthorization() gin.HandlerFunc {
 			return
 		}
 
+		RecordAuditEvent(c, model.AuditAuthorizationUpdate, model.AuditOutcomeSuccess, "authorization", updateId.Hex(), gin.H{"levelName": authorization.LevelName, "permissions": authorization.Permissions, "require_two_factor": authorization.RequireTwoFactor, "allow_magic_link": authorization.AllowMagicLink})
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -258,6 +297,7 @@ func AuthorizationDelete() gin.HandlerFunc {
 		}
 
 		if result.DeletedCount == 1 {
+			RecordAuditEvent(c, model.AuditAuthorizationDelete, model.AuditOutcomeSuccess, "authorization", deleteId.Hex(), nil)
 			c.JSON(http.StatusOK, gin.H{
 				"success": true,
 				"message": "Authorization deleted",
@@ -265,3 +305,121 @@ func AuthorizationDelete() gin.HandlerFunc {
 		}
 	}
 }
+
+/*
+Get every permission that can be granted to an Authorization
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the permissions
+*/
+func GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"permissions": model.AllPermissions,
+		})
+	}
+}
+
+/*
+Find the permissions that are not in model.AllPermissions
+
+params: permissions []string The permissions to check
+
+return: []string The unknown permissions
+*/
+func FindUnknownPermissions(permissions []string) []string {
+	var unknownPermissions []string
+	for _, permission := range permissions {
+		known := false
+		for _, knownPermission := range model.AllPermissions {
+			if permission == knownPermission {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			unknownPermissions = append(unknownPermissions, permission)
+		}
+	}
+
+	return unknownPermissions
+}
+
+/*
+Give the existing Authorizations their permissions from the command line: authorizations migrate [admin level]
+
+params: args []string The arguments after "authorizations"
+
+return: error The error if the command is unknown or fails
+*/
+func RunAuthorizationCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if (len(args) == 1 || len(args) == 2) && args[0] == "migrate" {
+		adminLevel := ""
+		if len(args) == 2 {
+			adminLevel = args[1]
+		}
+		adminCount, defaultCount, migrateErr := migrateAuthorizations(ctx, adminLevel)
+		if migrateErr != nil {
+			return migrateErr
+		}
+		fmt.Printf("Granted every permission to %d levels and the default permissions to %d levels\n", adminCount, defaultCount)
+		return nil
+	}
+
+	return fmt.Errorf("usage: authorizations migrate [admin level]")
+}
+
+/*
+Grant every permission to the admin level, then model.DefaultPermissions to the levels that have no permissions field.
+Levels that already have permissions keep them, so the migration can run again
+
+params: ctx context.Context Context of the DB operations
+
+adminLevel string The level name that gets the "*" permission, none when empty
+
+return: int The number of levels that got every permission
+
+int The number of levels that got the default permissions
+
+error The error of the DB operations, or the admin level does not exist
+*/
+func migrateAuthorizations(ctx context.Context, adminLevel string) (int, int, error) {
+	adminCount := 0
+	if adminLevel != "" {
+		adminResult, adminErr := authorizationCollection.UpdateOne(
+			ctx,
+			bson.M{"levelName": adminLevel},
+			bson.M{
+				"$addToSet": bson.M{"permissions": model.PermissionAll},
+				"$set":      bson.M{"updatedAt": time.Now().Unix()},
+			},
+		)
+		if adminErr != nil {
+			return 0, 0, adminErr
+		}
+		if adminResult.MatchedCount == 0 {
+			return 0, 0, fmt.Errorf("authorization level %q does not exist", adminLevel)
+		}
+		adminCount = int(adminResult.ModifiedCount)
+	}
+
+	defaultResult, defaultErr := authorizationCollection.UpdateMany(
+		ctx,
+		bson.M{"permissions": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"permissions": model.DefaultPermissions,
+			"updatedAt":   time.Now().Unix(),
+		}},
+	)
+	if defaultErr != nil {
+		return adminCount, 0, defaultErr
+	}
+
+	return adminCount, int(defaultResult.ModifiedCount), nil
+}
@@ -0,0 +1,693 @@
+/*
+Controller for the kanban board of the Tasks of a Project
+
+1. GetProjectBoard: Get the Tasks of a Project grouped in status columns
+
+2. GetEpicBoard: Get the Tasks of an Epic grouped in status columns
+
+3. MoveTask: Move a Task to a position of a column
+
+4. NextTaskRank: Get the rank of a new Task at the bottom of a column
+
+5. EnsureBoardIndex: Create the unique rank index of the Tasks, at startup
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Digits of the ranks, in the order MongoDB compares strings
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// Tries of a ranked write when concurrent moves take the same rank
+const rankAttempts = 5
+
+var errBoardChanged = errors.New("the board changed meanwhile")
+
+type moveTask_struct struct {
+	Status string `json:"status"` // Column to move to, the current one when empty
+	After  string `json:"after"`  // ID of the task the card goes below
+	Before string `json:"before"` // ID of the task the card goes above, the card goes to the bottom when both are empty
+}
+
+/*
+Get the Tasks of a Project grouped in the status columns of its workflow, each column in rank order
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the board of a project
+*/
+func GetProjectBoard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid project ID: "+convertErr.Error())
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		epicIds, epicErr := projectEpicIds(ctx, projectId)
+		if epicErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying epics: "+epicErr.Error())
+			return
+		}
+
+		sendBoard(ctx, c, projectId, epicIds)
+	}
+}
+
+/*
+Get the Tasks of an Epic grouped in the status columns of the workflow of its project, each column in rank order
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the board of an epic
+*/
+func GetEpicBoard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		epicId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid epic ID: "+convertErr.Error())
+			return
+		}
+		projectId, epicErr := GetProjectOfEpic(ctx, epicId)
+		if epicErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Epic not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		sendBoard(ctx, c, projectId, []primitive.ObjectID{epicId})
+	}
+}
+
+/*
+Move a Task to a column and a position within it, in a single update of the task.
+A change of column must be allowed by the workflow, like with TransitionTask
+
+params: None
+
+return: gin.HandlerFunc Handler function to move a task on the board
+*/
+func MoveTask() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		taskId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+			return
+		}
+
+		var request moveTask_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		var task model.Task
+		taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId}).Decode(&task)
+		if taskQueryErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+		projectId, epicErr := GetProjectOfEpic(ctx, task.Epic)
+		if epicErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Epic not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
+		workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+		if workflowErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying workflow: "+workflowErr.Error())
+			return
+		}
+
+		// A status the workflow does not know, set before the migration, counts as the initial status
+		from := task.Status
+		if _, known := workflowStatus(workflow, from); !known {
+			from = workflow.InitialStatus
+		}
+		if request.Status == "" {
+			request.Status = from
+		}
+		target, found := workflowStatus(workflow, request.Status)
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Unknown status",
+				"reason":  "unknown_status",
+			})
+			return
+		}
+		role := workflowRole(ctx, c, projectId)
+		if target.Name != from && !transitionAllowed(workflow, from, target.Name, role) {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The workflow does not allow moving the task from " + from + " to " + target.Name,
+				"reason":  "illegal_transition",
+				"allowed": allowedStatuses(workflow, from, role),
+			})
+			return
+		}
+
+		// Tasks created before the board get a rank first, so every neighbour has one
+		task, rankErr := rankUnrankedTasks(ctx, projectId, task)
+		if rankErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error ranking tasks: "+rankErr.Error())
+			return
+		}
+
+		low, high, boundsErr := moveBounds(ctx, projectId, task, target.Name, request.After, request.Before)
+		if boundsErr == errBoardChanged {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The cards around the position moved meanwhile, reload the board",
+				"reason":  "board_changed",
+			})
+			return
+		}
+		if boundsErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid position: " + boundsErr.Error(),
+			})
+			return
+		}
+
+		// Only update if nobody moved the task since it was read
+		statusFilter := interface{}(task.Status)
+		if task.Status == "" {
+			statusFilter = bson.M{"$in": bson.A{nil, ""}}
+		}
+		moved := true
+		rank, writeErr := rankedWrite(low, high, func(rank string) error {
+			updateResult, updateErr := taskCollection.UpdateOne(
+				ctx,
+				bson.D{
+					{Key: "_id", Value: taskId},
+					{Key: "status", Value: statusFilter},
+					{Key: "rank", Value: task.Rank},
+				},
+				bson.D{
+					{Key: "$set", Value: bson.D{
+						{Key: "status", Value: target.Name},
+						{Key: "rank", Value: rank},
+						{Key: "project", Value: projectId},
+						{Key: "updatedAt", Value: time.Now()},
+					}},
+				},
+			)
+			moved = updateErr != nil || updateResult.MatchedCount > 0
+			return updateErr
+		})
+		if writeErr == errBoardChanged {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The cards around the position moved meanwhile, reload the board",
+				"reason":  "board_changed",
+			})
+			return
+		}
+		if writeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error moving task: "+writeErr.Error())
+			return
+		}
+		if !moved {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The task was moved meanwhile, reload the board",
+				"reason":  "status_changed",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"message":  "Task moved",
+			"status":   target.Name,
+			"category": target.Category,
+			"rank":     rank,
+		})
+	}
+}
+
+/*
+Get the rank of a new Task at the bottom of a column of a Project
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+status string Status of the column
+
+return: string Lowest rank below the cards of the column
+
+error The error of the DB query
+*/
+func NextTaskRank(ctx context.Context, projectId primitive.ObjectID, status string) (string, error) {
+	last, lastErr := neighbourRank(ctx, projectId, status, "", false)
+	if lastErr != nil {
+		return "", lastErr
+	}
+
+	return rankBetween(last, ""), nil
+}
+
+/*
+Send the board of some epics of a Project, tasks without rank come last in creation order
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project
+
+epicIds []primitive.ObjectID IDs of the epics on the board
+*/
+func sendBoard(ctx context.Context, c *gin.Context, projectId primitive.ObjectID, epicIds []primitive.ObjectID) {
+	workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+	if workflowErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Project not found",
+		})
+		return
+	}
+
+	// Create an array of the Task model
+	var tasks []gin.H
+	result, queryErr := taskCollection.Find(ctx, bson.M{"epic": bson.M{"$in": epicIds}})
+	if queryErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error querying tasks: "+queryErr.Error())
+		return
+	}
+	decodeErr := result.All(ctx, &tasks)
+	if decodeErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error decoding tasks: "+decodeErr.Error())
+		return
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		rankI, _ := tasks[i]["rank"].(string)
+		rankJ, _ := tasks[j]["rank"].(string)
+		if (rankI == "") != (rankJ == "") {
+			return rankJ == ""
+		}
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		idI, _ := tasks[i]["_id"].(primitive.ObjectID)
+		idJ, _ := tasks[j]["_id"].(primitive.ObjectID)
+		return idI.Hex() < idJ.Hex()
+	})
+
+	// Flag the tasks waiting on an unfinished blocking task
+	if tasks == nil {
+		tasks = []gin.H{}
+	}
+	blockedErr := AddBlockedFlags(ctx, tasks)
+	if blockedErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+		return
+	}
+
+	// One column per status in workflow order, unknown statuses count as the initial status
+	columnTasks := map[string][]gin.H{}
+	for _, task := range tasks {
+		status, _ := task["status"].(string)
+		if _, known := workflowStatus(workflow, status); !known {
+			status = workflow.InitialStatus
+		}
+		columnTasks[status] = append(columnTasks[status], task)
+	}
+	columns := []gin.H{}
+	for _, status := range workflow.Statuses {
+		statusTasks := columnTasks[status.Name]
+		if statusTasks == nil {
+			statusTasks = []gin.H{}
+		}
+		columns = append(columns, gin.H{
+			"status":   status.Name,
+			"category": status.Category,
+			"count":    len(statusTasks),
+			"tasks":    Redact(c, statusTasks, model.Task{}),
+		})
+	}
+
+	// Send response to client
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"count":   len(tasks),
+		"columns": columns,
+	})
+}
+
+/*
+Find the ranks a moved Task goes between, from the cards the client placed it after and before
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+task model.Task The moved task
+
+status string Status of the column it moves to
+
+afterHex string ID of the task the card goes below, empty for none
+
+beforeHex string ID of the task the card goes above, empty for none
+
+return: string The rank to go above, empty for the top
+
+string The rank to go below, empty for the bottom
+
+error errBoardChanged if the given cards are no longer in that order in the column
+*/
+func moveBounds(ctx context.Context, projectId primitive.ObjectID, task model.Task, status, afterHex, beforeHex string) (string, string, error) {
+	after, afterErr := columnTaskRank(ctx, projectId, task.Id, status, afterHex)
+	if afterErr != nil {
+		return "", "", afterErr
+	}
+	before, beforeErr := columnTaskRank(ctx, projectId, task.Id, status, beforeHex)
+	if beforeErr != nil {
+		return "", "", beforeErr
+	}
+	if after != "" && before != "" && after >= before {
+		return "", "", errBoardChanged
+	}
+
+	// The card goes right below after, or right above before, other cards of the column may lie beyond them
+	switch {
+	case afterHex != "":
+		next, nextErr := neighbourRank(ctx, projectId, status, after, true, task.Id)
+		return after, next, nextErr
+	case beforeHex != "":
+		previous, previousErr := neighbourRank(ctx, projectId, status, before, false, task.Id)
+		return previous, before, previousErr
+	default:
+		// Without neighbours the card goes to the bottom of the column
+		last, lastErr := neighbourRank(ctx, projectId, status, "", false, task.Id)
+		return last, "", lastErr
+	}
+}
+
+/*
+Get the rank of a Task of a column, used as a neighbour of a moved task
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+movedId primitive.ObjectID ID of the moved task, which cannot be its own neighbour
+
+status string Status of the column
+
+taskHex string ID of the task, empty for none
+
+return: string The rank, empty for none
+
+error errBoardChanged if the task is no longer in the column
+*/
+func columnTaskRank(ctx context.Context, projectId, movedId primitive.ObjectID, status, taskHex string) (string, error) {
+	if taskHex == "" {
+		return "", nil
+	}
+	taskId, convertErr := primitive.ObjectIDFromHex(taskHex)
+	if convertErr != nil {
+		return "", convertErr
+	}
+	if taskId == movedId {
+		return "", errors.New("a card cannot be its own neighbour")
+	}
+
+	var task model.Task
+	taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId, "project": projectId, "status": status}).Decode(&task)
+	if taskQueryErr == mongo.ErrNoDocuments {
+		return "", errBoardChanged
+	}
+
+	return task.Rank, taskQueryErr
+}
+
+/*
+Get the rank of the nearest card of a column above or below a rank
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+status string Status of the column
+
+rank string The rank to start from, empty for the top or bottom of the column
+
+below bool True for the next card below the rank, false for the card above it
+
+excluded ...primitive.ObjectID IDs of tasks to skip
+
+return: string The rank of the card, empty if there is none
+
+error The error of the DB query
+*/
+func neighbourRank(ctx context.Context, projectId primitive.ObjectID, status, rank string, below bool, excluded ...primitive.ObjectID) (string, error) {
+	filter := bson.M{"project": projectId, "status": status, "rank": bson.M{"$type": "string"}}
+	if len(excluded) > 0 {
+		filter["_id"] = bson.M{"$nin": excluded}
+	}
+	direction := -1
+	if below {
+		direction = 1
+		filter["rank"] = bson.M{"$gt": rank}
+	} else if rank != "" {
+		filter["rank"] = bson.M{"$lt": rank}
+	}
+
+	var task model.Task
+	queryErr := taskCollection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "rank", Value: direction}})).Decode(&task)
+	if queryErr == mongo.ErrNoDocuments {
+		return "", nil
+	}
+
+	return task.Rank, queryErr
+}
+
+/*
+Give a rank to the Tasks of a Project that have none, below the ranked ones in creation order
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+task model.Task A task of the project to get back with its rank
+
+return: model.Task The task with its rank
+
+error The error of the DB operations
+*/
+func rankUnrankedTasks(ctx context.Context, projectId primitive.ObjectID, task model.Task) (model.Task, error) {
+	epicIds, epicErr := projectEpicIds(ctx, projectId)
+	if epicErr != nil {
+		return task, epicErr
+	}
+	result, queryErr := taskCollection.Find(
+		ctx,
+		bson.M{"epic": bson.M{"$in": epicIds}, "rank": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if queryErr != nil {
+		return task, queryErr
+	}
+	var unranked []model.Task
+	decodeErr := result.All(ctx, &unranked)
+	if decodeErr != nil {
+		return task, decodeErr
+	}
+	if len(unranked) == 0 {
+		return task, nil
+	}
+
+	for _, unrankedTask := range unranked {
+		// Ranks are unique within the project, so a task goes below every ranked one whatever its status
+		var last model.Task
+		lastErr := taskCollection.FindOne(
+			ctx,
+			bson.M{"project": projectId, "rank": bson.M{"$type": "string"}},
+			options.FindOne().SetSort(bson.D{{Key: "rank", Value: -1}}),
+		).Decode(&last)
+		if lastErr != nil && lastErr != mongo.ErrNoDocuments {
+			return task, lastErr
+		}
+
+		_, writeErr := rankedWrite(last.Rank, "", func(rank string) error {
+			_, updateErr := taskCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": unrankedTask.Id, "rank": bson.M{"$exists": false}},
+				bson.D{{Key: "$set", Value: bson.D{
+					{Key: "rank", Value: rank},
+					{Key: "project", Value: projectId},
+				}}},
+			)
+			return updateErr
+		})
+		if writeErr != nil {
+			return task, writeErr
+		}
+	}
+
+	reloadErr := taskCollection.FindOne(ctx, bson.M{"_id": task.Id}).Decode(&task)
+	return task, reloadErr
+}
+
+/*
+Write a rank between two ranks, taking the next free rank when a concurrent move took the same one
+
+params: low string The rank to go below, empty for the top
+
+high string The rank to go above, empty for the bottom
+
+write func(rank string) error The write of the rank, failing with a duplicate key error when the rank is taken
+
+return: string The written rank
+
+error The error of the write
+*/
+func rankedWrite(low, high string, write func(rank string) error) (string, error) {
+	for attempt := 0; attempt < rankAttempts; attempt++ {
+		if high != "" && low >= high {
+			return "", errBoardChanged
+		}
+		rank := rankBetween(low, high)
+		writeErr := write(rank)
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return rank, writeErr
+		}
+
+		// Another card took the rank meanwhile, go right below it
+		low = rank
+	}
+
+	return "", errBoardChanged
+}
+
+/*
+Get a rank between two ranks, without trailing zeros so there is always room for another one
+
+params: low string The lower rank, empty for the top
+
+high string The higher rank, empty for the bottom
+
+return: string A rank greater than low and lower than high
+*/
+func rankBetween(low, high string) string {
+	// Keep the prefix both ranks share, low being padded with zeros
+	if high != "" {
+		prefix := 0
+		for prefix < len(high) && rankDigitAt(low, prefix) == high[prefix] {
+			prefix++
+		}
+		if prefix > 0 {
+			rest := ""
+			if prefix < len(low) {
+				rest = low[prefix:]
+			}
+			return high[:prefix] + rankBetween(rest, high[prefix:])
+		}
+	}
+
+	lowDigit := strings.IndexByte(rankDigits, rankDigitAt(low, 0))
+	highDigit := len(rankDigits)
+	if high != "" {
+		highDigit = strings.IndexByte(rankDigits, high[0])
+	}
+	if highDigit-lowDigit > 1 {
+		return string(rankDigits[(lowDigit+highDigit)/2])
+	}
+	if len(high) > 1 {
+		return high[:1]
+	}
+
+	rest := ""
+	if len(low) > 1 {
+		rest = low[1:]
+	}
+	return string(rankDigits[lowDigit]) + rankBetween(rest, "")
+}
+
+/*
+Get a digit of a rank, ranks being padded with zeros
+
+params: rank string The rank
+
+i int Position of the digit
+
+return: byte The digit
+*/
+func rankDigitAt(rank string, i int) byte {
+	if i < len(rank) {
+		return rank[i]
+	}
+
+	return rankDigits[0]
+}
+
+/*
+Create the index keeping the ranks of a project unique, the board relies on it to detect concurrent moves.
+Runs at startup, a server without it would give two cards the same rank
+
+return: error The error of the DB operation, like ranks already taken twice
+*/
+func EnsureBoardIndex() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, indexErr := taskCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "project", Value: 1}, {Key: "rank", Value: 1}},
+		Options: options.Index().
+			SetName("project_rank").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"rank": bson.M{"$type": "string"}}),
+	})
+	if indexErr != nil {
+		return errors.New("[Board] Error creating the rank index: " + indexErr.Error())
+	}
+
+	return nil
+}
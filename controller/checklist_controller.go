@@ -0,0 +1,471 @@
+/*
+Controller for the checklist items and subtasks of a Task
+
+1. AddChecklistItem: Add an item at the end of the checklist of a Task
+
+2. UpdateChecklistItem: Update the text, done flag and assignee of a checklist item
+
+3. DeleteChecklistItem: Delete a checklist item
+
+4. ReorderChecklist: Change the order of the checklist items of a Task
+
+5. TaskRollup: Count the done checklist items and subtasks of a Task
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type checklistItem_struct struct {
+	Text     string             `json:"text" validate:"required,max=500"`
+	Done     bool               `json:"done"`
+	Assignee primitive.ObjectID `json:"assignee"` // No assignee when empty
+}
+
+type reorderChecklist_struct struct {
+	Order []primitive.ObjectID `json:"order" validate:"required"` // Every item ID, in the new order
+}
+
+/*
+Add an item at the end of the checklist of a Task
+
+params: None
+
+return: gin.HandlerFunc Handler function to add a checklist item
+*/
+func AddChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		task, projectId, found := checkTaskAccess(ctx, c, model.ProjectRoleLeader, model.ProjectRoleContributor)
+		if !found {
+			return
+		}
+
+		var request checklistItem_struct
+		if !bindChecklistItem(ctx, c, projectId, &request) {
+			return
+		}
+
+		item := model.ChecklistItem{
+			Id:        primitive.NewObjectID(),
+			Text:      request.Text,
+			Done:      request.Done,
+			Assignee:  request.Assignee,
+			Order:     len(task.Checklist),
+			CreatedAt: time.Now().Unix(),
+			UpdatedAt: time.Now().Unix(),
+		}
+		for _, existing := range task.Checklist {
+			if existing.Order >= item.Order {
+				item.Order = existing.Order + 1
+			}
+		}
+
+		// The checklist is kept sorted by order
+		_, updateErr := taskCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: task.Id}},
+			bson.D{
+				{Key: "$push", Value: bson.D{
+					{Key: "checklist", Value: bson.D{
+						{Key: "$each", Value: bson.A{item}},
+						{Key: "$sort", Value: bson.D{{Key: "order", Value: 1}}},
+					}},
+				}},
+				{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now()}}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating task: "+updateErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Checklist item added",
+			"item":    item,
+		})
+	}
+}
+
+/*
+Update the text, done flag and assignee of a checklist item
+
+params: None
+
+return: gin.HandlerFunc Handler function to update a checklist item
+*/
+func UpdateChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		itemId, convertErr := primitive.ObjectIDFromHex(c.Param("item"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid item ID: "+convertErr.Error())
+			return
+		}
+		task, projectId, found := checkTaskAccess(ctx, c, model.ProjectRoleLeader, model.ProjectRoleContributor)
+		if !found {
+			return
+		}
+
+		var request checklistItem_struct
+		if !bindChecklistItem(ctx, c, projectId, &request) {
+			return
+		}
+
+		setItem := bson.D{
+			{Key: "checklist.$.text", Value: request.Text},
+			{Key: "checklist.$.done", Value: request.Done},
+			{Key: "checklist.$.updatedAt", Value: time.Now().Unix()},
+			{Key: "updatedAt", Value: time.Now()},
+		}
+		update := bson.D{{Key: "$set", Value: append(setItem, bson.E{Key: "checklist.$.assignee", Value: request.Assignee})}}
+		if request.Assignee.IsZero() {
+			update = bson.D{
+				{Key: "$set", Value: setItem},
+				{Key: "$unset", Value: bson.D{{Key: "checklist.$.assignee", Value: ""}}},
+			}
+		}
+		updateResult, updateErr := taskCollection.UpdateOne(ctx, bson.D{{Key: "_id", Value: task.Id}, {Key: "checklist._id", Value: itemId}}, update)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating task: "+updateErr.Error())
+			return
+		}
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Checklist item not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Checklist item updated",
+		})
+	}
+}
+
+/*
+Delete a checklist item
+
+params: None
+
+return: gin.HandlerFunc Handler function to delete a checklist item
+*/
+func DeleteChecklistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		itemId, convertErr := primitive.ObjectIDFromHex(c.Param("item"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid item ID: "+convertErr.Error())
+			return
+		}
+		task, _, found := checkTaskAccess(ctx, c, model.ProjectRoleLeader, model.ProjectRoleContributor)
+		if !found {
+			return
+		}
+
+		updateResult, updateErr := taskCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: task.Id}, {Key: "checklist._id", Value: itemId}},
+			bson.D{
+				{Key: "$pull", Value: bson.D{{Key: "checklist", Value: bson.D{{Key: "_id", Value: itemId}}}}},
+				{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now()}}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating task: "+updateErr.Error())
+			return
+		}
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Checklist item not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Checklist item deleted",
+		})
+	}
+}
+
+/*
+Change the order of the checklist items of a Task, the order must list every item once
+
+params: None
+
+return: gin.HandlerFunc Handler function to reorder a checklist
+*/
+func ReorderChecklist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		task, _, found := checkTaskAccess(ctx, c, model.ProjectRoleLeader, model.ProjectRoleContributor)
+		if !found {
+			return
+		}
+
+		var request reorderChecklist_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		listed := map[primitive.ObjectID]bool{}
+		for _, itemId := range request.Order {
+			listed[itemId] = true
+		}
+		if len(listed) != len(request.Order) || len(request.Order) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The order must list every item once",
+			})
+			return
+		}
+
+		// Only update if the checklist still has exactly the listed items
+		setOrders := bson.D{{Key: "updatedAt", Value: time.Now()}}
+		arrayFilters := []interface{}{}
+		for i, itemId := range request.Order {
+			setOrders = append(setOrders, bson.E{Key: "checklist.$[item" + strconv.Itoa(i) + "].order", Value: i})
+			arrayFilters = append(arrayFilters, bson.M{"item" + strconv.Itoa(i) + "._id": itemId})
+		}
+		updateResult, updateErr := taskCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: task.Id},
+				{Key: "checklist", Value: bson.D{{Key: "$size", Value: len(request.Order)}}},
+				{Key: "checklist._id", Value: bson.D{{Key: "$all", Value: request.Order}}},
+			},
+			bson.D{{Key: "$set", Value: setOrders}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters}),
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating task: "+updateErr.Error())
+			return
+		}
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The checklist changed meanwhile, reload the task",
+				"reason":  "checklist_changed",
+			})
+			return
+		}
+
+		// Keep the checklist sorted by order
+		_, sortErr := taskCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: task.Id}},
+			bson.D{{Key: "$push", Value: bson.D{
+				{Key: "checklist", Value: bson.D{
+					{Key: "$each", Value: bson.A{}},
+					{Key: "$sort", Value: bson.D{{Key: "order", Value: 1}}},
+				}},
+			}}},
+		)
+		if sortErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error sorting checklist: "+sortErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Checklist reordered",
+		})
+	}
+}
+
+/*
+Count the done checklist items and subtasks of a Task, a subtask being done in a done status of the workflow
+
+params: ctx context.Context Context of the DB operations
+
+task model.Task The task
+
+workflow model.Workflow The workflow of its project
+
+return: gin.H The counts and the progress in percent, 0 without checklist nor subtask
+
+[]gin.H The subtasks, with their ID, title, status, category and rank
+
+error The error of the DB query
+*/
+func TaskRollup(ctx context.Context, task model.Task, workflow model.Workflow) (gin.H, []gin.H, error) {
+	checklistDone := 0
+	for _, item := range task.Checklist {
+		if item.Done {
+			checklistDone++
+		}
+	}
+
+	var subtasks []model.Task
+	result, queryErr := taskCollection.Find(ctx, bson.M{"parent": task.Id}, options.Find().SetSort(bson.D{{Key: "rank", Value: 1}, {Key: "_id", Value: 1}}))
+	if queryErr != nil {
+		return nil, nil, queryErr
+	}
+	decodeErr := result.All(ctx, &subtasks)
+	if decodeErr != nil {
+		return nil, nil, decodeErr
+	}
+
+	subtasksDone := 0
+	briefSubtasks := []gin.H{}
+	for _, subtask := range subtasks {
+		status, known := workflowStatus(workflow, subtask.Status)
+		if !known {
+			status, _ = workflowStatus(workflow, workflow.InitialStatus)
+		}
+		if status.Category == model.WorkflowCategoryDone {
+			subtasksDone++
+		}
+		briefSubtasks = append(briefSubtasks, gin.H{
+			"_id":      subtask.Id,
+			"title":    subtask.Title,
+			"status":   subtask.Status,
+			"category": status.Category,
+			"rank":     subtask.Rank,
+		})
+	}
+
+	progress := 0
+	if total := len(task.Checklist) + len(subtasks); total > 0 {
+		progress = int(math.Round(float64(checklistDone+subtasksDone) * 100 / float64(total)))
+	}
+
+	return gin.H{
+		"checklistTotal": len(task.Checklist),
+		"checklistDone":  checklistDone,
+		"subtaskTotal":   len(subtasks),
+		"subtaskDone":    subtasksDone,
+		"progress":       progress,
+	}, briefSubtasks, nil
+}
+
+/*
+Find the Task of the :id parameter and check the current account has one of the roles in its project
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+roles ...string Roles allowed, any role if none is specified
+
+return: model.Task The task
+
+primitive.ObjectID ID of its project
+
+bool True if the request may continue, the response is sent otherwise
+*/
+func checkTaskAccess(ctx context.Context, c *gin.Context, roles ...string) (model.Task, primitive.ObjectID, bool) {
+	taskId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+	if convertErr != nil {
+		c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+		return model.Task{}, primitive.NilObjectID, false
+	}
+
+	return checkTaskIdAccess(ctx, c, taskId, roles...)
+}
+
+/*
+Find a Task and check the current account has one of the roles in its project
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+taskId primitive.ObjectID ID of the task
+
+roles ...string Roles allowed, any role if none is specified
+
+return: model.Task The task
+
+primitive.ObjectID ID of its project
+
+bool True if the request may continue, the response is sent otherwise
+*/
+func checkTaskIdAccess(ctx context.Context, c *gin.Context, taskId primitive.ObjectID, roles ...string) (model.Task, primitive.ObjectID, bool) {
+	var task model.Task
+	taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId}).Decode(&task)
+	if taskQueryErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Task not found",
+		})
+		return task, primitive.NilObjectID, false
+	}
+	projectId, epicErr := GetProjectOfEpic(ctx, task.Epic)
+	if epicErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": "Epic not found",
+		})
+		return task, primitive.NilObjectID, false
+	}
+
+	return task, projectId, CheckProjectAccess(ctx, c, projectId, roles...)
+}
+
+/*
+Bind and validate a checklist item, its assignee must be an employee member of the project
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project of the task
+
+request *checklistItem_struct The item to bind
+
+return: bool True if the item is valid, the response is sent otherwise
+*/
+func bindChecklistItem(ctx context.Context, c *gin.Context, projectId primitive.ObjectID, request *checklistItem_struct) bool {
+	bindingErr := c.BindJSON(request)
+	if bindingErr != nil {
+		c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+		return false
+	}
+	validationErr := validate.Struct(request)
+	if validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid checklist item: " + validationErr.Error(),
+		})
+		return false
+	}
+	if !request.Assignee.IsZero() && GetProjectRole(ctx, projectId, request.Assignee) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "The assignee must be a member of the project",
+		})
+		return false
+	}
+
+	return true
+}
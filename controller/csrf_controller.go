@@ -0,0 +1,49 @@
+/*
+Controller for handling the CSRF token cookie
+
+1. GetCSRFToken: Get the CSRF token to send in the X-CSRF-Token header
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Get the CSRF token of the browser, a new one is set in the csrf_token cookie if there is none yet
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the CSRF token
+*/
+func GetCSRFToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Keep the current token, so requests already holding it in other tabs stay valid
+		token, _ := c.Cookie(middleware.CSRFCookieName)
+		if !middleware.ValidCSRFToken(token) {
+			var generateErr error
+			token, generateErr = GenerateToken()
+			if generateErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error generating CSRF token: "+generateErr.Error())
+				return
+			}
+
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     middleware.CSRFCookieName,
+				Value:    token,
+				HttpOnly: true, // The frontend reads the token from this response, scripts do not need the cookie
+				Secure:   false,
+				SameSite: http.SameSiteLaxMode,
+				Path:     "/",
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"csrfToken": token,
+		})
+	}
+}
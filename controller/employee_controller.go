@@ -101,7 +101,8 @@ func CreateEmployee() gin.HandlerFunc {
 		FindUsername := accountCollection.FindOne(
 			ctx, bson.M{"username": GetUsername}).Decode(&accountModel)
 		if FindUsername != nil {
-			var password, unhashedPassword = GenerateAndHashPassword()
+			// The invitee sets the password, the generated one is never sent
+			var password, _ = GenerateAndHashPassword()
 
 			if password == "" {
 				c.JSON(http.StatusInternalServerError, "Error generating password")
@@ -142,7 +143,11 @@ func CreateEmployee() gin.HandlerFunc {
 				UpdatedAt:     time.Now().Unix(),
 			}
 
-			_, userInforInsertError := userInforCollection.InsertOne(ctx, newUserInfor)
+			// Encrypt the personal data before it reaches the database
+			userInforInsertError := ProtectUserInfor(&newUserInfor)
+			if userInforInsertError == nil {
+				_, userInforInsertError = userInforCollection.InsertOne(ctx, newUserInfor)
+			}
 			if userInforInsertError != nil {
 				// c.JSON(http.StatusInternalServerError, "Error inserting user information :"+insertErr.Error())
 
@@ -163,7 +168,7 @@ func CreateEmployee() gin.HandlerFunc {
 				UserInforID, _ := primitive.ObjectIDFromHex(newUserInfor.Id.Hex())
 				newEmployee := model.Employee{
 					Id:          primitive.NewObjectID(),
-					State:       0,
+					State:       model.EmployeeStatePending,
 					AccountID:   accountID,
 					UserInforId: UserInforID,
 					CreatedAt:   time.Now().Unix(),
@@ -199,17 +204,18 @@ func CreateEmployee() gin.HandlerFunc {
 					return
 				} else {
 					fmt.Println("Insert employee Successful into Database with employee ID:", newEmployee.Id)
-					// Send email to user
-					sendEmailSuccess := SendNewUserEmail(GetEmail, GetFullName, GetUsername, unhashedPassword)
-
-					if !sendEmailSuccess {
-						c.JSON(http.StatusInternalServerError, "Error sending emails")
+					// Send invitation to user
+					invitationErr := CreateInvitation(ctx, accountID, newEmployee.Id, GetEmail, GetFullName, CurrentEmployeeId(c))
+					if invitationErr != nil {
+						c.JSON(http.StatusInternalServerError, "Error sending emails: "+invitationErr.Error())
 						return
 					}
 
+					RecordAuditEvent(c, model.AuditEmployeeCreate, model.AuditOutcomeSuccess, "employee", newEmployee.Id.Hex(), gin.H{"account_id": accountID.Hex(), "email": GetEmail})
+
 					c.JSON(http.StatusOK, gin.H{
 						"success": true,
-						"message": "Employee account created",
+						"message": "Employee account created, invitation sent",
 					})
 				}
 			}
@@ -330,22 +336,26 @@ func UpdateEmployee() gin.HandlerFunc {
 			return
 		}
 
+		// Encrypt the personal data before it reaches the database
+		piiFields, protectErr := ProtectedPIIFields(email, phone, address)
+		if protectErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error encrypting user infor: "+protectErr.Error())
+			return
+		}
+
 		userinforUpdateResult := userInforCollection.FindOneAndUpdate(
 			ctx,
 			bson.D{{Key: "_id", Value: userInforId}},
 			bson.D{
-				{Key: "$set", Value: bson.D{
+				{Key: "$set", Value: append(bson.D{
 					{Key: "office", Value: office},
 					{Key: "department", Value: department},
 					{Key: "position", Value: position},
 					{Key: "manager_id", Value: managerId},
-					{Key: "phone", Value: phone},
-					{Key: "email", Value: email},
 					{Key: "gender", Value: gender},
-					{Key: "address", Value: address},
 					{Key: "fullname", Value: fullname},
 					{Key: "updatedAt", Value: time.Now().Unix()},
-				}},
+				}, piiFields...)},
 			},
 		)
 		if userinforUpdateResult.Err() != nil {
@@ -472,7 +482,7 @@ func EmployeeGetAll() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":   true,
-			"employees": employees,
+			"employees": Redact(c, employees, model.Employee{}),
 		})
 	}
 }
@@ -559,7 +569,7 @@ func EmployeeSearchFullName() gin.HandlerFunc {
 			// Send response to client
 			c.JSON(http.StatusOK, gin.H{
 				"count":     len(employees),
-				"employees": employees,
+				"employees": Redact(c, employees, model.Employee{}),
 			})
 		}
 	}
@@ -686,7 +696,7 @@ func GetEmployeeByRole() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":   true,
-			"employees": employees,
+			"employees": Redact(c, employees, model.Employee{}),
 		})
 	}
 }
@@ -784,7 +794,7 @@ func GetEmployeeDetailWithID() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":  true,
-			"employee": employee[0],
+			"employee": Redact(c, employee[0], model.Employee{}),
 		})
 	}
 }
@@ -844,7 +854,7 @@ func GetEmployeeByManager() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":   true,
-			"employees": employees,
+			"employees": Redact(c, employees, model.Employee{}),
 		})
 	}
 }
@@ -867,42 +877,25 @@ func GetEmployeeByProject() gin.HandlerFunc {
 		// Create an array for the employees
 		var employees []gin.H
 
-		// Define a pipeline to filter the data by title and join collections
+		// Define a pipeline to get the members of the project
 		pipeline := mongo.Pipeline{
-			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "epics"},
-					{Key: "localField", Value: "epic"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "epic"},
-				}},
-			},
-			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "projects"},
-					{Key: "localField", Value: "epic.project"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "project"},
-				}},
-			},
 			bson.D{
 				{Key: "$match", Value: bson.D{
-					{Key: "project._id", Value: queryId},
+					{Key: "project", Value: queryId},
 				}},
 			},
-			bson.D{
-				{Key: "$unwind", Value: "$members"},
-			},
 			bson.D{
 				{Key: "$project", Value: bson.D{
 					{Key: "_id", Value: 0},
-					{Key: "members", Value: 1},
+					{Key: "members", Value: "$employee"},
+					{Key: "role", Value: 1},
+					{Key: "joinedAt", Value: 1},
 				}},
 			},
 		}
 
-		// Use the defined stages to aggregate data from User_Infor collection
-		result, aggregateErr := taskCollection.Aggregate(ctx, pipeline)
+		// Use the defined stages to aggregate data from ProjectMember collection
+		result, aggregateErr := projectMemberCollection.Aggregate(ctx, pipeline)
 		if aggregateErr != nil {
 			c.JSON(http.StatusInternalServerError, "Error aggregating employees: "+aggregateErr.Error())
 			return
@@ -921,7 +914,7 @@ func GetEmployeeByProject() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":   true,
-			"employees": employees,
+			"employees": Redact(c, employees, model.Employee{}),
 		})
 	}
 }
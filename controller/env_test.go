@@ -0,0 +1,4 @@
+package controller
+
+// The environment is set before the collections connect
+import _ "backend/apptest"
@@ -59,6 +59,11 @@ func CreateEpic() gin.HandlerFunc {
 			return
 		}
 
+		// Only leaders and contributors of the project can create epics
+		if !CheckProjectAccess(ctx, c, epic.Project, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
 		epic.Id = primitive.NewObjectID()
 		epic.CreatedAt = time.Now()
 		epic.UpdatedAt = time.Now()
@@ -304,7 +309,7 @@ func GetEpics() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"count": len(epics),
-			"epics": epics,
+			"epics": Redact(c, epics, model.Epic{}),
 		})
 	}
 }
@@ -374,9 +379,16 @@ func GetEpicById() gin.HandlerFunc {
 		// Close the cursor after getting data to prevent memory leak
 		result.Close(ctx)
 
+		// Flag the tasks of the epic waiting on an unfinished blocking task
+		blockedErr := AddEmbeddedBlockedFlags(ctx, epic, "tasks")
+		if blockedErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+			return
+		}
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
-			"epic":    epic[0],
+			"epic":    Redact(c, epic[0], model.Epic{}),
 			"success": true,
 		})
 	}
@@ -454,7 +466,7 @@ func SearchEpic() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"count": len(epics),
-			"epics": epics,
+			"epics": Redact(c, epics, model.Epic{}),
 		})
 	}
 }
@@ -544,10 +556,17 @@ func GetEpicForProject() gin.HandlerFunc {
 		// Close the cursor after getting data to prevent memory leak
 		result.Close(ctx)
 
+		// Flag the tasks of the epics waiting on an unfinished blocking task
+		blockedErr := AddEmbeddedBlockedFlags(ctx, epics, "tasks")
+		if blockedErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+			return
+		}
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"epics":   epics,
+			"epics":   Redact(c, epics, model.Epic{}),
 		})
 	}
 }
@@ -0,0 +1,635 @@
+/*
+Controller for handling data with Guest model in DB
+
+1. GetGuests: Get all Guests, optionally only those of a project
+
+2. CreateGuest: Create a guest account and email it an invitation
+
+3. UpdateGuest: Update the name, company and expiry of a Guest
+
+4. DeleteGuest: Delete a Guest and its account
+
+5. ShareProjectWithGuest: Share a Project with a Guest, or change its role
+
+6. UnshareProjectWithGuest: Stop sharing a Project with a Guest
+
+7. GetGuestProjects: Get the Projects shared with the logged in guest
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type createGuest_struct struct {
+	Username  string               `json:"username" validate:"required"`
+	Name      string               `json:"name" validate:"required"`
+	Email     string               `json:"email" validate:"required,email"`
+	Company   string               `json:"company"`
+	ExpiresAt int64                `json:"expiresAt"` // 0 if the access does not expire
+	Projects  []model.GuestProject `json:"projects" validate:"dive"`
+}
+
+type updateGuest_struct struct {
+	Name      string `json:"name" validate:"required"`
+	Company   string `json:"company"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type shareProjectWithGuest_struct struct {
+	Role string `json:"role" validate:"omitempty,oneof=viewer contributor"` // Viewer when empty
+}
+
+var errGuestAuthorization = errors.New("GUEST_AUTHORIZATION is not an authorization level")
+
+/*
+Get all Guests, the project query parameter keeps only the guests a project is shared with
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the guests
+*/
+func GetGuests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Create an array of the Guest model
+		var guests []model.Guest
+
+		filter := bson.M{}
+		if projectHex := c.Query("project"); projectHex != "" {
+			projectId, convertErr := primitive.ObjectIDFromHex(projectHex)
+			if convertErr != nil {
+				c.JSON(http.StatusBadRequest, "Invalid project ID: "+convertErr.Error())
+				return
+			}
+			filter["projects.project"] = projectId
+		}
+
+		// Get the guests from DB, newest first
+		result, queryErr := guestCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying guests: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the guests array
+		decodeErr := result.All(ctx, &guests)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding guests: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"count":   len(guests),
+			"guests":  Redact(c, guests, model.Guest{}),
+		})
+	}
+}
+
+/*
+Create a guest account with the level named in GUEST_AUTHORIZATION, without employee or user information, and email it an invitation to set its password
+
+params: None
+
+return: gin.HandlerFunc Handler function to create a guest
+*/
+func CreateGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request createGuest_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		request.Username = strings.TrimSpace(request.Username)
+		request.Name = strings.TrimSpace(request.Name)
+		request.Email = strings.TrimSpace(request.Email)
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid guest: " + validationErr.Error(),
+			})
+			return
+		}
+		if request.ExpiresAt != 0 && request.ExpiresAt <= time.Now().Unix() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The expiry must be in the future",
+			})
+			return
+		}
+
+		authorizationId, authorizationErr := guestAuthorizationId(ctx)
+		if authorizationErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error finding guest authorization: "+authorizationErr.Error())
+			return
+		}
+
+		takenCount, countErr := accountCollection.CountDocuments(ctx, bson.M{"username": request.Username})
+		if countErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying username: "+countErr.Error())
+			return
+		}
+		if takenCount > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Username already exists",
+			})
+			return
+		}
+
+		// Only existing projects can be shared
+		for i := range request.Projects {
+			projectCount, projectErr := projectCollection.CountDocuments(ctx, bson.M{"_id": request.Projects[i].Project})
+			if projectErr != nil || projectCount == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "Project not found",
+					"project": request.Projects[i].Project,
+				})
+				return
+			}
+			if request.Projects[i].Role == "" {
+				request.Projects[i].Role = model.ProjectRoleViewer
+			}
+			request.Projects[i].SharedBy = CurrentEmployeeId(c)
+			request.Projects[i].SharedAt = time.Now().Unix()
+		}
+
+		// The invitee sets the password, the generated one is never sent
+		password, _ := GenerateAndHashPassword()
+		if password == "" {
+			c.JSON(http.StatusInternalServerError, "Error generating password")
+			return
+		}
+
+		account := model.Account{
+			Id:                       primitive.NewObjectID(),
+			Username:                 request.Username,
+			Password:                 password,
+			Account_Name:             request.Name,
+			Account_Authorization_Id: authorizationId,
+			CreatedAt:                time.Now().Unix(),
+			UpdatedAt:                time.Now().Unix(),
+		}
+		_, accountInsertErr := accountCollection.InsertOne(ctx, account)
+		if accountInsertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting account: "+accountInsertErr.Error())
+			return
+		}
+
+		guest := model.Guest{
+			Id:        primitive.NewObjectID(),
+			AccountId: account.Id,
+			Name:      request.Name,
+			Email:     request.Email,
+			Company:   strings.TrimSpace(request.Company),
+			Projects:  request.Projects,
+			ExpiresAt: request.ExpiresAt,
+			CreatedBy: CurrentEmployeeId(c),
+			CreatedAt: time.Now().Unix(),
+			UpdatedAt: time.Now().Unix(),
+		}
+		if guest.Projects == nil {
+			guest.Projects = []model.GuestProject{}
+		}
+		_, guestInsertErr := guestCollection.InsertOne(ctx, guest)
+		if guestInsertErr != nil {
+			// Do not leave an account that is neither an employee nor a guest
+			_, _ = accountCollection.DeleteOne(ctx, bson.M{"_id": account.Id})
+			c.JSON(http.StatusInternalServerError, "Error inserting guest: "+guestInsertErr.Error())
+			return
+		}
+
+		// Send invitation to the guest, it has no employee to activate
+		invitationErr := CreateInvitation(ctx, account.Id, primitive.NilObjectID, guest.Email, guest.Name, CurrentEmployeeId(c))
+		if invitationErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error sending emails: "+invitationErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditGuestCreate, model.AuditOutcomeSuccess, "guest", guest.Id.Hex(), gin.H{"account_id": account.Id.Hex(), "email": guest.Email, "projects": len(guest.Projects)})
+
+		// Send response to client
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Guest account created, invitation sent",
+			"guest":   Redact(c, guest, model.Guest{}),
+		})
+	}
+}
+
+/*
+Update the name, company and expiry of a Guest, an expiry in the past ends its access at once
+
+params: None
+
+return: gin.HandlerFunc Handler function to update a guest
+*/
+func UpdateGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		guestId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid guest ID: "+convertErr.Error())
+			return
+		}
+
+		var request updateGuest_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		request.Name = strings.TrimSpace(request.Name)
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid guest: " + validationErr.Error(),
+			})
+			return
+		}
+
+		var guest model.Guest
+		updateErr := guestCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{{Key: "_id", Value: guestId}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "name", Value: request.Name},
+					{Key: "company", Value: strings.TrimSpace(request.Company)},
+					{Key: "expiresAt", Value: request.ExpiresAt},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&guest)
+		if updateErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Guest not found",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditGuestUpdate, model.AuditOutcomeSuccess, "guest", guest.Id.Hex(), gin.H{"expiresAt": guest.ExpiresAt})
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Guest updated",
+			"guest":   Redact(c, guest, model.Guest{}),
+		})
+	}
+}
+
+/*
+Delete a Guest with its account, signing it out everywhere and revoking its pending invitation
+
+params: None
+
+return: gin.HandlerFunc Handler function to delete a guest
+*/
+func DeleteGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		guestId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid guest ID: "+convertErr.Error())
+			return
+		}
+
+		var guest model.Guest
+		deleteErr := guestCollection.FindOneAndDelete(ctx, bson.M{"_id": guestId}).Decode(&guest)
+		if deleteErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Guest not found",
+			})
+			return
+		}
+
+		_, accountDeleteErr := accountCollection.DeleteOne(ctx, bson.M{"_id": guest.AccountId})
+		if accountDeleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting account: "+accountDeleteErr.Error())
+			return
+		}
+		revokeErr := RevokeAccountSessions(ctx, guest.AccountId)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
+		_, invitationErr := invitationCollection.UpdateMany(
+			ctx,
+			bson.D{
+				{Key: "account_id", Value: guest.AccountId},
+				{Key: "status", Value: model.InvitationStatusPending},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: model.InvitationStatusRevoked},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if invitationErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking invitation: "+invitationErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditGuestDelete, model.AuditOutcomeSuccess, "guest", guest.Id.Hex(), gin.H{"account_id": guest.AccountId.Hex()})
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Guest deleted",
+		})
+	}
+}
+
+/*
+Share a Project with a Guest as viewer or contributor, or change the role it is shared with, only leaders of the project can
+
+params: None
+
+return: gin.HandlerFunc Handler function to share a project with a guest
+*/
+func ShareProjectWithGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		projectId, guestId, parsingErr := projectGuestParams(c)
+		if parsingErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid ID: "+parsingErr.Error())
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		var request shareProjectWithGuest_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Role must be viewer or contributor",
+			})
+			return
+		}
+		if request.Role == "" {
+			request.Role = model.ProjectRoleViewer
+		}
+
+		// Change the role if the project is already shared, each update is a single operation
+		roleResult, roleErr := guestCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: guestId},
+				{Key: "projects.project", Value: projectId},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "projects.$.role", Value: request.Role},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if roleErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating guest: "+roleErr.Error())
+			return
+		}
+		if roleResult.MatchedCount == 0 {
+			shareResult, shareErr := guestCollection.UpdateOne(
+				ctx,
+				bson.D{
+					{Key: "_id", Value: guestId},
+					{Key: "projects.project", Value: bson.D{{Key: "$ne", Value: projectId}}},
+				},
+				bson.D{
+					{Key: "$push", Value: bson.D{
+						{Key: "projects", Value: model.GuestProject{
+							Project:  projectId,
+							Role:     request.Role,
+							SharedBy: CurrentEmployeeId(c),
+							SharedAt: time.Now().Unix(),
+						}},
+					}},
+					{Key: "$set", Value: bson.D{
+						{Key: "updatedAt", Value: time.Now().Unix()},
+					}},
+				},
+			)
+			if shareErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error updating guest: "+shareErr.Error())
+				return
+			}
+			if shareResult.MatchedCount == 0 {
+				c.JSON(http.StatusNotFound, gin.H{
+					"success": false,
+					"message": "Guest not found",
+				})
+				return
+			}
+		}
+
+		RecordAuditEvent(c, model.AuditGuestShare, model.AuditOutcomeSuccess, "guest", guestId.Hex(), gin.H{"project_id": projectId.Hex(), "role": request.Role})
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Project shared",
+			"role":    request.Role,
+		})
+	}
+}
+
+/*
+Stop sharing a Project with a Guest, only leaders of the project can
+
+params: None
+
+return: gin.HandlerFunc Handler function to stop sharing a project with a guest
+*/
+func UnshareProjectWithGuest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		projectId, guestId, parsingErr := projectGuestParams(c)
+		if parsingErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid ID: "+parsingErr.Error())
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		unshareResult, unshareErr := guestCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: guestId},
+				{Key: "projects.project", Value: projectId},
+			},
+			bson.D{
+				{Key: "$pull", Value: bson.D{
+					{Key: "projects", Value: bson.D{{Key: "project", Value: projectId}}},
+				}},
+				{Key: "$set", Value: bson.D{
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if unshareErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating guest: "+unshareErr.Error())
+			return
+		}
+		if unshareResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Project is not shared with the guest",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditGuestUnshare, model.AuditOutcomeSuccess, "guest", guestId.Hex(), gin.H{"project_id": projectId.Hex()})
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Project no longer shared",
+		})
+	}
+}
+
+/*
+Get the Projects shared with the logged in guest, with the role each one is shared with
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the projects of the guest
+*/
+func GetGuestProjects() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		sharedProjects, isGuest := c.MustGet("currentAccount").(gin.H)["guest_projects"].([]model.GuestProject)
+		if !isGuest {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Only guests have shared projects",
+			})
+			return
+		}
+
+		roles := make(map[primitive.ObjectID]string)
+		projectIds := bson.A{}
+		for _, sharedProject := range sharedProjects {
+			roles[sharedProject.Project] = sharedProject.Role
+			projectIds = append(projectIds, sharedProject.Project)
+		}
+
+		// Create an array of the Project model
+		var projects []model.Project
+		result, queryErr := projectCollection.Find(ctx, bson.M{"_id": bson.M{"$in": projectIds}})
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying projects: "+queryErr.Error())
+			return
+		}
+		decodeErr := result.All(ctx, &projects)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding projects: "+decodeErr.Error())
+			return
+		}
+
+		guestProjects := []gin.H{}
+		for _, project := range projects {
+			role := roles[project.Id]
+			if role == "" {
+				role = model.ProjectRoleViewer
+			}
+			guestProjects = append(guestProjects, gin.H{"project": project, "role": role})
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"count":    len(guestProjects),
+			"projects": Redact(c, guestProjects, model.Project{}),
+		})
+	}
+}
+
+/*
+Get the ID of the authorization level of guest accounts, named in GUEST_AUTHORIZATION
+
+params: ctx context.Context Context of the DB operations
+
+return: primitive.ObjectID ID of the authorization level
+
+error errGuestAuthorization if no level has that name
+*/
+func guestAuthorizationId(ctx context.Context) (primitive.ObjectID, error) {
+	var authorization model.Authorization
+	authorizationErr := authorizationCollection.FindOne(ctx, bson.M{"levelName": os.Getenv("GUEST_AUTHORIZATION")}).Decode(&authorization)
+	if authorizationErr != nil {
+		return primitive.NilObjectID, errGuestAuthorization
+	}
+
+	return authorization.Id, nil
+}
+
+/*
+Get the project and guest IDs of the route parameters
+
+params: c *gin.Context Context of the request
+
+return: primitive.ObjectID ID of the project
+
+primitive.ObjectID ID of the guest
+
+error The error if an ID is invalid
+*/
+func projectGuestParams(c *gin.Context) (primitive.ObjectID, primitive.ObjectID, error) {
+	projectId, projectErr := primitive.ObjectIDFromHex(c.Param("id"))
+	if projectErr != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, projectErr
+	}
+	guestId, guestErr := primitive.ObjectIDFromHex(c.Param("guest"))
+	if guestErr != nil {
+		return primitive.NilObjectID, primitive.NilObjectID, guestErr
+	}
+
+	return projectId, guestId, nil
+}
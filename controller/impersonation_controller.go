@@ -0,0 +1,326 @@
+/*
+Controller for handling data with Impersonation model in DB
+
+1. StartImpersonation: Start a time-limited session acting as another account
+
+2. StopImpersonation: End the impersonation and return to the session of the admin
+
+3. GetImpersonations: Get the impersonations of the logged in account
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type startImpersonation_struct struct {
+	Reason string `json:"reason"`
+}
+
+/*
+Start a time-limited session acting as another account, the token keeps the identity of the admin and replaces its cookies until stopped
+
+params: None
+
+return: gin.HandlerFunc Handler function to start an impersonation
+*/
+func StartImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		accountId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Account not found",
+			})
+			return
+		}
+
+		// The admin returns to its session when the impersonation stops, access tokens have none
+		currentSession, hasSession := c.Get("currentSession")
+		if !hasSession {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Impersonation requires a logged in session",
+			})
+			return
+		}
+		currentAccount := c.MustGet("currentAccount").(gin.H)
+		if currentAccount["account_id"].(primitive.ObjectID) == accountId {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Cannot impersonate your own account",
+			})
+			return
+		}
+
+		var request startImpersonation_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		account, accountErr := middleware.LoadCurrentAccount(ctx, accountId)
+		if accountErr != nil || account == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Account not found",
+			})
+			return
+		}
+
+		// Impersonating must not give the admin permissions it does not have itself
+		granted := middleware.GrantedPermissions(currentAccount)
+		if !granted[model.PermissionAll] {
+			for permission := range middleware.GrantedPermissions(account) {
+				if !granted[permission] {
+					RecordAuditEvent(c, model.AuditImpersonationStart, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"reason": "more_permissions"})
+					c.JSON(http.StatusForbidden, gin.H{
+						"success": false,
+						"message": "Cannot impersonate an account with permissions you do not have",
+					})
+					return
+				}
+			}
+		}
+
+		levelName, levelErr := GetAccountLevelName(ctx, accountId)
+		if levelErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Account not found",
+			})
+			return
+		}
+
+		impersonation := model.Impersonation{
+			Id:                    primitive.NewObjectID(),
+			AccountId:             accountId,
+			ImpersonatorAccountId: currentAccount["account_id"].(primitive.ObjectID),
+			ImpersonatorSessionId: currentSession.(primitive.ObjectID),
+			SessionId:             primitive.NewObjectID(),
+			Reason:                strings.TrimSpace(request.Reason),
+			StartedAt:             time.Now().Unix(),
+			ExpiresAt:             time.Now().Add(impersonationLifetime).Unix(),
+		}
+		impersonation.Username, _ = account["username"].(string)
+		impersonation.ImpersonatorUsername, _ = currentAccount["username"].(string)
+
+		validationErr := validate.Struct(&impersonation)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "A reason is required",
+			})
+			return
+		}
+
+		_, insertErr := impersonationCollection.InsertOne(ctx, impersonation)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting impersonation: "+insertErr.Error())
+			return
+		}
+
+		// The session of the impersonation cannot be refreshed past its expiration
+		sessionErr := startSession(ctx, c, model.Session{
+			Id:             impersonation.SessionId,
+			AccountId:      accountId,
+			ImpersonatorId: impersonation.ImpersonatorAccountId,
+			ExpiresAt:      impersonation.ExpiresAt,
+		}, levelName)
+		if sessionErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error creating session: "+sessionErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditImpersonationStart, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"reason": impersonation.Reason, "impersonation_id": impersonation.Id.Hex()})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":       true,
+			"message":       "Impersonating " + impersonation.Username,
+			"level":         levelName,
+			"impersonation": Redact(c, impersonation, model.Impersonation{}),
+		})
+	}
+}
+
+/*
+End the impersonation of the current session and return to the session of the admin
+
+params: None
+
+return: gin.HandlerFunc Handler function to stop an impersonation
+*/
+func StopImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		if _, impersonating := c.Get("impersonator"); !impersonating {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Not impersonating",
+			})
+			return
+		}
+		sessionId := c.MustGet("currentSession").(primitive.ObjectID)
+
+		var impersonation model.Impersonation
+		endErr := impersonationCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "session_id", Value: sessionId},
+				{Key: "endedAt", Value: 0},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "endedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&impersonation)
+		if endErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Impersonation not found",
+			})
+			return
+		}
+
+		_, revokeErr := revokeSessions(ctx, bson.D{{Key: "_id", Value: sessionId}})
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking session: "+revokeErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditImpersonationStop, model.AuditOutcomeSuccess, "account", impersonation.AccountId.Hex(), gin.H{"impersonation_id": impersonation.Id.Hex()})
+
+		// Log the admin out if its own session ended in the meantime
+		levelName, resumeErr := resumeSession(ctx, c, impersonation.ImpersonatorSessionId)
+		if resumeErr != nil {
+			ClearTokenCookies(c)
+			c.JSON(http.StatusOK, gin.H{
+				"success":       true,
+				"message":       "Impersonation stopped, please log in again",
+				"loginRequired": true,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Impersonation stopped",
+			"level":   levelName,
+		})
+	}
+}
+
+/*
+Get the times an admin impersonated the logged in account, newest first
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the impersonations
+*/
+func GetImpersonations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		// Create an array of the Impersonation model
+		impersonations := []model.Impersonation{}
+
+		result, queryErr := impersonationCollection.Find(
+			ctx,
+			bson.M{"account_id": accountId},
+			options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}),
+		)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying impersonations: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the impersonations array
+		decodeErr := result.All(ctx, &impersonations)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding impersonations: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"count":          len(impersonations),
+			"impersonations": Redact(c, impersonations, model.Impersonation{}),
+		})
+	}
+}
+
+/*
+Send new token cookies for an existing session that is still active, rotating its refresh token
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request to set the cookies on
+
+sessionId primitive.ObjectID ID of the session
+
+return: string The level name of the account of the session
+
+error The error if the session is no longer active
+*/
+func resumeSession(ctx context.Context, c *gin.Context, sessionId primitive.ObjectID) (string, error) {
+	refreshToken, generateErr := GenerateToken()
+	if generateErr != nil {
+		return "", generateErr
+	}
+
+	var session model.Session
+	rotateErr := sessionCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: sessionId},
+			{Key: "revoked", Value: false},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "refresh_token_hash", Value: HashToken(refreshToken)},
+				{Key: "lastSeenAt", Value: time.Now().Unix()},
+				{Key: "lastSeenIP", Value: c.ClientIP()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	).Decode(&session)
+	if rotateErr != nil {
+		return "", rotateErr
+	}
+
+	levelName, levelErr := GetAccountLevelName(ctx, session.AccountId)
+	if levelErr != nil {
+		return "", levelErr
+	}
+
+	accessToken, tokenErr := CreateAccessToken(session.AccountId, levelName, session.Id, session.ImpersonatorId)
+	if tokenErr != nil {
+		return "", tokenErr
+	}
+
+	SetTokenCookies(c, accessToken, refreshToken, time.Unix(session.ExpiresAt, 0))
+	return levelName, nil
+}
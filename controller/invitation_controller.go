@@ -0,0 +1,419 @@
+/*
+Controller for handling data with Invitation model in DB
+
+1. GetInvitations: Get all Invitations, optionally filtered by status
+
+2. ResendInvitation: Send a new link for a pending Invitation
+
+3. RevokeInvitation: Revoke a pending Invitation
+
+4. AcceptInvitation: Set the password of an invited account and activate it
+
+5. CreateInvitation: Create an Invitation for a new account and email the link
+
+6. AccountPending: Check if an account waits for its invitation to be accepted
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type acceptInvitation_struct struct {
+	Token         string `json:"token"`
+	NewPassword   string `json:"new_password"`
+	RenewPassword string `json:"renew_password"`
+}
+
+/*
+Get all Invitations, the status query parameter filters them by status
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the invitations
+*/
+func GetInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Create an array of the Invitation model
+		var invitations []model.Invitation
+
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		// Get the invitations from DB, newest first
+		result, queryErr := invitationCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying invitations: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the invitations array
+		decodeErr := result.All(ctx, &invitations)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding invitations: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":     true,
+			"count":       len(invitations),
+			"invitations": Redact(c, invitations, model.Invitation{}),
+		})
+	}
+}
+
+/*
+Send a new link for a pending Invitation, the previous link stops working
+
+params: None
+
+return: gin.HandlerFunc Handler function to resend an invitation
+*/
+func ResendInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		invitationId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invitation not found",
+			})
+			return
+		}
+
+		token, generateErr := GenerateToken()
+		if generateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error generating token: "+generateErr.Error())
+			return
+		}
+
+		// Replace the token and extend the expiration of the pending invitation
+		var invitation model.Invitation
+		updateErr := invitationCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: invitationId},
+				{Key: "status", Value: model.InvitationStatusPending},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "token_hash", Value: HashToken(token)},
+					{Key: "expiresAt", Value: time.Now().Add(invitationLifetime).Unix()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&invitation)
+		if updateErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Pending invitation not found",
+			})
+			return
+		}
+
+		sendErr := sendInvitation(ctx, invitation, token)
+		if sendErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error sending emails: "+sendErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditInvitationResend, model.AuditOutcomeSuccess, "invitation", invitationId.Hex(), gin.H{"email": invitation.Email})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Invitation sent",
+		})
+	}
+}
+
+/*
+Revoke a pending Invitation, its link stops working and the account stays pending, so it cannot log in or get a password
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke an invitation
+*/
+func RevokeInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		invitationId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invitation not found",
+			})
+			return
+		}
+
+		result, updateErr := invitationCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: invitationId},
+				{Key: "status", Value: model.InvitationStatusPending},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: model.InvitationStatusRevoked},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking invitation: "+updateErr.Error())
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Pending invitation not found",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditInvitationRevoke, model.AuditOutcomeSuccess, "invitation", invitationId.Hex(), nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Invitation revoked",
+		})
+	}
+}
+
+/*
+Set the password of an invited account with the token of the invitation link and activate the employee
+
+params: None
+
+return: gin.HandlerFunc Handler function to accept an invitation
+*/
+func AcceptInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request acceptInvitation_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Check the new password before using up the invitation
+		if request.NewPassword == "" || request.NewPassword != request.RenewPassword {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "*Passwords do not match",
+			})
+			return
+		}
+		// Find the account of the invitation without using it up, a password that breaks the policy can be corrected
+		var pendingInvitation model.Invitation
+		pendingErr := invitationCollection.FindOne(ctx, bson.D{
+			{Key: "token_hash", Value: HashToken(request.Token)},
+			{Key: "status", Value: model.InvitationStatusPending},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		}).Decode(&pendingInvitation)
+		var account model.Account
+		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": pendingInvitation.AccountId}).Decode(&account)
+		if pendingErr != nil || accountQueryErr != nil {
+			RecordAuditEvent(c, model.AuditInvitationAccept, model.AuditOutcomeFailure, "invitation", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired invitation",
+			})
+			return
+		}
+
+		policy, violations, policyErr := ValidateNewPassword(ctx, request.NewPassword, account)
+		if policyErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+			return
+		}
+		if len(violations) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":    false,
+				"message":    "*New password does not meet requirements",
+				"violations": violations,
+			})
+			return
+		}
+
+		hashedPasswordHex, hashingErr := HashPassword(request.NewPassword)
+		if hashingErr != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		// Mark the invitation as accepted in one operation, so it cannot be used twice
+		var invitation model.Invitation
+		acceptErr := invitationCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: pendingInvitation.Id},
+				{Key: "status", Value: model.InvitationStatusPending},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: model.InvitationStatusAccepted},
+					{Key: "acceptedAt", Value: time.Now().Unix()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&invitation)
+		if acceptErr != nil {
+			RecordAuditEvent(c, model.AuditInvitationAccept, model.AuditOutcomeFailure, "invitation", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired invitation",
+			})
+			return
+		}
+
+		// Set the password chosen by the invitee, with its history and age like any password change
+		updateErr := SetAccountPassword(ctx, account, hashedPasswordHex, policy)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating account: "+updateErr.Error())
+			return
+		}
+
+		// Activate the employee, guests have none
+		if !invitation.EmployeeId.IsZero() {
+			employeeUpdateResult := employeeCollection.FindOneAndUpdate(
+				ctx,
+				bson.D{{Key: "_id", Value: invitation.EmployeeId}},
+				bson.D{
+					{Key: "$set", Value: bson.D{
+						{Key: "state", Value: model.EmployeeStateActive},
+						{Key: "updatedAt", Value: time.Now().Unix()},
+					}},
+				},
+			)
+			if employeeUpdateResult.Err() != nil {
+				c.JSON(http.StatusInternalServerError, "Error updating employee: "+employeeUpdateResult.Err().Error())
+				return
+			}
+		}
+
+		RecordAuditEvent(c, model.AuditInvitationAccept, model.AuditOutcomeSuccess, "account", invitation.AccountId.Hex(), gin.H{"invitation_id": invitation.Id.Hex()})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Account activated",
+		})
+	}
+}
+
+/*
+Create an Invitation for a new account and email the link to the invitee
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the invited account
+
+employeeId primitive.ObjectID ID of the employee of the account, nil for a guest
+
+email string Email to send the invitation to
+
+name string Full name of the invitee
+
+invitedBy primitive.ObjectID ID of the employee sending the invitation
+
+return: error The error if the invitation cannot be created or the email cannot be sent
+*/
+func CreateInvitation(ctx context.Context, accountId, employeeId primitive.ObjectID, email, name string, invitedBy primitive.ObjectID) error {
+	token, generateErr := GenerateToken()
+	if generateErr != nil {
+		return generateErr
+	}
+
+	invitation := model.Invitation{
+		Id:         primitive.NewObjectID(),
+		AccountId:  accountId,
+		EmployeeId: employeeId,
+		Email:      email,
+		Name:       name,
+		TokenHash:  HashToken(token),
+		Status:     model.InvitationStatusPending,
+		InvitedBy:  invitedBy,
+		ExpiresAt:  time.Now().Add(invitationLifetime).Unix(),
+		CreatedAt:  time.Now().Unix(),
+		UpdatedAt:  time.Now().Unix(),
+	}
+	_, insertErr := invitationCollection.InsertOne(ctx, invitation)
+	if insertErr != nil {
+		return insertErr
+	}
+
+	return sendInvitation(ctx, invitation, token)
+}
+
+/*
+Email the link of an Invitation
+
+params: ctx context.Context Context of the DB operations
+
+invitation model.Invitation The invitation to send
+
+token string The token of the invitation link
+
+return: error The error if the account does not exist or the email cannot be sent
+*/
+func sendInvitation(ctx context.Context, invitation model.Invitation, token string) error {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": invitation.AccountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return accountQueryErr
+	}
+
+	link := os.Getenv("FRONTEND_URL") + "/accept-invitation?token=" + url.QueryEscape(token)
+	if !SendInvitationEmail(invitation.Email, invitation.Name, account.Username, link) {
+		return errors.New("error sending email")
+	}
+
+	return nil
+}
+
+/*
+Check if an account belongs to an employee whose invitation was not accepted, such an account cannot log in, reset its password or be linked to single sign-on
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: bool True if the employee of the account is pending
+
+error The error of the DB query
+*/
+func AccountPending(ctx context.Context, accountId primitive.ObjectID) (bool, error) {
+	pendingCount, countErr := employeeCollection.CountDocuments(ctx, bson.D{
+		{Key: "account_id", Value: accountId},
+		{Key: "state", Value: model.EmployeeStatePending},
+	})
+
+	return pendingCount > 0, countErr
+}
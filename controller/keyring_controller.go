@@ -0,0 +1,250 @@
+/*
+Controller for handling the signing and encryption keys of the keyring
+
+1. GetKeyringKeys: Get the keys of the keyring without their key material
+
+2. RotateKeyringKey: Create a new primary key for a purpose
+
+3. RetireKeyringKey: Retire a key that is not primary
+
+4. RunKeyCommand: Rotate, retire or list the keys from the command line
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type keyringKey_struct struct {
+	Purpose string `json:"purpose"`
+}
+
+/*
+Get the keys of the keyring without their key material
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the keys
+*/
+func GetKeyringKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		keys, listErr := config.ListKeys(ctx)
+		if listErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying keys: "+listErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"keys":    Redact(c, keys, model.KeyringKey{}),
+		})
+	}
+}
+
+/*
+Create a new primary key for a purpose, the previous key keeps verifying and decrypting until it is retired
+
+params: None
+
+return: gin.HandlerFunc Handler function to rotate a key
+*/
+func RotateKeyringKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request keyringKey_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		key, rotateErr := config.RotateKey(ctx, request.Purpose)
+		if rotateErr != nil {
+			RecordAuditEvent(c, model.AuditKeyRotate, model.AuditOutcomeFailure, "key", "", gin.H{"purpose": request.Purpose, "error": rotateErr.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Error rotating key: " + rotateErr.Error(),
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditKeyRotate, model.AuditOutcomeSuccess, "key", key.Kid, gin.H{"purpose": key.Purpose})
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Key rotated",
+			"key":     Redact(c, key, model.KeyringKey{}),
+		})
+	}
+}
+
+/*
+Retire a key that is not primary, secrets stored in DB with an encryption key are encrypted again with the primary key first
+
+params: None
+
+return: gin.HandlerFunc Handler function to retire a key
+*/
+func RetireKeyringKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request keyringKey_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		retireErr := RetireKey(ctx, request.Purpose, c.Param("kid"))
+		if retireErr != nil {
+			RecordAuditEvent(c, model.AuditKeyRetire, model.AuditOutcomeFailure, "key", c.Param("kid"), gin.H{"purpose": request.Purpose, "error": retireErr.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Error retiring key: " + retireErr.Error(),
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditKeyRetire, model.AuditOutcomeSuccess, "key", c.Param("kid"), gin.H{"purpose": request.Purpose})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Key retired",
+		})
+	}
+}
+
+/*
+Rotate, retire or list the keys from the command line: keys rotate <purpose> | keys retire <purpose> <kid> | keys list
+
+params: args []string The arguments after "keys"
+
+return: error The error if the command is unknown or fails
+*/
+func RunKeyCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+	defer cancel()
+
+	switch {
+	case len(args) == 2 && args[0] == "rotate":
+		key, rotateErr := config.RotateKey(ctx, args[1])
+		if rotateErr != nil {
+			return rotateErr
+		}
+		fmt.Printf("New primary %s key: %s\n", key.Purpose, key.Kid)
+		return nil
+
+	case len(args) == 3 && args[0] == "retire":
+		retireErr := RetireKey(ctx, args[1], args[2])
+		if retireErr != nil {
+			return retireErr
+		}
+		fmt.Printf("Retired %s key: %s\n", args[1], args[2])
+		return nil
+
+	case len(args) == 1 && args[0] == "list":
+		keys, listErr := config.ListKeys(ctx)
+		if listErr != nil {
+			return listErr
+		}
+		for _, key := range keys {
+			fmt.Printf("%-10s %-24s %s\n", key.Purpose, key.Kid, key.Status)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("usage: keys rotate <signing|encryption> | keys retire <signing|encryption> <kid> | keys list")
+}
+
+/*
+Retire a key of the keyring, after encrypting again the secrets and personal data stored in DB with it
+
+params: ctx context.Context Context of the DB operations
+
+purpose string model.KeyPurposeSigning or model.KeyPurposeEncryption
+
+kid string The key ID
+
+return: error The error if a secret cannot be encrypted again or the key cannot be retired
+*/
+func RetireKey(ctx context.Context, purpose, kid string) error {
+	if purpose == model.KeyPurposeEncryption {
+		reencryptErr := reencryptTOTPSecrets(ctx, kid)
+		if reencryptErr != nil {
+			return reencryptErr
+		}
+		_, migrateErr := migratePII(ctx, kid)
+		if migrateErr != nil {
+			return migrateErr
+		}
+	}
+
+	return config.RetireKey(ctx, purpose, kid)
+}
+
+/*
+Encrypt again with the primary key the TOTP secrets encrypted with a key
+
+params: ctx context.Context Context of the DB operations
+
+kid string The key ID
+
+return: error The error if a secret cannot be decrypted or updated
+*/
+func reencryptTOTPSecrets(ctx context.Context, kid string) error {
+	var twoFactors []model.TwoFactor
+	result, queryErr := twoFactorCollection.Find(ctx, bson.M{})
+	if queryErr != nil {
+		return queryErr
+	}
+	decodeErr := result.All(ctx, &twoFactors)
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	for _, twoFactor := range twoFactors {
+		if config.EncryptionKeyId(twoFactor.Secret) != kid {
+			continue
+		}
+
+		secret, decryptErr := decryptTOTPSecret(twoFactor.Secret)
+		if decryptErr != nil {
+			return decryptErr
+		}
+		encryptedSecret, encryptErr := encryptTOTPSecret(secret)
+		if encryptErr != nil {
+			return encryptErr
+		}
+
+		_, updateErr := twoFactorCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: twoFactor.Id}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "secret", Value: encryptedSecret},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			return updateErr
+		}
+	}
+
+	return nil
+}
@@ -2,21 +2,29 @@ package controller
 
 import (
 	"backend/config"
+	"backend/model"
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"html/template"
 	"io"
-	mathRand "math/rand"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
 )
@@ -24,61 +32,98 @@ import (
 // Global variables in controller package goes here
 var timeoutLimit = 30 * time.Second
 var validate = validator.New()
+var accessTokenLifetime = 15 * time.Minute
+var refreshTokenLifetime = 7 * 24 * time.Hour
+var resetTokenLifetime = 30 * time.Minute
+var resetCooldown = 5 * time.Minute
+var magicLinkLifetime = 15 * time.Minute
+var magicLinkCooldown = time.Minute
+var invitationLifetime = 3 * 24 * time.Hour
+var loginChallengeLifetime = 5 * time.Minute
+var loginAlertLifetime = 7 * 24 * time.Hour
+var loginAlertFailureWindow = 24 * time.Hour
+var impersonationLifetime = 30 * time.Minute
+var ssoLoginLifetime = 10 * time.Minute
+var loginChallengeMaxAttempts = 5
+var totpPeriod int64 = 30
+var recoveryCodeCount = 10
+
+// Permissions that let an account see the admin only fields of responses
+var redactionAdminPermissions = []string{model.PermissionAccountAdmin, model.PermissionEmployeeAdmin}
+
+// Models whose secrets and personal data can be embedded by a lookup in any document, so they are always redacted
+var alwaysRedactedModels = []interface{}{model.Account{}, model.UserInfor{}}
 
 var accountCollection = config.GetCollection(config.ConnectDB(), "accounts")
+var auditEventCollection = config.GetCollection(config.ConnectDB(), "audit_events")
 var authorizationCollection = config.GetCollection(config.ConnectDB(), "authorizations")
 var employeeCollection = config.GetCollection(config.ConnectDB(), "employee")
 var epicCollection = config.GetCollection(config.ConnectDB(), "epics")
+var guestCollection = config.GetCollection(config.ConnectDB(), "guests")
+var impersonationCollection = config.GetCollection(config.ConnectDB(), "impersonations")
+var invitationCollection = config.GetCollection(config.ConnectDB(), "invitations")
+var knownDeviceCollection = config.GetCollection(config.ConnectDB(), "known_devices")
+var lockoutEventCollection = config.GetCollection(config.ConnectDB(), "lockout_events")
+var loginAlertCollection = config.GetCollection(config.ConnectDB(), "login_alerts")
+var loginChallengeCollection = config.GetCollection(config.ConnectDB(), "login_challenges")
+var loginThrottleCollection = config.GetCollection(config.ConnectDB(), "login_throttles")
+var magicLinkCollection = config.GetCollection(config.ConnectDB(), "magic_links")
 var messageCollection = config.GetCollection(config.ConnectDB(), "messages")
+var passwordPolicyCollection = config.GetCollection(config.ConnectDB(), "password_policies")
+var passwordResetCollection = config.GetCollection(config.ConnectDB(), "password_resets")
+var personalAccessTokenCollection = config.GetCollection(config.ConnectDB(), "personal_access_tokens")
 var projectCollection = config.GetCollection(config.ConnectDB(), "projects")
+var projectMemberCollection = config.GetCollection(config.ConnectDB(), "project_members")
+var sessionCollection = config.GetCollection(config.ConnectDB(), "sessions")
+var ssoIdentityCollection = config.GetCollection(config.ConnectDB(), "sso_identities")
+var ssoLoginCollection = config.GetCollection(config.ConnectDB(), "sso_logins")
 var taskCollection = config.GetCollection(config.ConnectDB(), "tasks")
+var taskLinkCollection = config.GetCollection(config.ConnectDB(), "task_links")
+var twoFactorCollection = config.GetCollection(config.ConnectDB(), "two_factors")
 var userInforCollection = config.GetCollection(config.ConnectDB(), "user_infor")
 
-const (
-	letterBytes  = "abcdefghijklmnopqrstuvwxyz"
-	upperBytes   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	specialBytes = "!@#$%^&*()_+-=[]{};:,.<>/|?`~"
-	numBytes     = "0123456789"
-)
+/*
+Generate a random password that nobody knows, for accounts whose owner sets the password with an emailed link
+
+params: None
 
+return: string The hash of the password, empty if it cannot be generated
+
+string The plaintext password
+*/
 func GenerateAndHashPassword() (string, string) {
-	randomGenerator := mathRand.New(mathRand.NewSource(time.Now().UnixNano()))
-	passwordLength, _ := strconv.Atoi(os.Getenv("PASSWORD_LENGTH"))
-	password := ""
-	for {
-		password = ""
-		for i := 0; i < passwordLength; i++ {
-			randNum := randomGenerator.Intn(4)
-
-			switch randNum {
-			case 0:
-				password += string(letterBytes[randomGenerator.Intn(len(letterBytes))])
-			case 1:
-				password += string(upperBytes[randomGenerator.Intn(len(upperBytes))])
-			case 2:
-				password += string(specialBytes[randomGenerator.Intn(len(specialBytes))])
-			case 3:
-				password += string(numBytes[randomGenerator.Intn(len(numBytes))])
-			}
-		}
+	password, generateErr := GenerateToken()
+	if generateErr != nil {
+		return "", ""
+	}
 
-		// Check if password is good
-		if CheckPassword(password) {
-			break
-		}
+	// Hash the generated password
+	hashedPasswordHex, errhashedPassword := HashPassword(password)
+	if errhashedPassword != nil {
+		return "", password
 	}
 
+	return hashedPasswordHex, password
+}
+
+/*
+Hash a password with the peppers to store it in DB
+
+params: password string The plaintext password
+
+return: string The bcrypt hash encoded as hex
+
+error The error if hashing fails
+*/
+func HashPassword(password string) (string, error) {
 	combined := os.Getenv("PEPPER1") + password + os.Getenv("PEPPER2")
 
-	// Hash the generated password
 	hashedPassword, errhashedPassword := bcrypt.GenerateFromPassword([]byte(combined), 15)
 	if errhashedPassword != nil {
-		return "", password
+		return "", errhashedPassword
 	}
 
-	hashedPasswordHex := hex.EncodeToString(hashedPassword)
-
-	return hashedPasswordHex, password
+	return hex.EncodeToString(hashedPassword), nil
 }
 
 func VerifyPassword(password, hashedPasswordHex string) bool {
@@ -94,193 +139,357 @@ func VerifyPassword(password, hashedPasswordHex string) bool {
 	return errComparingPassword == nil
 }
 
-func CheckPassword(password string) bool {
-	passwordLength, _ := strconv.Atoi(os.Getenv("PASSWORD_LENGTH"))
+/*
+Check required validation for Project ID in epic
 
-	if len(password) < passwordLength {
-		return false
-	}
+params: fl validator.FieldLevel Field level of the validator
 
-	if os.Getenv("USE_SPECIAL") == "true" {
-		valid := false
-		for _, char := range specialBytes {
-			if strings.Contains(password, string(char)) {
-				valid = true
-				break
-			}
-		}
+return: bool The result of the validation
+*/
+func CheckCustomRequired(fl validator.FieldLevel) bool {
+	return !fl.Field().IsZero()
+}
 
-		if !valid {
-			return false
-		}
+/*
+Validation for Project ID in epic when updating (because cannot change epic from project to project)
+
+params: fl validator.FieldLevel Field level of the validator
+
+return: bool The result of the validation (always true)
+*/
+func DummyValidation(fl validator.FieldLevel) bool {
+	return true
+}
+
+/*
+Read an integer setting from the environment
+
+params: name string Name of the environment variable
+
+defaultValue int Value used when the variable is not set or not a positive number
+
+return: int The setting
+*/
+func GetEnvInt(name string, defaultValue int) int {
+	value, convertErr := strconv.Atoi(os.Getenv(name))
+	if convertErr != nil || value <= 0 {
+		return defaultValue
 	}
 
-	if os.Getenv("USE_UPPER") == "true" {
-		valid := false
-		for _, char := range upperBytes {
-			if strings.Contains(password, string(char)) {
-				valid = true
-				break
-			}
-		}
+	return value
+}
 
-		if !valid {
-			return false
-		}
+/*
+Get the employee ID of the logged in account, as loaded by CookieAuth
+
+params: c *gin.Context Context of the request
+
+return: primitive.ObjectID ID of the employee, NilObjectID if there is no logged in account
+*/
+func CurrentEmployeeId(c *gin.Context) primitive.ObjectID {
+	currentAccount, exists := c.Get("currentAccount")
+	if !exists || currentAccount == nil {
+		return primitive.NilObjectID
 	}
 
-	if os.Getenv("USE_NUMBER") == "true" {
-		valid := false
-		for _, char := range numBytes {
-			if strings.Contains(password, string(char)) {
-				valid = true
-				break
-			}
-		}
+	employeeId, _ := currentAccount.(gin.H)["_id"].(primitive.ObjectID)
+	return employeeId
+}
 
-		if !valid {
-			return false
-		}
+/*
+Generate a random opaque token to hand out to the client
+
+params: None
+
+return: string The token encoded as hex
+
+error The error if the random source fails
+*/
+func GenerateToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, tokenBytes); err != nil {
+		return "", err
 	}
 
-	return true
+	return hex.EncodeToString(tokenBytes), nil
 }
 
 /*
-Check required validation for Project ID in epic
+Hash an opaque token before storing it in DB, so a leaked DB cannot be used to log in
 
-params: fl validator.FieldLevel Field level of the validator
+params: token string The token sent to the client
 
-return: bool The result of the validation
+return: string The SHA-256 hash of the token encoded as hex
 */
-func CheckCustomRequired(fl validator.FieldLevel) bool {
-	return !fl.Field().IsZero()
+func HashToken(token string) string {
+	hashedToken := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hashedToken[:])
 }
 
 /*
-Validation for Project ID in epic when updating (because cannot change epic from project to project)
+Generate a random TOTP secret
 
-params: fl validator.FieldLevel Field level of the validator
+params: None
 
-return: bool The result of the validation (always true)
+return: string The secret encoded as base32 without padding, as authenticator apps expect
+
+error The error if the random source fails
 */
-func DummyValidation(fl validator.FieldLevel) bool {
-	return true
+func GenerateTOTPSecret() (string, error) {
+	secretBytes := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, secretBytes); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes), nil
 }
 
 /*
-Encrypt the generated token to send to the client
+Compute the RFC 6238 TOTP code of a secret for a time step (HMAC-SHA1, 6 digits)
 
-params: plaintext []byte The token to encrypt
+params: secret string The base32 secret
 
-key []byte The key to encrypt the token
+step int64 The time step, the unix time divided by totpPeriod
 
-return: []byte The encrypted token
+return: string The 6 digits code
 
-error The error if the encryption fails
+error The error if the secret is not valid base32
 */
-func Encrypt(plaintext, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+func TOTPCode(secret string, step int64) (string, error) {
+	key, decodeErr := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if decodeErr != nil {
+		return "", decodeErr
 	}
 
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
 
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+/*
+Check a TOTP code against the current time step and one step on each side for clock drift
+
+params: secret string The base32 secret
+
+code string The code entered by the user
+
+lastUsedStep int64 The step of the last accepted code, codes of this step or before are rejected
+
+return: int64 The step of the code, to store as the last used step
+
+bool True if the code is valid
+*/
+func VerifyTOTP(secret, code string, lastUsedStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	currentStep := time.Now().Unix() / totpPeriod
+
+	for step := currentStep - 1; step <= currentStep+1; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+
+		expectedCode, codeErr := TOTPCode(secret, step)
+		if codeErr != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expectedCode), []byte(code)) == 1 {
+			return step, true
+		}
 	}
 
-	return aesGCM.Seal(nonce, nonce, plaintext, nil), nil
+	return 0, false
 }
 
-func SendNewUserEmail(receiver string, name, username, password string) bool {
-	// Prepare emails
-	var confirmationBody, usernameBody, passwordBody bytes.Buffer
-	confirmationTemplate, parseErr := template.ParseFiles("./config/confirmationEmail.html")
-	if parseErr != nil {
-		fmt.Println(parseErr)
-		return false
+/*
+Build the otpauth URI of a TOTP secret, shown as a QR code to authenticator apps
+
+params: secret string The base32 secret
+
+accountName string The username of the account
+
+return: string The otpauth URI
+*/
+func TOTPURI(secret, accountName string) string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Project"
 	}
-	executeErr := confirmationTemplate.Execute(&confirmationBody, struct{ Name string }{Name: name})
-	if executeErr != nil {
-		fmt.Println(executeErr)
-		return false
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", "6")
+	query.Set("period", strconv.FormatInt(totpPeriod, 10))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+accountName) + "?" + query.Encode()
+}
+
+/*
+Generate one-time recovery codes for two-factor authentication
+
+params: None
+
+return: []string The codes to show once to the user
+
+[]string The hashes of the codes to store in DB
+
+error The error if the random source fails
+*/
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		codeBytes := make([]byte, 5)
+		if _, err := io.ReadFull(rand.Reader, codeBytes); err != nil {
+			return nil, nil, err
+		}
+
+		code := hex.EncodeToString(codeBytes)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, HashToken(code))
 	}
 
-	usernameTemplate, parseErr := template.ParseFiles("./config/usernameEmail.html")
+	return codes, hashes, nil
+}
+
+/*
+Hash a recovery code entered by the user, ignoring case, spaces and dashes
+
+params: code string The recovery code
+
+return: string The hash to look up in DB
+*/
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return HashToken(code)
+}
+
+func SendInvitationEmail(receiver, name, username, link string) bool {
+	// Prepare email
+	var invitationBody bytes.Buffer
+	invitationTemplate, parseErr := template.ParseFiles("./config/invitationEmail.html")
 	if parseErr != nil {
 		fmt.Println(parseErr)
 		return false
 	}
-	executeErr = usernameTemplate.Execute(&usernameBody, struct {
+	executeErr := invitationTemplate.Execute(&invitationBody, struct {
 		Name     string
 		Username string
+		Link     string
+		Days     int
 	}{
 		Name:     name,
 		Username: username,
+		Link:     link,
+		Days:     int(invitationLifetime.Hours() / 24),
 	})
 	if executeErr != nil {
 		fmt.Println(executeErr)
 		return false
 	}
 
-	passwordTemplate, parseErr := template.ParseFiles("./config/passwordEmail.html")
+	// Create email with the template
+	invitationEmail := gomail.NewMessage()
+	invitationEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
+	invitationEmail.SetHeader("To", receiver)
+	invitationEmail.SetHeader("Subject", "Mantle Management - Account Invitation")
+	invitationEmail.SetBody("text/html", invitationBody.String())
+
+	// Send email
+	dialer := gomail.NewDialer(os.Getenv("EMAIL_HOST"), 587, os.Getenv("EMAIL_ADDRESS"), os.Getenv("EMAIL_PASSWORD"))
+	dialErr := dialer.DialAndSend(invitationEmail)
+	if dialErr != nil {
+		fmt.Println(dialErr)
+		return false
+	}
+
+	return true
+}
+
+func SendEmailResetPassword(receiver, name, link string) bool {
+	// Prepare email
+	var resetPasswordBody bytes.Buffer
+	resetPasswordTemplate, parseErr := template.ParseFiles("./config/resetPasswordEmail.html")
 	if parseErr != nil {
 		fmt.Println(parseErr)
 		return false
 	}
-	executeErr = passwordTemplate.Execute(&passwordBody, struct {
-		Name     string
-		Password string
+	executeErr := resetPasswordTemplate.Execute(&resetPasswordBody, struct {
+		Name    string
+		Link    string
+		Minutes int
 	}{
-		Name:     name,
-		Password: password,
+		Name:    name,
+		Link:    link,
+		Minutes: int(resetTokenLifetime.Minutes()),
 	})
 	if executeErr != nil {
 		fmt.Println(executeErr)
 		return false
 	}
 
-	// Create emails with the templates
-	confirmationMail := gomail.NewMessage()
-	confirmationMail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
-	confirmationMail.SetHeader("To", receiver)
-	confirmationMail.SetHeader("Subject", "Mantle Management - Confirmation Email")
-	confirmationMail.SetBody("text/html", confirmationBody.String())
-
-	usernameEmail := gomail.NewMessage()
-	usernameEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
-	usernameEmail.SetHeader("To", receiver)
-	usernameEmail.SetHeader("Subject", "Mantle Management - New Account Information Email 1")
-	usernameEmail.SetBody("text/html", usernameBody.String())
-
-	passwordEmail := gomail.NewMessage()
-	passwordEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
-	passwordEmail.SetHeader("To", receiver)
-	passwordEmail.SetHeader("Subject", "Mantle Management - New Account Information Email 2")
-	passwordEmail.SetBody("text/html", passwordBody.String())
-
-	// Send emails
-	dialer := gomail.NewDialer(os.Getenv("EMAIL_HOST"), 587, os.Getenv("EMAIL_ADDRESS"), os.Getenv("EMAIL_PASSWORD"))
+	// Create email with the templates
+	resetPasswordEmail := gomail.NewMessage()
+	resetPasswordEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
+	resetPasswordEmail.SetHeader("To", receiver)
+	resetPasswordEmail.SetHeader("Subject", "Mantle Management - Reset Password")
+	resetPasswordEmail.SetBody("text/html", resetPasswordBody.String())
 
-	dialErr := dialer.DialAndSend(confirmationMail)
+	// Send email
+	dialer := gomail.NewDialer(os.Getenv("EMAIL_HOST"), 587, os.Getenv("EMAIL_ADDRESS"), os.Getenv("EMAIL_PASSWORD"))
+	dialErr := dialer.DialAndSend(resetPasswordEmail)
 	if dialErr != nil {
 		fmt.Println(dialErr)
 		return false
 	}
 
-	dialErr = dialer.DialAndSend(usernameEmail)
-	if dialErr != nil {
-		fmt.Println(dialErr)
+	return true
+}
+
+func SendMagicLinkEmail(receiver, name, link string) bool {
+	// Prepare email
+	var magicLinkBody bytes.Buffer
+	magicLinkTemplate, parseErr := template.ParseFiles("./config/magicLinkEmail.html")
+	if parseErr != nil {
+		fmt.Println(parseErr)
+		return false
+	}
+	executeErr := magicLinkTemplate.Execute(&magicLinkBody, struct {
+		Name    string
+		Link    string
+		Minutes int
+	}{
+		Name:    name,
+		Link:    link,
+		Minutes: int(magicLinkLifetime.Minutes()),
+	})
+	if executeErr != nil {
+		fmt.Println(executeErr)
 		return false
 	}
 
-	dialErr = dialer.DialAndSend(passwordEmail)
+	// Create email with the templates
+	magicLinkEmail := gomail.NewMessage()
+	magicLinkEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
+	magicLinkEmail.SetHeader("To", receiver)
+	magicLinkEmail.SetHeader("Subject", "Mantle Management - Login Link")
+	magicLinkEmail.SetBody("text/html", magicLinkBody.String())
+
+	// Send email
+	dialer := gomail.NewDialer(os.Getenv("EMAIL_HOST"), 587, os.Getenv("EMAIL_ADDRESS"), os.Getenv("EMAIL_PASSWORD"))
+	dialErr := dialer.DialAndSend(magicLinkEmail)
 	if dialErr != nil {
 		fmt.Println(dialErr)
 		return false
@@ -289,30 +498,56 @@ func SendNewUserEmail(receiver string, name, username, password string) bool {
 	return true
 }
 
-func SendEmailResetPassword(receiver, name, password string) bool {
+func SendLoginAlertEmail(receiver, name string, alert model.LoginAlert, confirmLink, flagLink string) bool {
 	// Prepare email
-	var resetPasswordBody bytes.Buffer
-	resetPasswordTemplate, parseErr := template.ParseFiles("./config/resetPasswordEmail.html")
+	var loginAlertBody bytes.Buffer
+	loginAlertTemplate, parseErr := template.ParseFiles("./config/loginAlertEmail.html")
 	if parseErr != nil {
 		fmt.Println(parseErr)
 		return false
 	}
-	executeErr := resetPasswordTemplate.Execute(&resetPasswordBody, struct{ Name, Password string }{Name: name, Password: password})
+	executeErr := loginAlertTemplate.Execute(&loginAlertBody, struct {
+		Name           string
+		LoggedIn       bool
+		NewDevice      bool
+		FailedAttempts int64
+		Time           string
+		IP             string
+		UserAgent      string
+		ConfirmLink    string
+		FlagLink       string
+		Days           int
+	}{
+		Name:           name,
+		LoggedIn:       !alert.SessionId.IsZero(),
+		NewDevice:      slices.Contains(alert.Reasons, model.LoginAlertNewDevice),
+		FailedAttempts: alert.FailedAttempts,
+		Time:           time.Unix(alert.CreatedAt, 0).UTC().Format("2006-01-02 15:04 MST"),
+		IP:             alert.IP,
+		UserAgent:      alert.UserAgent,
+		ConfirmLink:    confirmLink,
+		FlagLink:       flagLink,
+		Days:           int(loginAlertLifetime.Hours() / 24),
+	})
 	if executeErr != nil {
 		fmt.Println(executeErr)
 		return false
 	}
 
 	// Create email with the templates
-	resetPasswordEmail := gomail.NewMessage()
-	resetPasswordEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
-	resetPasswordEmail.SetHeader("To", receiver)
-	resetPasswordEmail.SetHeader("Subject", "Mantle Management - Reset Password")
-	resetPasswordEmail.SetBody("text/html", resetPasswordBody.String())
+	loginAlertEmail := gomail.NewMessage()
+	loginAlertEmail.SetHeader("From", os.Getenv("EMAIL_ADDRESS"))
+	loginAlertEmail.SetHeader("To", receiver)
+	subject := "Mantle Management - New Login To Your Account"
+	if alert.SessionId.IsZero() {
+		subject = "Mantle Management - Failed Logins To Your Account"
+	}
+	loginAlertEmail.SetHeader("Subject", subject)
+	loginAlertEmail.SetBody("text/html", loginAlertBody.String())
 
 	// Send email
 	dialer := gomail.NewDialer(os.Getenv("EMAIL_HOST"), 587, os.Getenv("EMAIL_ADDRESS"), os.Getenv("EMAIL_PASSWORD"))
-	dialErr := dialer.DialAndSend(resetPasswordEmail)
+	dialErr := dialer.DialAndSend(loginAlertEmail)
 	if dialErr != nil {
 		fmt.Println(dialErr)
 		return false
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890" in base32
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 Appendix B, the last 6 of the 8 digits
+	tests := []struct {
+		unixTime int64
+		want     string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		got, codeErr := TOTPCode(rfc6238Secret, test.unixTime/totpPeriod)
+		if codeErr != nil {
+			t.Fatalf("TOTPCode(%d): %v", test.unixTime, codeErr)
+		}
+		if got != test.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", test.unixTime, got, test.want)
+		}
+	}
+
+	// Secrets may be typed in lower case, anything else than base32 is refused
+	if got, _ := TOTPCode(strings.ToLower(rfc6238Secret), 59/totpPeriod); got != "287082" {
+		t.Errorf("TOTPCode of the lower case secret = %s, want 287082", got)
+	}
+	if _, codeErr := TOTPCode("not base32!", 1); codeErr == nil {
+		t.Error("TOTPCode accepted a secret that is not base32")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	currentStep := time.Now().Unix() / totpPeriod
+	code := func(step int64) string {
+		stepCode, codeErr := TOTPCode(rfc6238Secret, step)
+		if codeErr != nil {
+			t.Fatalf("TOTPCode(%d): %v", step, codeErr)
+		}
+		return stepCode
+	}
+
+	tests := []struct {
+		name         string
+		step         int64
+		lastUsedStep int64
+		want         bool
+	}{
+		{"current step", currentStep, 0, true},
+		{"one step before", currentStep - 1, 0, true},
+		{"one step after", currentStep + 1, 0, true},
+		{"two steps before", currentStep - 2, 0, false},
+		{"two steps after", currentStep + 2, 0, false},
+		{"step already used", currentStep, currentStep, false},
+		{"step before the used one", currentStep - 1, currentStep - 1, false},
+		{"step after the used one", currentStep, currentStep - 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A code computed just before the next step would be checked against it
+			if time.Now().Unix()/totpPeriod != currentStep {
+				t.Skip("the time step changed during the test")
+			}
+			step, valid := VerifyTOTP(rfc6238Secret, code(test.step), test.lastUsedStep)
+			if valid != test.want {
+				t.Fatalf("VerifyTOTP(step %+d) = %v, want %v", test.step-currentStep, valid, test.want)
+			}
+			if valid && step != test.step {
+				t.Errorf("VerifyTOTP returned step %d, want %d", step, test.step)
+			}
+		})
+	}
+
+	if _, valid := VerifyTOTP(rfc6238Secret, " "+code(currentStep)+" ", 0); !valid {
+		t.Error("VerifyTOTP refused a code with spaces around it")
+	}
+}
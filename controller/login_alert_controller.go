@@ -0,0 +1,440 @@
+/*
+Controller for handling data with KnownDevice and LoginAlert models in DB
+
+1. GetKnownDevices: Get the devices the logged in account logged in from
+
+2. ConfirmLoginAlert: Confirm an emailed login and remember its device
+
+3. FlagLoginAlert: Flag an emailed login as suspicious and revoke its session
+
+4. CheckLoginDevice: Remember the device of a new session and email an alert if the login looks unusual
+
+5. CheckLoginFailures: Email an alert when the failed logins of an account reach the threshold
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type loginAlert_struct struct {
+	Token string `json:"token"`
+}
+
+// Reasons of failed logins that count as someone trying the credentials of the account
+var credentialFailureReasons = bson.A{"wrong_password", "invalid_two_factor_code"}
+
+/*
+Get the devices the logged in account logged in from, most recently seen first
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the known devices
+*/
+func GetKnownDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		currentAccount := c.MustGet("currentAccount").(gin.H)
+
+		devices := []model.KnownDevice{}
+		cursor, queryErr := knownDeviceCollection.Find(
+			ctx,
+			bson.M{"account_id": currentAccount["account_id"].(primitive.ObjectID)},
+			options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}),
+		)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying devices: "+queryErr.Error())
+			return
+		}
+		decodeErr := cursor.All(ctx, &devices)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding devices: "+decodeErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, Redact(c, devices, model.KnownDevice{}))
+	}
+}
+
+/*
+Confirm an emailed login with the token of its alert, its device is not reported again
+
+params: None
+
+return: gin.HandlerFunc Handler function to confirm a login alert
+*/
+func ConfirmLoginAlert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request loginAlert_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		alert, resolveErr := resolveLoginAlert(ctx, request.Token, model.LoginAlertConfirmed)
+		if resolveErr != nil {
+			RecordAuditEvent(c, model.AuditLoginAlertConfirm, model.AuditOutcomeFailure, "login_alert", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		_, updateErr := knownDeviceCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: alert.DeviceId}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "confirmed", Value: true},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating device: "+updateErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditLoginAlertConfirm, model.AuditOutcomeSuccess, "account", alert.AccountId.Hex(), gin.H{"login_alert_id": alert.Id.Hex()})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Login confirmed",
+		})
+	}
+}
+
+/*
+Flag an emailed login as suspicious with the token of its alert, its session is revoked at once and its device forgotten
+
+params: None
+
+return: gin.HandlerFunc Handler function to flag a login alert
+*/
+func FlagLoginAlert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request loginAlert_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		alert, resolveErr := resolveLoginAlert(ctx, request.Token, model.LoginAlertFlagged)
+		if resolveErr != nil {
+			RecordAuditEvent(c, model.AuditLoginAlertFlag, model.AuditOutcomeFailure, "login_alert", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// Sign the suspicious login out before anything else
+		revokedCount, revokeErr := revokeSessions(ctx, bson.D{{Key: "_id", Value: alert.SessionId}})
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking session: "+revokeErr.Error())
+			return
+		}
+
+		// The next login from this device is reported again
+		_, deleteErr := knownDeviceCollection.DeleteOne(ctx, bson.M{"_id": alert.DeviceId})
+		if deleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting device: "+deleteErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditLoginAlertFlag, model.AuditOutcomeSuccess, "account", alert.AccountId.Hex(), gin.H{
+			"login_alert_id": alert.Id.Hex(),
+			"session_id":     alert.SessionId.Hex(),
+			"revoked":        revokedCount,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Login flagged and signed out, please change your password",
+		})
+	}
+}
+
+/*
+Remember the device of a new session and email the owner if the device is not confirmed or the login followed several failed attempts, errors are logged and never stop the login
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the login request
+
+accountId primitive.ObjectID ID of the logged in account
+
+sessionId primitive.ObjectID ID of the new session
+*/
+func CheckLoginDevice(ctx context.Context, c *gin.Context, accountId, sessionId primitive.ObjectID) {
+	userAgent := c.Request.UserAgent()
+	ipPrefix := deviceIPPrefix(c.ClientIP())
+	fingerprint := HashToken(userAgent + "|" + ipPrefix)
+
+	// The first device of an account is how it was set up, it is trusted without confirmation
+	deviceCount, countErr := knownDeviceCollection.CountDocuments(ctx, bson.M{"account_id": accountId})
+	if countErr != nil {
+		log.Println("[LOGIN ALERT] Error counting devices: " + countErr.Error())
+		return
+	}
+
+	var device model.KnownDevice
+	queryErr := knownDeviceCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "fingerprint", Value: fingerprint},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "lastSeenAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	).Decode(&device)
+	if queryErr == mongo.ErrNoDocuments {
+		device = model.KnownDevice{
+			Id:          primitive.NewObjectID(),
+			AccountId:   accountId,
+			Fingerprint: fingerprint,
+			UserAgent:   userAgent,
+			IPPrefix:    ipPrefix,
+			Confirmed:   deviceCount == 0,
+			FirstSeenAt: time.Now().Unix(),
+			LastSeenAt:  time.Now().Unix(),
+			CreatedAt:   time.Now().Unix(),
+			UpdatedAt:   time.Now().Unix(),
+		}
+		_, queryErr = knownDeviceCollection.InsertOne(ctx, device)
+	}
+	if queryErr != nil {
+		log.Println("[LOGIN ALERT] Error recording device: " + queryErr.Error())
+		return
+	}
+
+	failedAttempts, failuresErr := countRecentLoginFailures(ctx, accountId, sessionId)
+	if failuresErr != nil {
+		log.Println("[LOGIN ALERT] Error counting failed logins: " + failuresErr.Error())
+	}
+
+	// Every login from a device is reported until the owner confirms it
+	reasons := []string{}
+	if !device.Confirmed {
+		reasons = append(reasons, model.LoginAlertNewDevice)
+	}
+	if failedAttempts >= int64(GetEnvInt("LOGIN_ALERT_FAILURES", 3)) {
+		reasons = append(reasons, model.LoginAlertFailedAttempts)
+	}
+	if len(reasons) == 0 {
+		return
+	}
+
+	sendLoginAlert(ctx, c, accountId, device.Id, sessionId, reasons, failedAttempts)
+}
+
+/*
+Email the owner of an account once its failed logins since the previous login reach LOGIN_ALERT_FAILURES, even if no login succeeds.
+Meant to run in the background after the failure is recorded in the audit log, so a wrong password answers as fast as an unknown username
+
+params: c *gin.Context Copy of the context of the failed login request
+
+accountId primitive.ObjectID ID of the account
+*/
+func CheckLoginFailures(c *gin.Context, accountId primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+	defer cancel()
+
+	failedAttempts, failuresErr := countRecentLoginFailures(ctx, accountId, primitive.NilObjectID)
+	if failuresErr != nil {
+		log.Println("[LOGIN ALERT] Error counting failed logins: " + failuresErr.Error())
+		return
+	}
+
+	// Only the failure reaching the threshold is reported, the next login reports the ones after it
+	if failedAttempts != int64(GetEnvInt("LOGIN_ALERT_FAILURES", 3)) {
+		return
+	}
+
+	sendLoginAlert(ctx, c, accountId, primitive.NilObjectID, primitive.NilObjectID, []string{model.LoginAlertFailedAttempts}, failedAttempts)
+}
+
+/*
+Store a login alert and email its confirm and flag links to the owner of the account, errors are logged
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the login request
+
+accountId primitive.ObjectID ID of the account
+
+deviceId primitive.ObjectID ID of the device of the login, nil for failed logins
+
+sessionId primitive.ObjectID ID of the session of the login, nil for failed logins
+
+reasons []string Why the login is reported
+
+failedAttempts int64 Failed logins of the account before this one
+*/
+func sendLoginAlert(ctx context.Context, c *gin.Context, accountId, deviceId, sessionId primitive.ObjectID, reasons []string, failedAttempts int64) {
+	token, generateErr := GenerateToken()
+	if generateErr != nil {
+		log.Println("[LOGIN ALERT] Error generating token: " + generateErr.Error())
+		return
+	}
+	alert := model.LoginAlert{
+		Id:             primitive.NewObjectID(),
+		AccountId:      accountId,
+		DeviceId:       deviceId,
+		SessionId:      sessionId,
+		Reasons:        reasons,
+		FailedAttempts: failedAttempts,
+		UserAgent:      c.Request.UserAgent(),
+		IP:             c.ClientIP(),
+		TokenHash:      HashToken(token),
+		Status:         model.LoginAlertPending,
+		ExpiresAt:      time.Now().Add(loginAlertLifetime).Unix(),
+		CreatedAt:      time.Now().Unix(),
+		UpdatedAt:      time.Now().Unix(),
+	}
+	_, insertErr := loginAlertCollection.InsertOne(ctx, alert)
+	if insertErr != nil {
+		log.Println("[LOGIN ALERT] Error inserting alert: " + insertErr.Error())
+		return
+	}
+
+	email, fullname, contactErr := GetAccountContact(ctx, accountId)
+	if contactErr != nil {
+		log.Println("[LOGIN ALERT] Error finding the email of account " + accountId.Hex() + ": " + contactErr.Error())
+		return
+	}
+
+	RecordAuditEvent(c, model.AuditLoginAlert, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{
+		"login_alert_id":  alert.Id.Hex(),
+		"reasons":         reasons,
+		"failed_attempts": failedAttempts,
+	})
+
+	// Do not keep the login waiting for the mail server
+	link := os.Getenv("FRONTEND_URL") + "/login-alert?token=" + url.QueryEscape(token)
+	go SendLoginAlertEmail(email, fullname, alert, link+"&action=confirm", link+"&action=flag")
+}
+
+/*
+Answer a pending login alert in one operation, so its token cannot be used twice
+
+params: ctx context.Context Context of the DB operations
+
+token string The token from the emailed link
+
+status string model.LoginAlertConfirmed or model.LoginAlertFlagged
+
+return: model.LoginAlert The alert
+
+error The error if the token is unknown, answered or expired
+*/
+func resolveLoginAlert(ctx context.Context, token, status string) (model.LoginAlert, error) {
+	var alert model.LoginAlert
+	updateErr := loginAlertCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "token_hash", Value: HashToken(token)},
+			{Key: "status", Value: model.LoginAlertPending},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "status", Value: status},
+				{Key: "resolvedAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	).Decode(&alert)
+
+	return alert, updateErr
+}
+
+/*
+Count the failed logins of an account since its previous session, within loginAlertFailureWindow
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+sessionId primitive.ObjectID ID of the new session, not counted as the previous one
+
+return: int64 The number of failed logins
+
+error The error of the DB queries
+*/
+func countRecentLoginFailures(ctx context.Context, accountId, sessionId primitive.ObjectID) (int64, error) {
+	since := time.Now().Add(-loginAlertFailureWindow).Unix()
+
+	var previousSession model.Session
+	previousErr := sessionCollection.FindOne(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "_id", Value: bson.D{{Key: "$ne", Value: sessionId}}},
+			{Key: "impersonator_account_id", Value: bson.D{{Key: "$exists", Value: false}}},
+		},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	).Decode(&previousSession)
+	if previousErr != nil && previousErr != mongo.ErrNoDocuments {
+		return 0, previousErr
+	}
+	if previousSession.CreatedAt > since {
+		since = previousSession.CreatedAt
+	}
+
+	return auditEventCollection.CountDocuments(ctx, bson.D{
+		{Key: "action", Value: model.AuditLogin},
+		{Key: "outcome", Value: model.AuditOutcomeFailure},
+		{Key: "target_id", Value: accountId.Hex()},
+		{Key: "details.reason", Value: bson.D{{Key: "$in", Value: credentialFailureReasons}}},
+		{Key: "createdAt", Value: bson.D{{Key: "$gt", Value: since}}},
+	})
+}
+
+/*
+Get the network part of an IP that stays the same on one connection, the /24 of an IPv4 or the /48 of an IPv6
+
+params: ip string The client IP
+
+return: string The network prefix, or the IP itself if it cannot be parsed
+*/
+func deviceIPPrefix(ip string) string {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ip
+	}
+	if ipv4 := parsedIP.To4(); ipv4 != nil {
+		return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	return (&net.IPNet{IP: parsedIP.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
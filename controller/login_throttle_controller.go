@@ -0,0 +1,535 @@
+/*
+Controller for handling data with LoginThrottle and LockoutEvent models in DB
+
+1. GetLoginLockouts: Get the usernames and IPs that are locked out
+
+2. UnlockLogin: Unlock a username or an IP
+
+3. GetLockoutEvents: Get the history of lockouts and unlocks
+
+4. CheckLoginThrottle: Count a login attempt if it is allowed
+
+5. RecordLoginFailure: Lock out after too many failed login attempts
+
+6. ResetLoginFailures: Forget the failed attempts of a username after a successful login
+
+7. EnsureLoginThrottleIndex: Create the unique index of the throttled usernames and IPs, at startup
+
+8. AllowLoginAttempt: Count a login attempt and answer 429 if it must wait
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Hash compared against when the username does not exist, computed on the first use
+var dummyPasswordHash string
+var dummyPasswordHashOnce sync.Once
+
+// A username or IP the login attempts are counted for
+type loginThrottleKey struct {
+	kind  string // model.LoginThrottleUsername or model.LoginThrottleIP
+	value string
+}
+
+type unlockLogin_struct struct {
+	Kind  string `json:"kind" validate:"required,oneof=username ip"`
+	Value string `json:"value" validate:"required"`
+}
+
+/*
+Get the usernames and IPs that are currently locked out
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the lockouts
+*/
+func GetLoginLockouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Create an array of the LoginThrottle model
+		var lockouts []model.LoginThrottle
+
+		result, queryErr := loginThrottleCollection.Find(
+			ctx,
+			bson.M{"lockedUntil": bson.M{"$gt": time.Now().Unix()}},
+			options.Find().SetSort(bson.D{{Key: "lockedUntil", Value: -1}}),
+		)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying lockouts: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the lockouts array
+		decodeErr := result.All(ctx, &lockouts)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding lockouts: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"count":    len(lockouts),
+			"lockouts": Redact(c, lockouts, model.LoginThrottle{}),
+		})
+	}
+}
+
+/*
+Unlock a username or an IP and clear its failed attempts
+
+params: None
+
+return: gin.HandlerFunc Handler function to unlock a login
+*/
+func UnlockLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request unlockLogin_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Usernames are throttled in lower case
+		request.Value = strings.TrimSpace(request.Value)
+		if request.Kind == model.LoginThrottleUsername {
+			request.Value = strings.ToLower(request.Value)
+		}
+
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid lockout",
+			})
+			return
+		}
+
+		result, deleteErr := loginThrottleCollection.DeleteOne(ctx, bson.M{"kind": request.Kind, "value": request.Value})
+		if deleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error unlocking: "+deleteErr.Error())
+			return
+		}
+
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "No failed attempts recorded",
+			})
+			return
+		}
+
+		recordLockoutEvent(ctx, model.LockoutEvent{
+			Action: model.LockoutEventUnlocked,
+			Kind:   request.Kind,
+			Value:  request.Value,
+			By:     CurrentEmployeeId(c),
+		})
+		RecordAuditEvent(c, model.AuditLoginUnlock, model.AuditOutcomeSuccess, request.Kind, request.Value, nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Unlocked",
+		})
+	}
+}
+
+/*
+Get the history of lockouts and unlocks, the kind and value query parameters filter it
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the lockout events
+*/
+func GetLockoutEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Create an array of the LockoutEvent model
+		var events []model.LockoutEvent
+
+		filter := bson.M{}
+		if kind := c.Query("kind"); kind != "" {
+			filter["kind"] = kind
+		}
+		if value := c.Query("value"); value != "" {
+			filter["value"] = value
+		}
+
+		// Get the newest events first
+		result, queryErr := lockoutEventCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(200))
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying lockout events: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the events array
+		decodeErr := result.All(ctx, &events)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding lockout events: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"count":   len(events),
+			"events":  Redact(c, events, model.LockoutEvent{}),
+		})
+	}
+}
+
+/*
+Count a login attempt for a username from an IP, if it is allowed right now.
+The attempt counts as a failure until ResetLoginFailures, and sets the backoff of the next one in the same operation, so parallel attempts cannot pass together
+
+params: ctx context.Context Context of the DB operations
+
+username string The username of the attempt
+
+clientIP string The IP of the client
+
+return: int64 The number of seconds to wait before the next attempt, 0 if allowed
+
+error The error of the DB query
+*/
+func CheckLoginThrottle(ctx context.Context, username, clientIP string) (int64, error) {
+	keys := []loginThrottleKey{
+		{kind: model.LoginThrottleUsername, value: strings.ToLower(username)},
+		{kind: model.LoginThrottleIP, value: clientIP},
+	}
+
+	// Wait for the latest of the lockouts and backoffs
+	var retryAfter int64
+	var counted []loginThrottleKey
+	for _, key := range keys {
+		wait, attemptErr := countThrottleAttempt(ctx, key)
+		if attemptErr != nil {
+			return 0, attemptErr
+		}
+		if wait > retryAfter {
+			retryAfter = wait
+		}
+		if wait == 0 {
+			counted = append(counted, key)
+		}
+	}
+
+	// A refused attempt is not counted on the keys that allowed it
+	if retryAfter > 0 {
+		for _, key := range counted {
+			releaseThrottleAttempt(ctx, key)
+		}
+	}
+
+	return retryAfter, nil
+}
+
+/*
+Lock out the username and the IP of a failed login attempt if they reached their number of failures, the attempt was counted by CheckLoginThrottle
+
+params: ctx context.Context Context of the DB operations
+
+username string The username of the attempt
+
+clientIP string The IP of the client
+*/
+func RecordLoginFailure(ctx context.Context, username, clientIP string) {
+	lockOutThrottle(ctx, loginThrottleKey{kind: model.LoginThrottleUsername, value: strings.ToLower(username)}, clientIP, GetEnvInt("LOGIN_MAX_FAILURES", 5))
+	lockOutThrottle(ctx, loginThrottleKey{kind: model.LoginThrottleIP, value: clientIP}, clientIP, GetEnvInt("LOGIN_MAX_IP_FAILURES", 20))
+}
+
+/*
+Forget the failed attempts and lockouts of a username after a successful login, and the attempt counted for the IP
+
+params: ctx context.Context Context of the DB operations
+
+username string The username that logged in
+
+clientIP string The IP of the client
+*/
+func ResetLoginFailures(ctx context.Context, username, clientIP string) {
+	_, _ = loginThrottleCollection.DeleteOne(ctx, bson.M{"kind": model.LoginThrottleUsername, "value": strings.ToLower(username)})
+	releaseThrottleAttempt(ctx, loginThrottleKey{kind: model.LoginThrottleIP, value: clientIP})
+}
+
+/*
+Create the unique index of the throttle keys, so parallel first attempts count on one document.
+Runs at startup, a server without it could count a burst of attempts on separate documents
+
+return: error The error of the DB operation, like a username or IP counted twice
+*/
+func EnsureLoginThrottleIndex() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, indexErr := loginThrottleCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "kind", Value: 1}, {Key: "value", Value: 1}},
+		Options: options.Index().SetName("kind_value").SetUnique(true),
+	})
+	if indexErr != nil {
+		return errors.New("[Login] Error creating the throttle index: " + indexErr.Error())
+	}
+
+	return nil
+}
+
+/*
+Count an attempt to prove the credentials of a username, and answer 429 with retryAfter while the username or the IP is backing off or locked out
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+username string The username of the attempt
+
+action string The audit action of a refused attempt
+
+return: bool True if the attempt may go on, false if the response was sent
+*/
+func AllowLoginAttempt(ctx context.Context, c *gin.Context, username, action string) bool {
+	retryAfter, throttleErr := CheckLoginThrottle(ctx, username, c.ClientIP())
+	if throttleErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error checking login attempts: "+throttleErr.Error())
+		return false
+	}
+	if retryAfter > 0 {
+		RecordAuditEvent(c, action, model.AuditOutcomeFailure, "account", "", gin.H{"username": username, "reason": "throttled"})
+		c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"success":    false,
+			"message":    "Too many failed login attempts, try again later",
+			"retryAfter": retryAfter,
+		})
+		return false
+	}
+
+	return true
+}
+
+/*
+Get the username of an account, the key its login attempts are counted by
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: string The username
+
+error The error if the account cannot be found
+*/
+func getAccountUsername(ctx context.Context, accountId primitive.ObjectID) (string, error) {
+	var account model.Account
+	queryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+
+	return account.Username, queryErr
+}
+
+/*
+Count an attempt on one throttle key if it is not backing off or locked out, and set the backoff of the next attempt, in one operation
+
+params: ctx context.Context Context of the DB operations
+
+key loginThrottleKey The username or IP
+
+return: int64 The number of seconds to wait before the next attempt, 0 if the attempt was counted
+
+error The error of the DB operation
+*/
+func countThrottleAttempt(ctx context.Context, key loginThrottleKey) (int64, error) {
+	now := time.Now().Unix()
+	windowStart := now - int64(GetEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 15))*60
+	backoffSeconds := GetEnvInt("LOGIN_BACKOFF_SECONDS", 1)
+
+	// Failures older than the window are not counted anymore, each counted one doubles the delay before the next attempt
+	update := mongo.Pipeline{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "allowed", Value: bson.D{{Key: "$and", Value: bson.A{
+				bson.D{{Key: "$lte", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$lockedUntil", 0}}}, now}}},
+				bson.D{{Key: "$lte", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$nextAttemptAt", 0}}}, now}}},
+			}}}},
+			{Key: "recentFailures", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$lt", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$lastFailureAt", 0}}}, windowStart}}},
+				0,
+				bson.D{{Key: "$ifNull", Value: bson.A{"$failures", 0}}},
+			}}}},
+		}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "failures", Value: bson.D{{Key: "$cond", Value: bson.A{"$allowed", bson.D{{Key: "$add", Value: bson.A{"$recentFailures", 1}}}, "$failures"}}}},
+			{Key: "nextAttemptAt", Value: bson.D{{Key: "$cond", Value: bson.A{
+				"$allowed",
+				bson.D{{Key: "$toLong", Value: bson.D{{Key: "$add", Value: bson.A{now, bson.D{{Key: "$min", Value: bson.A{
+					60,
+					bson.D{{Key: "$multiply", Value: bson.A{backoffSeconds, bson.D{{Key: "$pow", Value: bson.A{2, "$recentFailures"}}}}}},
+				}}}}}}}},
+				"$nextAttemptAt",
+			}}}},
+			{Key: "lastFailureAt", Value: bson.D{{Key: "$cond", Value: bson.A{"$allowed", now, "$lastFailureAt"}}}},
+			{Key: "lockouts", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$lockouts", 0}}}},
+			{Key: "lockedUntil", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$lockedUntil", 0}}}},
+			{Key: "createdAt", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$createdAt", now}}}},
+			{Key: "updatedAt", Value: now},
+		}}},
+		bson.D{{Key: "$unset", Value: bson.A{"allowed", "recentFailures"}}},
+	}
+
+	// The document before the update tells if the attempt was allowed
+	var throttle model.LoginThrottle
+	updateErr := loginThrottleCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "kind", Value: key.kind},
+			{Key: "value", Value: key.value},
+		},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&throttle)
+	if updateErr == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if updateErr != nil {
+		return 0, updateErr
+	}
+
+	blockedUntil := throttle.NextAttemptAt
+	if throttle.LockedUntil > blockedUntil {
+		blockedUntil = throttle.LockedUntil
+	}
+	if blockedUntil > now {
+		return blockedUntil - now, nil
+	}
+
+	return 0, nil
+}
+
+/*
+Stop counting an attempt that was refused or succeeded, and its backoff
+
+params: ctx context.Context Context of the DB operations
+
+key loginThrottleKey The username or IP
+*/
+func releaseThrottleAttempt(ctx context.Context, key loginThrottleKey) {
+	_, _ = loginThrottleCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "kind", Value: key.kind},
+			{Key: "value", Value: key.value},
+			{Key: "failures", Value: bson.D{{Key: "$gt", Value: 0}}},
+		},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "failures", Value: -1}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "nextAttemptAt", Value: 0},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+}
+
+/*
+Lock out one throttle key once it counted too many failures
+
+params: ctx context.Context Context of the DB operations
+
+key loginThrottleKey The username or IP
+
+clientIP string The IP of the client, recorded in the lockout event
+
+maxFailures int Number of failures before a lockout
+*/
+func lockOutThrottle(ctx context.Context, key loginThrottleKey, clientIP string, maxFailures int) {
+	var throttle model.LoginThrottle
+	queryErr := loginThrottleCollection.FindOne(ctx, bson.M{"kind": key.kind, "value": key.value}).Decode(&throttle)
+	if queryErr != nil || throttle.Failures < maxFailures {
+		return
+	}
+
+	// Lock out, each lockout since the last successful login lasts twice as long, up to a day
+	now := time.Now()
+	lockout := time.Duration(GetEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute * time.Duration(math.Pow(2, float64(throttle.Lockouts)))
+	if lockout > 24*time.Hour {
+		lockout = 24 * time.Hour
+	}
+	lockedUntil := now.Add(lockout).Unix()
+
+	// Only one of parallel failures locks out
+	result, lockErr := loginThrottleCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: throttle.Id},
+			{Key: "lockouts", Value: throttle.Lockouts},
+			{Key: "failures", Value: bson.D{{Key: "$gte", Value: maxFailures}}},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "failures", Value: 0},
+				{Key: "nextAttemptAt", Value: 0},
+				{Key: "lockedUntil", Value: lockedUntil},
+				{Key: "updatedAt", Value: now.Unix()},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "lockouts", Value: 1}}},
+		},
+	)
+	if lockErr != nil || result.ModifiedCount == 0 {
+		return
+	}
+
+	recordLockoutEvent(ctx, model.LockoutEvent{
+		Action:      model.LockoutEventLocked,
+		Kind:        key.kind,
+		Value:       key.value,
+		ClientIP:    clientIP,
+		LockedUntil: lockedUntil,
+	})
+}
+
+/*
+Record a lockout or unlock for later review
+
+params: ctx context.Context Context of the DB operations
+
+event model.LockoutEvent The event, its ID and timestamp are set here
+*/
+func recordLockoutEvent(ctx context.Context, event model.LockoutEvent) {
+	event.Id = primitive.NewObjectID()
+	event.CreatedAt = time.Now().Unix()
+	_, _ = lockoutEventCollection.InsertOne(ctx, event)
+}
+
+/*
+Compare a password against a dummy hash, so a login with an unknown username takes as long as one with a wrong password
+
+params: password string The entered password
+*/
+func VerifyDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPassword, _ := GenerateToken()
+		dummyPasswordHash, _ = HashPassword(dummyPassword)
+	})
+
+	VerifyPassword(password, dummyPasswordHash)
+}
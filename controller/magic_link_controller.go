@@ -0,0 +1,373 @@
+/*
+Controller for handling data with MagicLink model in DB
+
+1. RequestMagicLink: Email a login link for a username or email
+
+2. ConfirmMagicLink: Log in with the token of a login link
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purpose claim of magic link tokens, so no other token signed with the same key is accepted
+const magicLinkPurpose = "magic_link"
+
+type requestMagicLink_struct struct {
+	Identifier string `json:"identifier"` // Username or email
+}
+
+type confirmMagicLink_struct struct {
+	Token string `json:"token"`
+}
+
+var errMagicLinkCooldown = errors.New("a login link was sent recently")
+
+/*
+Email a login link to the owner of a username or email, if the authorization level of the account allows it
+
+params: None
+
+return: gin.HandlerFunc Handler function to request a login link
+*/
+func RequestMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request requestMagicLink_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		request.Identifier = strings.TrimSpace(request.Identifier)
+		if request.Identifier == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Username or email is required",
+			})
+			return
+		}
+
+		// Look the account up and send the link in the background, so every request answers the same and as fast, to not reveal which accounts exist
+		go requestMagicLink(c.Copy(), request.Identifier)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "If the account can log in by email, a login link has been sent to its email",
+		})
+	}
+}
+
+/*
+Log in with the token of a login link, the link is used up even if two-factor authentication is still needed
+
+params: None
+
+return: gin.HandlerFunc Handler function to confirm a login link
+*/
+func ConfirmMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request confirmMagicLink_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		magicLinkId, parsingErr := parseMagicLinkToken(request.Token)
+		if parsingErr != nil {
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", "", gin.H{"method": "magic_link", "reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// Mark the link as used in one operation, so it cannot be used twice
+		var magicLink model.MagicLink
+		useErr := magicLinkCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: magicLinkId},
+				{Key: "used", Value: false},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "used", Value: true},
+					{Key: "usedAt", Value: time.Now().Unix()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&magicLink)
+		if useErr != nil {
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", "", gin.H{"method": "magic_link", "reason": "used_or_expired"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// The level may have stopped allowing login links since the link was sent
+		allowed, allowedErr := accountAllowsMagicLink(ctx, magicLink.AccountId)
+		if allowedErr != nil || !allowed {
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", magicLink.AccountId.Hex(), gin.H{"method": "magic_link", "reason": "not_allowed"})
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Login by email is not enabled for your account",
+			})
+			return
+		}
+
+		// The link replaces the password only, ask for the second factor before creating a session
+		requireTwoFactor, _ := AccountRequiresTwoFactor(ctx, magicLink.AccountId)
+		challengeKind := GetLoginChallengeKind(ctx, magicLink.AccountId, requireTwoFactor)
+		if challengeKind != "" {
+			challengeToken, challengeErr := CreateLoginChallenge(ctx, magicLink.AccountId, challengeKind)
+			if challengeErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error creating login challenge: "+challengeErr.Error())
+				return
+			}
+
+			// Send the challenge to client, the login continues on /login/two-factor
+			c.JSON(http.StatusAccepted, gin.H{
+				"success":        false,
+				"message":        "Two-factor authentication required",
+				"twoFactor":      challengeKind,
+				"challengeToken": challengeToken,
+			})
+			return
+		}
+
+		levelName, levelErr := GetAccountLevelName(ctx, magicLink.AccountId)
+		if levelErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// Create a session and send the token cookies to client
+		sessionErr := CreateSession(ctx, c, magicLink.AccountId, levelName)
+		if sessionErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error creating session: "+sessionErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeSuccess, "account", magicLink.AccountId.Hex(), gin.H{"method": "magic_link"})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Login successful",
+			"level":   levelName,
+		})
+	}
+}
+
+/*
+Send a login link to the owner of a username or email, only if the account exists and may use it. Errors are logged
+
+params: c *gin.Context Copy of the context of the request
+
+identifier string Username or email
+*/
+func requestMagicLink(c *gin.Context, identifier string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+	defer cancel()
+
+	accountId, findErr := FindAccountIdByIdentifier(ctx, identifier)
+	if findErr != nil {
+		RecordAuditEvent(c, model.AuditMagicLinkRequest, model.AuditOutcomeFailure, "account", "", gin.H{"identifier": identifier, "reason": "unknown_account"})
+		return
+	}
+	if pending, pendingErr := AccountPending(ctx, accountId); pending || pendingErr != nil {
+		RecordAuditEvent(c, model.AuditMagicLinkRequest, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"identifier": identifier, "reason": "account_pending"})
+		return
+	}
+	if allowed, _ := accountAllowsMagicLink(ctx, accountId); !allowed {
+		RecordAuditEvent(c, model.AuditMagicLinkRequest, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"identifier": identifier, "reason": "not_allowed"})
+		return
+	}
+
+	sendErr := startMagicLink(ctx, accountId)
+	if sendErr != nil && sendErr != errMagicLinkCooldown {
+		log.Println("[MAGIC LINK] Error sending login link to account " + accountId.Hex() + ": " + sendErr.Error())
+		return
+	}
+	RecordAuditEvent(c, model.AuditMagicLinkRequest, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"identifier": identifier, "sent": sendErr == nil})
+}
+
+/*
+Create a single use login link for an account and email it to its owner, at most one per magicLinkCooldown
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: error errMagicLinkCooldown if a link was just sent, or the error if the link cannot be created or the email cannot be sent
+*/
+func startMagicLink(ctx context.Context, accountId primitive.ObjectID) error {
+	// Do not let anyone flood the inbox of an account
+	recentCount, countErr := magicLinkCollection.CountDocuments(ctx, bson.D{
+		{Key: "account_id", Value: accountId},
+		{Key: "createdAt", Value: bson.D{{Key: "$gt", Value: time.Now().Add(-magicLinkCooldown).Unix()}}},
+	})
+	if countErr != nil {
+		return countErr
+	}
+	if recentCount > 0 {
+		return errMagicLinkCooldown
+	}
+
+	email, fullname, contactErr := GetAccountContact(ctx, accountId)
+	if contactErr != nil {
+		return contactErr
+	}
+
+	// Only the latest link of an account can be used
+	_, invalidateErr := magicLinkCollection.UpdateMany(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "used", Value: false},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "used", Value: true},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if invalidateErr != nil {
+		return invalidateErr
+	}
+
+	magicLink := model.MagicLink{
+		Id:        primitive.NewObjectID(),
+		AccountId: accountId,
+		ExpiresAt: time.Now().Add(magicLinkLifetime).Unix(),
+		CreatedAt: time.Now().Unix(),
+		UpdatedAt: time.Now().Unix(),
+	}
+	token, signingErr := signMagicLinkToken(magicLink)
+	if signingErr != nil {
+		return signingErr
+	}
+
+	_, insertErr := magicLinkCollection.InsertOne(ctx, magicLink)
+	if insertErr != nil {
+		return insertErr
+	}
+
+	// The frontend page posts the token, so mail scanners opening the link do not use it up
+	link := os.Getenv("FRONTEND_URL") + "/magic-login?token=" + url.QueryEscape(token)
+	if !SendMagicLinkEmail(email, fullname, link) {
+		return errors.New("error sending email")
+	}
+
+	return nil
+}
+
+/*
+Sign the token of a login link with the primary signing key
+
+params: magicLink model.MagicLink The login link
+
+return: string The signed token
+
+error The error if the keyring has no signing key
+*/
+func signMagicLinkToken(magicLink model.MagicLink) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{
+		"purpose":    magicLinkPurpose,
+		"jti":        magicLink.Id.Hex(),
+		"account_id": magicLink.AccountId.Hex(),
+		"exp":        magicLink.ExpiresAt,
+	})
+
+	kid, signingKey, keyErr := config.PrimaryKey(model.KeyPurposeSigning)
+	if keyErr != nil {
+		return "", keyErr
+	}
+	token.Header["kid"] = kid
+	return token.SignedString(signingKey)
+}
+
+/*
+Verify the signature and expiration of the token of a login link
+
+params: tokenString string The signed token
+
+return: primitive.ObjectID ID of the login link
+
+error The error if the token is invalid or expired
+*/
+func parseMagicLinkToken(tokenString string) (primitive.ObjectID, error) {
+	token, parsingErr := jwt.Parse(strings.TrimSpace(tokenString), func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return config.LookupKey(model.KeyPurposeSigning, kid)
+	})
+	if parsingErr != nil {
+		return primitive.NilObjectID, parsingErr
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != magicLinkPurpose || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return primitive.NilObjectID, errors.New("invalid login link")
+	}
+
+	magicLinkId, _ := claims["jti"].(string)
+	return primitive.ObjectIDFromHex(magicLinkId)
+}
+
+/*
+Check if the authorization level of an account allows logging in with a login link
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: bool True if login links are allowed
+
+error The error if the account or its authorization cannot be found
+*/
+func accountAllowsMagicLink(ctx context.Context, accountId primitive.ObjectID) (bool, error) {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return false, accountQueryErr
+	}
+
+	var authorization model.Authorization
+	authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": account.Account_Authorization_Id}).Decode(&authorization)
+	if authorizationQueryErr != nil {
+		return false, authorizationQueryErr
+	}
+
+	return authorization.AllowMagicLink, nil
+}
@@ -27,6 +27,11 @@ func CreateMessage() gin.HandlerFunc {
 			return
 		}
 
+		// Only leaders and contributors of the project can post messages
+		if !CheckProjectAccess(ctx, c, message.Project, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
 		// Set the Id and timestamps for the message
 		message.Id = primitive.NewObjectID()
 		message.CreatedAt = time.Now().Unix()
@@ -136,7 +141,7 @@ func GetMessageById() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"message": message,
+			"message": Redact(c, message, model.Message{}),
 		})
 	}
 }
@@ -156,6 +161,11 @@ func GetMessageByProject() gin.HandlerFunc {
 			return
 		}
 
+		// Only members of the project can read its messages
+		if !CheckProjectAccess(ctx, c, queryId) {
+			return
+		}
+
 		// Create an array for the employees
 		var message []gin.H
 
@@ -226,7 +236,7 @@ func GetMessageByProject() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"message": message,
+			"message": Redact(c, message, model.Message{}),
 		})
 	}
 }
@@ -0,0 +1,480 @@
+/*
+Controller for handling data with PasswordPolicy model in DB
+
+1. GetPasswordPolicy: Get the password rules
+
+2. UpdatePasswordPolicy: Replace the password rules
+
+3. LoginChangePassword: Set a new password during a login whose password expired
+
+4. LoadPasswordPolicy: Get the password rules, or the defaults if they were never saved
+
+5. ValidateNewPassword: List the rules that a new password of an account breaks
+
+6. SetAccountPassword: Replace the password of an account and remember the old one
+
+7. PasswordExpired: Check if the password of an account is older than the policy allows
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type passwordPolicy_struct struct {
+	MinLength       int      `json:"min_length"`
+	RequireUpper    bool     `json:"require_upper"`
+	RequireLower    bool     `json:"require_lower"`
+	RequireNumber   bool     `json:"require_number"`
+	RequireSpecial  bool     `json:"require_special"`
+	BannedPasswords []string `json:"banned_passwords"`
+	HistoryCount    int      `json:"history_count"`
+	MaxAgeDays      int      `json:"max_age_days"`
+}
+
+type loginChangePassword_struct struct {
+	ChallengeToken string `json:"challenge_token"`
+	NewPassword    string `json:"new_password"`
+	RenewPassword  string `json:"renew_password"`
+}
+
+var errPasswordChanged = errors.New("the password was changed by another request")
+
+/*
+Get the password rules, so forms of a logged in account can show them before a password is sent
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the password policy
+*/
+func GetPasswordPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		policy, policyErr := LoadPasswordPolicy(ctx)
+		if policyErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, Redact(c, policy, model.PasswordPolicy{}))
+	}
+}
+
+/*
+Replace the password rules, existing passwords only need to follow them when they are changed or expire
+
+params: None
+
+return: gin.HandlerFunc Handler function to update the password policy
+*/
+func UpdatePasswordPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request passwordPolicy_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Banned passwords are compared ignoring case, store them once in lower case
+		bannedPasswords := []string{}
+		seen := map[string]bool{}
+		for _, banned := range request.BannedPasswords {
+			banned = strings.ToLower(strings.TrimSpace(banned))
+			if banned != "" && !seen[banned] {
+				seen[banned] = true
+				bannedPasswords = append(bannedPasswords, banned)
+			}
+		}
+
+		policy := model.PasswordPolicy{
+			MinLength:       request.MinLength,
+			RequireUpper:    request.RequireUpper,
+			RequireLower:    request.RequireLower,
+			RequireNumber:   request.RequireNumber,
+			RequireSpecial:  request.RequireSpecial,
+			BannedPasswords: bannedPasswords,
+			HistoryCount:    request.HistoryCount,
+			MaxAgeDays:      request.MaxAgeDays,
+			UpdatedBy:       CurrentEmployeeId(c),
+			UpdatedAt:       time.Now().Unix(),
+		}
+		validationErr := validate.Struct(policy)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid password policy: " + validationErr.Error(),
+			})
+			return
+		}
+
+		// There is a single policy document, create it on the first save
+		updateErr := passwordPolicyCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "min_length", Value: policy.MinLength},
+					{Key: "require_upper", Value: policy.RequireUpper},
+					{Key: "require_lower", Value: policy.RequireLower},
+					{Key: "require_number", Value: policy.RequireNumber},
+					{Key: "require_special", Value: policy.RequireSpecial},
+					{Key: "banned_passwords", Value: policy.BannedPasswords},
+					{Key: "history_count", Value: policy.HistoryCount},
+					{Key: "max_age_days", Value: policy.MaxAgeDays},
+					{Key: "updatedBy", Value: policy.UpdatedBy},
+					{Key: "updatedAt", Value: policy.UpdatedAt},
+				}},
+			},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+		).Decode(&policy)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating password policy: "+updateErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditPasswordPolicyUpdate, model.AuditOutcomeSuccess, "password_policy", policy.Id.Hex(), gin.H{
+			"min_length":       policy.MinLength,
+			"require_upper":    policy.RequireUpper,
+			"require_lower":    policy.RequireLower,
+			"require_number":   policy.RequireNumber,
+			"require_special":  policy.RequireSpecial,
+			"banned_passwords": len(policy.BannedPasswords),
+			"history_count":    policy.HistoryCount,
+			"max_age_days":     policy.MaxAgeDays,
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Password policy updated",
+			"policy":  Redact(c, policy, model.PasswordPolicy{}),
+		})
+	}
+}
+
+/*
+Set a new password during a login whose password expired, then continue with two-factor authentication if needed
+
+params: None
+
+return: gin.HandlerFunc Handler function to change an expired password during a login
+*/
+func LoginChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request loginChangePassword_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		challenge, challengeErr := findLoginChallenge(ctx, request.ChallengeToken, model.LoginChallengePasswordChange)
+		if challengeErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		if request.NewPassword == "" || request.NewPassword != request.RenewPassword {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "*Passwords do not match",
+			})
+			return
+		}
+
+		var account model.Account
+		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": challenge.AccountId}).Decode(&account)
+		if accountQueryErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		policy, violations, policyErr := ValidateNewPassword(ctx, request.NewPassword, account)
+		if policyErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+			return
+		}
+		if len(violations) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":    false,
+				"message":    "*New password does not meet requirements",
+				"violations": violations,
+			})
+			return
+		}
+
+		hashedPasswordHex, hashingErr := HashPassword(request.NewPassword)
+		if hashingErr != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		setErr := SetAccountPassword(ctx, account, hashedPasswordHex, policy)
+		if setErr != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to update password: "+setErr.Error())
+			return
+		}
+
+		// Sessions started with the expired password must not stay alive
+		revokeErr := RevokeAccountSessions(ctx, account.Id)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditPasswordChange, model.AuditOutcomeSuccess, "account", account.Id.Hex(), gin.H{"reason": "expired"})
+
+		// The new password replaces the expired one only, ask for the second factor before creating a session
+		requireTwoFactor, _ := AccountRequiresTwoFactor(ctx, account.Id)
+		challengeKind := GetLoginChallengeKind(ctx, account.Id, requireTwoFactor)
+		if challengeKind == "" {
+			completeLogin(ctx, c, challenge, nil)
+			return
+		}
+
+		if !useLoginChallenge(ctx, challenge.Id) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		challengeToken, createErr := CreateLoginChallenge(ctx, account.Id, challengeKind)
+		if createErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error creating login challenge: "+createErr.Error())
+			return
+		}
+
+		// Send the challenge to client, the login continues on /login/two-factor
+		c.JSON(http.StatusAccepted, gin.H{
+			"success":        false,
+			"message":        "Two-factor authentication required",
+			"twoFactor":      challengeKind,
+			"challengeToken": challengeToken,
+		})
+	}
+}
+
+/*
+Get the password rules saved by admins, or the rules from the environment if none were saved yet
+
+params: ctx context.Context Context of the DB operations
+
+return: model.PasswordPolicy The password rules
+
+error The error of the DB operation
+*/
+func LoadPasswordPolicy(ctx context.Context) (model.PasswordPolicy, error) {
+	var policy model.PasswordPolicy
+	queryErr := passwordPolicyCollection.FindOne(ctx, bson.M{}).Decode(&policy)
+	if queryErr == mongo.ErrNoDocuments {
+		return model.PasswordPolicy{
+			MinLength:       GetEnvInt("PASSWORD_LENGTH", 8),
+			RequireUpper:    os.Getenv("USE_UPPER") == "true",
+			RequireNumber:   os.Getenv("USE_NUMBER") == "true",
+			RequireSpecial:  os.Getenv("USE_SPECIAL") == "true",
+			BannedPasswords: model.DefaultBannedPasswords,
+		}, nil
+	}
+
+	return policy, queryErr
+}
+
+/*
+Check a new password of an account against the password policy
+
+params: ctx context.Context Context of the DB operations
+
+password string The new plaintext password
+
+account model.Account The account, its current and previous passwords cannot be used again when the policy remembers them
+
+return: model.PasswordPolicy The policy that was checked
+
+[]string The broken rules, empty if the password can be used
+
+error The error if the policy cannot be loaded
+*/
+func ValidateNewPassword(ctx context.Context, password string, account model.Account) (model.PasswordPolicy, []string, error) {
+	policy, policyErr := LoadPasswordPolicy(ctx)
+	if policyErr != nil {
+		return policy, nil, policyErr
+	}
+
+	violations := []string{}
+	if len([]rune(password)) < policy.MinLength {
+		violations = append(violations, "min_length")
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSpecial bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasNumber = true
+		case !unicode.IsLetter(char) && !unicode.IsSpace(char):
+			hasSpecial = true
+		}
+	}
+	if policy.RequireUpper && !hasUpper {
+		violations = append(violations, "require_upper")
+	}
+	if policy.RequireLower && !hasLower {
+		violations = append(violations, "require_lower")
+	}
+	if policy.RequireNumber && !hasNumber {
+		violations = append(violations, "require_number")
+	}
+	if policy.RequireSpecial && !hasSpecial {
+		violations = append(violations, "require_special")
+	}
+
+	for _, banned := range policy.BannedPasswords {
+		if strings.EqualFold(password, banned) {
+			violations = append(violations, "banned")
+			break
+		}
+	}
+
+	// Each hash takes a while to check, compare the remembered ones at the same time
+	if policy.HistoryCount > 0 && account.Password != "" {
+		hashes := append([]string{account.Password}, rememberedPasswords(account.PasswordHistory, policy.HistoryCount-1)...)
+		reused := make([]bool, len(hashes))
+		var waitGroup sync.WaitGroup
+		for i, hash := range hashes {
+			waitGroup.Add(1)
+			go func(i int, hash string) {
+				defer waitGroup.Done()
+				reused[i] = VerifyPassword(password, hash)
+			}(i, hash)
+		}
+		waitGroup.Wait()
+
+		for _, match := range reused {
+			if match {
+				violations = append(violations, "reused")
+				break
+			}
+		}
+	}
+
+	return policy, violations, nil
+}
+
+/*
+Replace the password of an account, keep the old hash as long as the policy remembers it and restart the password age
+
+params: ctx context.Context Context of the DB operations
+
+account model.Account The account as it was read, the update fails if its password changed since
+
+hashedPasswordHex string Hash of the new password
+
+policy model.PasswordPolicy The password policy
+
+return: error errPasswordChanged if another request changed the password first, or the error of the DB operation
+*/
+func SetAccountPassword(ctx context.Context, account model.Account, hashedPasswordHex string, policy model.PasswordPolicy) error {
+	// The current password is checked apart, the history keeps only the ones before it
+	history := []string{}
+	if policy.HistoryCount > 1 {
+		history = rememberedPasswords(append(account.PasswordHistory, account.Password), policy.HistoryCount-1)
+	}
+
+	result, updateErr := accountCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: account.Id},
+			{Key: "password", Value: account.Password},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "password", Value: hashedPasswordHex},
+				{Key: "password_history", Value: history},
+				{Key: "passwordChangedAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if updateErr != nil {
+		return updateErr
+	}
+	if result.MatchedCount == 0 {
+		return errPasswordChanged
+	}
+
+	return nil
+}
+
+/*
+Check if the password of an account is older than the maximum age of the policy
+
+params: policy model.PasswordPolicy The password policy
+
+account model.Account The account, its creation time counts if the password was never changed
+
+return: bool True if the password must be changed before logging in
+*/
+func PasswordExpired(policy model.PasswordPolicy, account model.Account) bool {
+	if policy.MaxAgeDays <= 0 {
+		return false
+	}
+
+	changedAt := account.PasswordChangedAt
+	if changedAt == 0 {
+		changedAt = account.CreatedAt
+	}
+
+	return time.Now().After(time.Unix(changedAt, 0).AddDate(0, 0, policy.MaxAgeDays))
+}
+
+/*
+Keep the newest hashes of a password history
+
+params: history []string Hashes of the previous passwords, newest last
+
+count int Number of hashes to keep
+
+return: []string The newest hashes, newest last
+*/
+func rememberedPasswords(history []string, count int) []string {
+	if count <= 0 {
+		return []string{}
+	}
+	if len(history) > count {
+		history = history[len(history)-count:]
+	}
+
+	return append([]string{}, history...)
+}
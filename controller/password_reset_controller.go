@@ -0,0 +1,423 @@
+/*
+Controller for handling data with PasswordReset model in DB
+
+1. ForgotPassword: Email a password reset link for a username or email
+
+2. ConfirmPasswordReset: Set a new password with the token of a reset link
+
+3. StartPasswordReset: Create a reset token for an account and email the link
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type forgotPassword_struct struct {
+	Identifier string `json:"identifier"` // Username or email
+}
+
+type confirmPasswordReset_struct struct {
+	Token         string `json:"token"`
+	NewPassword   string `json:"new_password"`
+	RenewPassword string `json:"renew_password"`
+}
+
+/*
+Email a password reset link to the owner of a username or email
+
+params: None
+
+return: gin.HandlerFunc Handler function to request a password reset
+*/
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request forgotPassword_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		request.Identifier = strings.TrimSpace(request.Identifier)
+		if request.Identifier == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Username or email is required",
+			})
+			return
+		}
+
+		// Look the account up and send the link in the background, so every request answers the same and as fast, to not reveal which accounts exist
+		go requestPasswordReset(c.Copy(), request.Identifier)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "If the account exists, a password reset link has been sent to its email",
+		})
+	}
+}
+
+/*
+Set a new password with the token of a password reset link
+
+params: None
+
+return: gin.HandlerFunc Handler function to confirm a password reset
+*/
+func ConfirmPasswordReset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request confirmPasswordReset_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Check the new password before using up the token
+		if request.NewPassword == "" || request.NewPassword != request.RenewPassword {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "*Passwords do not match",
+			})
+			return
+		}
+
+		// Find the account of the token without using it up, a password that breaks the policy can be corrected
+		var pendingReset model.PasswordReset
+		pendingErr := passwordResetCollection.FindOne(ctx, bson.D{
+			{Key: "token_hash", Value: HashToken(request.Token)},
+			{Key: "used", Value: false},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		}).Decode(&pendingReset)
+		var account model.Account
+		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": pendingReset.AccountId}).Decode(&account)
+		if pendingErr != nil || accountQueryErr != nil {
+			RecordAuditEvent(c, model.AuditPasswordResetConfirm, model.AuditOutcomeFailure, "account", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// Invited accounts get their first password from the invitation
+		pending, pendingErr := AccountPending(ctx, account.Id)
+		if pendingErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying employee: "+pendingErr.Error())
+			return
+		}
+		if pending {
+			RecordAuditEvent(c, model.AuditPasswordResetConfirm, model.AuditOutcomeFailure, "account", account.Id.Hex(), gin.H{"reason": "account_pending"})
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Accept your invitation to activate the account",
+				"reason":  "account_pending",
+			})
+			return
+		}
+
+		policy, violations, policyErr := ValidateNewPassword(ctx, request.NewPassword, account)
+		if policyErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying password policy: "+policyErr.Error())
+			return
+		}
+		if len(violations) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":    false,
+				"message":    "*New password does not meet requirements",
+				"violations": violations,
+			})
+			return
+		}
+
+		hashedPasswordHex, hashingErr := HashPassword(request.NewPassword)
+		if hashingErr != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+
+		// Mark the token as used in one operation, so it cannot be used twice
+		var passwordReset model.PasswordReset
+		useErr := passwordResetCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: pendingReset.Id},
+				{Key: "used", Value: false},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "used", Value: true},
+					{Key: "usedAt", Value: time.Now().Unix()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&passwordReset)
+		if useErr != nil {
+			RecordAuditEvent(c, model.AuditPasswordResetConfirm, model.AuditOutcomeFailure, "account", "", gin.H{"reason": "invalid_token"})
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid or expired link",
+			})
+			return
+		}
+
+		// Update the password of the account
+		updateErr := SetAccountPassword(ctx, account, hashedPasswordHex, policy)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Failed to update password")
+			return
+		}
+
+		// Log the account out everywhere after the password changed
+		revokeErr := RevokeAccountSessions(ctx, passwordReset.AccountId)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditPasswordResetConfirm, model.AuditOutcomeSuccess, "account", passwordReset.AccountId.Hex(), nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Password updated",
+		})
+	}
+}
+
+/*
+Create a single use reset token for an account and email the reset link to its owner
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: error The error if the token cannot be created or the email cannot be sent
+*/
+func StartPasswordReset(ctx context.Context, accountId primitive.ObjectID) error {
+	email, fullname, contactErr := GetAccountContact(ctx, accountId)
+	if contactErr != nil {
+		return contactErr
+	}
+
+	token, generateErr := GenerateToken()
+	if generateErr != nil {
+		return generateErr
+	}
+
+	// Only the latest link of an account can be used
+	_, invalidateErr := passwordResetCollection.UpdateMany(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "used", Value: false},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "used", Value: true},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if invalidateErr != nil {
+		return invalidateErr
+	}
+
+	passwordReset := model.PasswordReset{
+		Id:        primitive.NewObjectID(),
+		AccountId: accountId,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenLifetime).Unix(),
+		CreatedAt: time.Now().Unix(),
+		UpdatedAt: time.Now().Unix(),
+	}
+	_, insertErr := passwordResetCollection.InsertOne(ctx, passwordReset)
+	if insertErr != nil {
+		return insertErr
+	}
+
+	link := os.Getenv("FRONTEND_URL") + "/reset-password?token=" + url.QueryEscape(token)
+	if !SendEmailResetPassword(email, fullname, link) {
+		return errors.New("error sending email")
+	}
+
+	return nil
+}
+
+/*
+Send a password reset link to the owner of a username or email, only if the account exists and no unused link was sent within resetCooldown. Errors are logged
+
+params: c *gin.Context Copy of the context of the request
+
+identifier string Username or email
+*/
+func requestPasswordReset(c *gin.Context, identifier string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+	defer cancel()
+
+	accountId, findErr := FindAccountIdByIdentifier(ctx, identifier)
+	if findErr != nil {
+		RecordAuditEvent(c, model.AuditPasswordResetRequest, model.AuditOutcomeFailure, "account", "", gin.H{"identifier": identifier, "reason": "unknown_account"})
+		return
+	}
+
+	// Invited accounts get their first password from the invitation
+	if pending, pendingErr := AccountPending(ctx, accountId); pending || pendingErr != nil {
+		RecordAuditEvent(c, model.AuditPasswordResetRequest, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"identifier": identifier, "reason": "account_pending"})
+		return
+	}
+
+	// Do not let anyone flood the inbox of an account
+	recentCount, countErr := passwordResetCollection.CountDocuments(ctx, bson.D{
+		{Key: "account_id", Value: accountId},
+		{Key: "used", Value: false},
+		{Key: "createdAt", Value: bson.D{{Key: "$gt", Value: time.Now().Add(-resetCooldown).Unix()}}},
+	})
+	if countErr != nil {
+		log.Println("[PASSWORD RESET] Error counting reset links of account " + accountId.Hex() + ": " + countErr.Error())
+		return
+	}
+	if recentCount > 0 {
+		RecordAuditEvent(c, model.AuditPasswordResetRequest, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"identifier": identifier, "reason": "cooldown"})
+		return
+	}
+
+	resetErr := StartPasswordReset(ctx, accountId)
+	if resetErr != nil {
+		log.Println("[PASSWORD RESET] Error starting password reset of account " + accountId.Hex() + ": " + resetErr.Error())
+		return
+	}
+	RecordAuditEvent(c, model.AuditPasswordResetRequest, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"identifier": identifier})
+}
+
+/*
+Find the account with the specified username, or the account of the employee with the specified email
+
+params: ctx context.Context Context of the DB operations
+
+identifier string Username or email
+
+return: primitive.ObjectID ID of the account
+
+error The error if no account matches
+*/
+func FindAccountIdByIdentifier(ctx context.Context, identifier string) (primitive.ObjectID, error) {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"username": identifier}).Decode(&account)
+	if accountQueryErr == nil {
+		return account.Id, nil
+	}
+
+	var userInfor model.UserInfor
+	userInforQueryErr := userInforCollection.FindOne(ctx, PIIFilter("email", identifier)).Decode(&userInfor)
+	if userInforQueryErr != nil {
+		// Guests have no user information, only an email in their guest document
+		var guest model.Guest
+		guestQueryErr := guestCollection.FindOne(ctx, bson.M{"email": identifier}).Decode(&guest)
+		if guestQueryErr != nil {
+			return primitive.NilObjectID, userInforQueryErr
+		}
+		return guest.AccountId, nil
+	}
+
+	var employee model.Employee
+	employeeQueryErr := employeeCollection.FindOne(ctx, bson.M{"userinfor_id": userInfor.Id}).Decode(&employee)
+	if employeeQueryErr != nil {
+		return primitive.NilObjectID, employeeQueryErr
+	}
+
+	return employee.AccountID, nil
+}
+
+/*
+Get the email and full name of the employee or guest owning an account
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: string Email of the employee
+
+string Full name of the employee
+
+error The error if the account has no employee or no email
+*/
+func GetAccountContact(ctx context.Context, accountId primitive.ObjectID) (string, string, error) {
+	var account gin.H
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{Key: "$match", Value: bson.D{
+				{Key: "account_id", Value: accountId},
+			}},
+		},
+		bson.D{
+			{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "user_infor"},
+				{Key: "localField", Value: "userinfor_id"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "userinfor"},
+			}},
+		},
+		bson.D{
+			{Key: "$project", Value: bson.D{
+				{Key: "fullname", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.fullname", 0}},
+				}},
+				{Key: "email", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.email", 0}},
+				}},
+			}},
+		},
+	}
+	accountQueryResult, aggregateErr := employeeCollection.Aggregate(ctx, pipeline)
+	if aggregateErr != nil {
+		return "", "", aggregateErr
+	}
+	defer accountQueryResult.Close(ctx)
+
+	if accountQueryResult.Next(ctx) {
+		decodeErr := accountQueryResult.Decode(&account)
+		if decodeErr != nil {
+			return "", "", decodeErr
+		}
+	}
+
+	// Guests keep their contact in the guest document
+	if account == nil {
+		var guest model.Guest
+		guestQueryErr := guestCollection.FindOne(ctx, bson.M{"account_id": accountId}).Decode(&guest)
+		if guestQueryErr == nil {
+			return guest.Email, guest.Name, nil
+		}
+	}
+
+	emailValue, _ := account["email"].(string)
+	email, decryptErr := config.DecryptPII(emailValue)
+	if decryptErr != nil {
+		return "", "", decryptErr
+	}
+	fullname, _ := account["fullname"].(string)
+	if email == "" {
+		return "", "", errors.New("account has no email")
+	}
+
+	return email, fullname, nil
+}
@@ -0,0 +1,245 @@
+/*
+Controller for handling data with PersonalAccessToken model in DB
+
+1. GetPersonalAccessTokens: Get the personal access tokens of the logged in account
+
+2. CreatePersonalAccessToken: Create a personal access token for the logged in account
+
+3. RevokePersonalAccessToken: Revoke a personal access token of the logged in account
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type createPersonalAccessToken_struct struct {
+	Name          string   `json:"name"`
+	Permissions   []string `json:"permissions"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 if the token does not expire
+}
+
+/*
+Get the personal access tokens of the logged in account, the tokens themselves are never returned again
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the personal access tokens
+*/
+func GetPersonalAccessTokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		// Create an array of the PersonalAccessToken model
+		var accessTokens []model.PersonalAccessToken
+
+		result, queryErr := personalAccessTokenCollection.Find(
+			ctx,
+			bson.M{"account_id": accountId},
+			options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+		)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying access tokens: "+queryErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the access tokens array
+		decodeErr := result.All(ctx, &accessTokens)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding access tokens: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"count":        len(accessTokens),
+			"accessTokens": Redact(c, accessTokens, model.PersonalAccessToken{}),
+		})
+	}
+}
+
+/*
+Create a personal access token for the logged in account, limited to permissions the account has
+
+params: None
+
+return: gin.HandlerFunc Handler function to create a personal access token
+*/
+func CreatePersonalAccessToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// A leaked token must not be able to create more tokens
+		if _, byAccessToken := c.Get("currentAccessToken"); byAccessToken {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Access tokens cannot be created with an access token",
+			})
+			return
+		}
+
+		var request createPersonalAccessToken_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Reject permissions that do not exist
+		if unknownPermissions := FindUnknownPermissions(request.Permissions); len(unknownPermissions) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":     false,
+				"message":     "Unknown permissions",
+				"permissions": unknownPermissions,
+			})
+			return
+		}
+
+		// Reject permissions the account does not have itself
+		var missingPermissions []string
+		for _, permission := range request.Permissions {
+			if !middleware.HasPermission(c, permission) {
+				missingPermissions = append(missingPermissions, permission)
+			}
+		}
+		if len(missingPermissions) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success":     false,
+				"message":     "Permissions not granted to your account",
+				"permissions": missingPermissions,
+			})
+			return
+		}
+
+		if request.ExpiresInDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid expiration",
+			})
+			return
+		}
+
+		token, generateErr := GenerateToken()
+		if generateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error generating token: "+generateErr.Error())
+			return
+		}
+		token = model.PersonalAccessTokenPrefix + token
+
+		if request.Permissions == nil {
+			request.Permissions = []string{}
+		}
+		accessToken := model.PersonalAccessToken{
+			Id:          primitive.NewObjectID(),
+			AccountId:   c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID),
+			Name:        strings.TrimSpace(request.Name),
+			Hint:        token[:len(model.PersonalAccessTokenPrefix)+8],
+			TokenHash:   HashToken(token),
+			Permissions: request.Permissions,
+			CreatedAt:   time.Now().Unix(),
+			UpdatedAt:   time.Now().Unix(),
+		}
+		if request.ExpiresInDays > 0 {
+			accessToken.ExpiresAt = time.Now().AddDate(0, 0, request.ExpiresInDays).Unix()
+		}
+
+		validationErr := validate.Struct(&accessToken)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid access token name",
+			})
+			return
+		}
+
+		_, insertErr := personalAccessTokenCollection.InsertOne(ctx, accessToken)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting access token: "+insertErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditAccessTokenCreate, model.AuditOutcomeSuccess, "access_token", accessToken.Id.Hex(), gin.H{"name": accessToken.Name, "permissions": accessToken.Permissions})
+
+		// The token is shown only once
+		c.JSON(http.StatusCreated, gin.H{
+			"success":     true,
+			"message":     "Access token created",
+			"token":       token,
+			"accessToken": Redact(c, accessToken, model.PersonalAccessToken{}),
+		})
+	}
+}
+
+/*
+Revoke a personal access token of the logged in account
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke a personal access token
+*/
+func RevokePersonalAccessToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		accessTokenId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Access token not found",
+			})
+			return
+		}
+
+		result, updateErr := personalAccessTokenCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: accessTokenId},
+				{Key: "account_id", Value: c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)},
+				{Key: "revoked", Value: false},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "revoked", Value: true},
+					{Key: "revokedAt", Value: time.Now().Unix()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking access token: "+updateErr.Error())
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Access token not found",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditAccessTokenRevoke, model.AuditOutcomeSuccess, "access_token", accessTokenId.Hex(), nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Access token revoked",
+		})
+	}
+}
@@ -0,0 +1,325 @@
+/*
+Controller for the encrypted personal data fields of the UserInfor model
+
+1. RunPIICommand: Encrypt the personal data stored in DB from the command line
+
+2. ProtectUserInfor: Encrypt the personal data of a UserInfor before inserting it
+
+3. ProtectedPIIFields: Encrypt personal data values for an update
+
+4. DecryptPIIFields: Decrypt the personal data in DB results before sending them
+
+5. PIIFilter: Filter matching an exact personal data value, encrypted or not
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Blind indexes of the fields that support exact lookups, by field
+var piiIndexFields = map[string]string{
+	"email": "email_index",
+	"phone": "phone_index",
+}
+
+/*
+Encrypt the personal data stored in DB from the command line: pii encrypt
+
+params: args []string The arguments after "pii"
+
+return: error The error if the command is unknown or fails
+*/
+func RunPIICommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if len(args) == 1 && args[0] == "encrypt" {
+		updatedCount, migrateErr := migratePII(ctx, "")
+		if migrateErr != nil {
+			return migrateErr
+		}
+		fmt.Printf("Updated the personal data of %d documents\n", updatedCount)
+		return nil
+	}
+
+	return fmt.Errorf("usage: pii encrypt")
+}
+
+/*
+Encrypt the personal data fields of a UserInfor and set their blind indexes, before inserting it
+
+params: userInfor *model.UserInfor The UserInfor with plaintext values
+
+return: error The error if a field cannot be encrypted
+*/
+func ProtectUserInfor(userInfor *model.UserInfor) error {
+	protectedFields, protectErr := ProtectedPIIFields(userInfor.Email, userInfor.Phone, userInfor.Address)
+	if protectErr != nil {
+		return protectErr
+	}
+
+	protected := protectedFields.Map()
+	userInfor.Email, _ = protected["email"].(string)
+	userInfor.EmailIndex, _ = protected["email_index"].(string)
+	userInfor.Phone, _ = protected["phone"].(string)
+	userInfor.PhoneIndex, _ = protected["phone_index"].(string)
+	userInfor.Address, _ = protected["address"].(string)
+	return nil
+}
+
+/*
+Encrypt personal data values and compute their blind indexes, for the $set of an update
+
+params: email string The plaintext email
+
+phone string The plaintext phone
+
+address string The plaintext address
+
+return: bson.D The email, phone, address, email_index and phone_index to set
+
+error The error if a field cannot be encrypted
+*/
+func ProtectedPIIFields(email, phone, address string) (bson.D, error) {
+	fields := bson.D{}
+	for _, field := range []bson.E{{Key: "email", Value: email}, {Key: "phone", Value: phone}, {Key: "address", Value: address}} {
+		value := field.Value.(string)
+		encryptedValue, encryptErr := config.EncryptPII(field.Key, value)
+		if encryptErr != nil {
+			return nil, encryptErr
+		}
+		fields = append(fields, bson.E{Key: field.Key, Value: encryptedValue})
+
+		if indexField, indexed := piiIndexFields[field.Key]; indexed {
+			fields = append(fields, bson.E{Key: indexField, Value: config.BlindIndex(field.Key, value)})
+		}
+	}
+
+	return fields, nil
+}
+
+/*
+Decrypt the personal data in decoded DB results in place and drop the blind indexes, whatever document they are nested in
+
+params: value interface{} The results, like []gin.H, gin.H, bson.M, primitive.D or []model.UserInfor
+
+return: interface{} The same results, for use inside expressions
+*/
+func DecryptPIIFields(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		decryptPIIMap(typedValue)
+	case gin.H:
+		decryptPIIMap(typedValue)
+	case primitive.M:
+		decryptPIIMap(typedValue)
+	case primitive.D:
+		for i := range typedValue {
+			typedValue[i].Value = decryptPIIValue(typedValue[i].Key, typedValue[i].Value)
+		}
+	case primitive.A:
+		for i := range typedValue {
+			typedValue[i] = DecryptPIIFields(typedValue[i])
+		}
+	case []interface{}:
+		for i := range typedValue {
+			typedValue[i] = DecryptPIIFields(typedValue[i])
+		}
+	case []gin.H:
+		for _, item := range typedValue {
+			decryptPIIMap(item)
+		}
+	case []primitive.M:
+		for _, item := range typedValue {
+			decryptPIIMap(item)
+		}
+	case []map[string]interface{}:
+		for _, item := range typedValue {
+			decryptPIIMap(item)
+		}
+	case *model.UserInfor:
+		decryptUserInfor(typedValue)
+	case []model.UserInfor:
+		for i := range typedValue {
+			decryptUserInfor(&typedValue[i])
+		}
+	}
+
+	return value
+}
+
+/*
+Filter matching documents whose personal data field equals a value, stored in plaintext or encrypted
+
+params: field string The BSON name of the field, "email" or "phone"
+
+value string The plaintext value
+
+return: bson.M The filter
+*/
+func PIIFilter(field, value string) bson.M {
+	index := config.BlindIndex(field, value)
+	if index == "" {
+		return bson.M{field: value}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{field: value},
+		bson.M{piiIndexFields[field]: index},
+	}}
+}
+
+/*
+Decrypt the personal data fields of a map in place
+
+params: document map[string]interface{} The decoded document
+*/
+func decryptPIIMap(document map[string]interface{}) {
+	for key, value := range document {
+		if isPIIIndexField(key) {
+			delete(document, key)
+			continue
+		}
+		document[key] = decryptPIIValue(key, value)
+	}
+}
+
+/*
+Decrypt one value of a document, or the documents nested in it
+
+params: key string The name of the field
+
+value interface{} The value of the field
+
+return: interface{} The decrypted value
+*/
+func decryptPIIValue(key string, value interface{}) interface{} {
+	stringValue, isString := value.(string)
+	if !isString {
+		return DecryptPIIFields(value)
+	}
+	if !config.IsEncryptedPII(stringValue) {
+		return stringValue
+	}
+
+	plaintext, decryptErr := config.DecryptPII(stringValue)
+	if decryptErr != nil {
+		log.Println("[PII] Error decrypting " + key + ": " + decryptErr.Error())
+		return ""
+	}
+
+	return plaintext
+}
+
+/*
+Decrypt the personal data fields of a UserInfor in place
+
+params: userInfor *model.UserInfor The UserInfor read from DB
+*/
+func decryptUserInfor(userInfor *model.UserInfor) {
+	userInfor.Email, _ = decryptPIIValue("email", userInfor.Email).(string)
+	userInfor.Phone, _ = decryptPIIValue("phone", userInfor.Phone).(string)
+	userInfor.Address, _ = decryptPIIValue("address", userInfor.Address).(string)
+}
+
+/*
+Check if a field holds a blind index, never sent to clients
+
+params: key string The name of the field
+
+return: bool True for email_index and phone_index
+*/
+func isPIIIndexField(key string) bool {
+	for _, indexField := range piiIndexFields {
+		if key == indexField {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Encrypt with the primary key the personal data of every UserInfor that is in plaintext or encrypted with another key, and set the blind indexes
+
+params: ctx context.Context Context of the DB operations
+
+kid string Only encrypt again the values encrypted with this key, empty for every value
+
+return: int The number of updated documents
+
+error The error if a value cannot be decrypted or a document cannot be updated
+*/
+func migratePII(ctx context.Context, kid string) (int, error) {
+	primaryKid, _, keyErr := config.PrimaryKey(model.KeyPurposeEncryption)
+	if keyErr != nil {
+		return 0, keyErr
+	}
+
+	cursor, queryErr := userInforCollection.Find(ctx, bson.M{})
+	if queryErr != nil {
+		return 0, queryErr
+	}
+	defer cursor.Close(ctx)
+
+	updatedCount := 0
+	for cursor.Next(ctx) {
+		var userInfor model.UserInfor
+		decodeErr := cursor.Decode(&userInfor)
+		if decodeErr != nil {
+			return updatedCount, decodeErr
+		}
+
+		// Only documents with a value to encrypt again, or missing an index, are written
+		stale := false
+		for field, storedValue := range map[string]string{"email": userInfor.Email, "phone": userInfor.Phone, "address": userInfor.Address} {
+			valueKid := config.PIIKeyId(storedValue)
+			if kid == "" {
+				stale = stale || (valueKid == "" && config.PIIFieldEncrypted(field) && storedValue != "") || (valueKid != "" && valueKid != primaryKid)
+			} else {
+				stale = stale || valueKid == kid
+			}
+		}
+		plaintextInfor := userInfor
+		decryptUserInfor(&plaintextInfor)
+		if kid == "" {
+			stale = stale || userInfor.EmailIndex != config.BlindIndex("email", plaintextInfor.Email) || userInfor.PhoneIndex != config.BlindIndex("phone", plaintextInfor.Phone)
+		}
+		if !stale {
+			continue
+		}
+
+		// Decrypt first, EncryptPII leaves encrypted values as they are
+		for field, storedValue := range map[string]string{"email": userInfor.Email, "phone": userInfor.Phone, "address": userInfor.Address} {
+			if _, decryptErr := config.DecryptPII(storedValue); decryptErr != nil {
+				return updatedCount, fmt.Errorf("cannot decrypt %s of user information %s: %w", field, userInfor.Id.Hex(), decryptErr)
+			}
+		}
+		protectedFields, protectErr := ProtectedPIIFields(plaintextInfor.Email, plaintextInfor.Phone, plaintextInfor.Address)
+		if protectErr != nil {
+			return updatedCount, protectErr
+		}
+
+		_, updateErr := userInforCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: userInfor.Id}},
+			bson.D{{Key: "$set", Value: append(protectedFields, bson.E{Key: "updatedAt", Value: time.Now().Unix()})}},
+		)
+		if updateErr != nil {
+			return updatedCount, updateErr
+		}
+		updatedCount++
+	}
+
+	return updatedCount, cursor.Err()
+}
@@ -75,6 +75,19 @@ func CreateProject() gin.HandlerFunc {
 			return
 		}
 
+		// Projects get the default workflow unless they bring a valid one
+		if project.Workflow == nil {
+			defaultWorkflow := model.DefaultWorkflow
+			project.Workflow = &defaultWorkflow
+		} else if problems := ValidateWorkflow(*project.Workflow); len(problems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":  false,
+				"message":  "Invalid workflow",
+				"problems": problems,
+			})
+			return
+		}
+
 		// Set the Id and timestamps for the project
 		project.Id = primitive.NewObjectID()
 		project.CreatedAt = time.Now()
@@ -87,6 +100,22 @@ func CreateProject() gin.HandlerFunc {
 			return
 		}
 
+		// The leader is the first member of the project
+		leaderMember := model.ProjectMember{
+			Id:        primitive.NewObjectID(),
+			Project:   project.Id,
+			Employee:  project.Leader,
+			Role:      model.ProjectRoleLeader,
+			JoinedAt:  time.Now().Unix(),
+			CreatedAt: time.Now().Unix(),
+			UpdatedAt: time.Now().Unix(),
+		}
+		_, memberInsertErr := projectMemberCollection.InsertOne(ctx, leaderMember)
+		if memberInsertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting project leader: "+memberInsertErr.Error())
+			return
+		}
+
 		// Send response to client
 		if result.InsertedID != nil {
 			c.JSON(http.StatusCreated, gin.H{
@@ -174,7 +203,7 @@ func GetProjects() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success":  true,
-			"projects": projects,
+			"projects": Redact(c, projects, model.Project{}),
 		})
 	}
 }
@@ -246,7 +275,7 @@ func GetProjectById() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"project": project[0],
+			"project": Redact(c, project[0], model.Project{}),
 		})
 	}
 }
@@ -331,7 +360,7 @@ func GetProjectsForManager() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
-			"project": project,
+			"project": Redact(c, project, model.Project{}),
 		})
 	}
 }
@@ -372,7 +401,7 @@ func SearchProject() gin.HandlerFunc {
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"count":    len(projects),
-			"projects": projects,
+			"projects": Redact(c, projects, model.Project{}),
 		})
 	}
 }
@@ -501,6 +530,20 @@ func DeleteProject() gin.HandlerFunc {
 			return
 		}
 
+		// Delete the memberships of the project
+		_, memberDeleteErr := projectMemberCollection.DeleteMany(ctx, bson.M{"project": deleteId})
+		if memberDeleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting project members: "+memberDeleteErr.Error())
+			return
+		}
+
+		// Delete the links between the tasks of the project
+		_, linkDeleteErr := taskLinkCollection.DeleteMany(ctx, bson.M{"project": deleteId})
+		if linkDeleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting task links: "+linkDeleteErr.Error())
+			return
+		}
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"msg": strconv.Itoa(int(result.DeletedCount)) + " project deleted",
@@ -0,0 +1,592 @@
+/*
+Controller for handling data with ProjectMember model in DB
+
+1. AddProjectMember: Add an employee to a Project
+
+2. GetProjectMembers: Get all members of a Project
+
+3. UpdateProjectMember: Change the role of a member in a Project
+
+4. RemoveProjectMember: Remove an employee from a Project
+
+5. CheckProjectAccess: Check if the logged in employee can access a Project
+
+6. CurrentProjectRole: Get the role of the logged in account within a Project
+
+7. RunMembersCommand: Give the Projects created before memberships their members, from the command line
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Add an employee to a Project
+
+params: None
+
+return: gin.HandlerFunc Handler function to add a member to a project
+*/
+func AddProjectMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+		validate := validator.New()
+
+		// Convert the hex string to ObjectID
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+
+		// Only the leaders of the project can manage its members
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		// Create an instance of the ProjectMember model
+		var member model.ProjectMember
+
+		// Bind the request body to the member model
+		bindingErr := c.BindJSON(&member)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Process the specified member
+		validationErr := validate.Struct(&member)
+		if validationErr != nil {
+			var memberValidationErr []gin.H
+			for _, ve := range validationErr.(validator.ValidationErrors) {
+				memberValidationErr = append(memberValidationErr, gin.H{
+					"field": ve.Field(),
+					"tag":   ve.Tag(),
+				})
+			}
+
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":         false,
+				"validationError": memberValidationErr,
+			})
+			return
+		}
+
+		// Check if the employee exists
+		var employee model.Employee
+		employeeQueryErr := employeeCollection.FindOne(ctx, bson.M{"_id": member.Employee}).Decode(&employee)
+		if employeeQueryErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Employee not found",
+			})
+			return
+		}
+
+		// Check if the employee is already a member
+		var existingMember model.ProjectMember
+		memberQueryErr := projectMemberCollection.FindOne(ctx, bson.M{"project": projectId, "employee": member.Employee}).Decode(&existingMember)
+		if memberQueryErr == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Employee is already a member",
+			})
+			return
+		}
+
+		// Set the Id, project and timestamps for the member
+		member.Id = primitive.NewObjectID()
+		member.Project = projectId
+		member.JoinedAt = time.Now().Unix()
+		member.CreatedAt = time.Now().Unix()
+		member.UpdatedAt = time.Now().Unix()
+
+		// Insert the member to DB
+		_, insertErr := projectMemberCollection.InsertOne(ctx, member)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting project member: "+insertErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Member added",
+			"member":  Redact(c, member, model.ProjectMember{}),
+		})
+	}
+}
+
+/*
+Get all members of a Project
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the members of a project
+*/
+func GetProjectMembers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		// Create an array for the members
+		var members []gin.H
+
+		// Define a pipeline to filter the members by project and join collections
+		pipeline := mongo.Pipeline{
+			bson.D{
+				{Key: "$match", Value: bson.D{
+					{Key: "project", Value: projectId},
+				}},
+			},
+			bson.D{
+				{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "employee"},
+					{Key: "localField", Value: "employee"},
+					{Key: "foreignField", Value: "_id"},
+					{Key: "as", Value: "employee"},
+				}},
+			},
+			bson.D{
+				{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "user_infor"},
+					{Key: "localField", Value: "employee.userinfor_id"},
+					{Key: "foreignField", Value: "_id"},
+					{Key: "as", Value: "userinfor"},
+				}},
+			},
+			bson.D{
+				{Key: "$project", Value: bson.D{
+					{Key: "employee_id", Value: bson.D{
+						{Key: "$arrayElemAt", Value: bson.A{"$employee._id", 0}},
+					}},
+					{Key: "role", Value: 1},
+					{Key: "joinedAt", Value: 1},
+					{Key: "fullname", Value: bson.D{
+						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.fullname", 0}},
+					}},
+					{Key: "profile_image", Value: bson.D{
+						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.profile_image", 0}},
+					}},
+				}},
+			},
+			bson.D{
+				{Key: "$sort", Value: bson.D{
+					{Key: "joinedAt", Value: 1},
+				}},
+			},
+		}
+
+		// Use the defined stages to aggregate data from the Employee and User_Infor collections
+		result, aggregateErr := projectMemberCollection.Aggregate(ctx, pipeline)
+		if aggregateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error aggregating project members: "+aggregateErr.Error())
+			return
+		}
+
+		// Decode the data from DB to the members array
+		decodeErr := result.All(ctx, &members)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding project members: "+decodeErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"members": Redact(c, members, model.ProjectMember{}, model.Employee{}),
+		})
+	}
+}
+
+/*
+Change the role of a member in a Project
+
+params: None
+
+return: gin.HandlerFunc Handler function to update a member of a project
+*/
+func UpdateProjectMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex strings to ObjectID
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+		employeeId, convertErr := primitive.ObjectIDFromHex(c.Param("employee"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Member not found",
+			})
+			return
+		}
+
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		// Bind the request body to the member model
+		var member model.ProjectMember
+		bindingErr := c.BindJSON(&member)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		// Validate the role only, the employee comes from the link
+		validationErr := validate.Var(member.Role, "required,oneof=leader contributor viewer")
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"validationError": []gin.H{{
+					"field": "Role",
+					"tag":   "oneof",
+				}},
+			})
+			return
+		}
+
+		// Find and update the member in DB
+		result := projectMemberCollection.FindOneAndUpdate(
+			ctx,
+			bson.M{"project": projectId, "employee": employeeId},
+			bson.M{
+				"$set": bson.M{
+					"role":      member.Role,
+					"updatedAt": time.Now().Unix(),
+				},
+			},
+		)
+		if result.Err() != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Member not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Member updated",
+		})
+	}
+}
+
+/*
+Remove an employee from a Project
+
+params: None
+
+return: gin.HandlerFunc Handler function to remove a member from a project
+*/
+func RemoveProjectMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex strings to ObjectID
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+		employeeId, convertErr := primitive.ObjectIDFromHex(c.Param("employee"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Member not found",
+			})
+			return
+		}
+
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		// Delete the member from DB
+		result, deleteErr := projectMemberCollection.DeleteOne(ctx, bson.M{"project": projectId, "employee": employeeId})
+		if deleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting project member: "+deleteErr.Error())
+			return
+		}
+
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Member not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Member removed",
+		})
+	}
+}
+
+/*
+Check if the logged in employee is a member of a Project, and send a 403 response to the client if not
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project
+
+roles ...string Roles allowed, any role is allowed if none is specified
+
+return: bool True if the employee can access the project
+*/
+func CheckProjectAccess(ctx context.Context, c *gin.Context, projectId primitive.ObjectID, roles ...string) bool {
+	// Accounts with project admin permission can access every project
+	if middleware.HasPermission(c, model.PermissionProjectAdmin) {
+		return true
+	}
+
+	role := CurrentProjectRole(ctx, c, projectId)
+	if role == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Forbidden",
+			"reason":  "not_project_member",
+		})
+		return false
+	}
+
+	if len(roles) == 0 {
+		return true
+	}
+	for _, allowedRole := range roles {
+		if role == allowedRole {
+			return true
+		}
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"success": false,
+		"message": "Forbidden",
+		"reason":  "project_role",
+		"role":    role,
+	})
+	return false
+}
+
+/*
+Get the role of the logged in account within a Project
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project
+
+return: string The role, empty if the account is not a member
+*/
+func CurrentProjectRole(ctx context.Context, c *gin.Context, projectId primitive.ObjectID) string {
+	// Guests only have the role the project was shared with
+	if middleware.IsGuest(c) {
+		return middleware.GuestProjectRole(c, projectId)
+	}
+
+	return GetProjectRole(ctx, projectId, CurrentEmployeeId(c))
+}
+
+/*
+Get the role of an employee within a Project
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+employeeId primitive.ObjectID ID of the employee
+
+return: string The role of the employee, empty if the employee is not a member
+*/
+func GetProjectRole(ctx context.Context, projectId, employeeId primitive.ObjectID) string {
+	if employeeId.IsZero() {
+		return ""
+	}
+
+	var member model.ProjectMember
+	memberQueryErr := projectMemberCollection.FindOne(ctx, bson.M{"project": projectId, "employee": employeeId}).Decode(&member)
+	if memberQueryErr == nil {
+		return member.Role
+	}
+
+	// Projects created before memberships existed only know their leader
+	var project model.Project
+	projectQueryErr := projectCollection.FindOne(ctx, bson.M{"_id": projectId}).Decode(&project)
+	if projectQueryErr == nil && project.Leader == employeeId {
+		return model.ProjectRoleLeader
+	}
+
+	return ""
+}
+
+/*
+Get the project that owns an Epic
+
+params: ctx context.Context Context of the DB operations
+
+epicId primitive.ObjectID ID of the epic
+
+return: primitive.ObjectID ID of the project
+
+error The error if the epic does not exist
+*/
+func GetProjectOfEpic(ctx context.Context, epicId primitive.ObjectID) (primitive.ObjectID, error) {
+	var epic model.Epic
+	epicQueryErr := epicCollection.FindOne(ctx, bson.M{"_id": epicId}).Decode(&epic)
+	if epicQueryErr != nil {
+		return primitive.NilObjectID, epicQueryErr
+	}
+
+	return epic.Project, nil
+}
+
+/*
+Give existing Projects their members from the command line: members migrate
+
+params: args []string The arguments after "members"
+
+return: error The error if the command is unknown or fails
+*/
+func RunMembersCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if len(args) == 1 && args[0] == "migrate" {
+		projectCount, memberCount, migrateErr := migrateProjectMembers(ctx)
+		if migrateErr != nil {
+			return migrateErr
+		}
+		fmt.Printf("Added %d members to %d projects\n", memberCount, projectCount)
+		return nil
+	}
+
+	return fmt.Errorf("usage: members migrate")
+}
+
+/*
+Make the leader of every Project a leader member, and the members of its tasks across its epics contributors.
+Employees who already are members keep their role, so the migration can run again
+
+params: ctx context.Context Context of the DB operations
+
+return: int The number of projects that got members
+
+int The number of added members
+
+error The error of the DB operations
+*/
+func migrateProjectMembers(ctx context.Context) (int, int, error) {
+	cursor, queryErr := projectCollection.Find(ctx, bson.M{})
+	if queryErr != nil {
+		return 0, 0, queryErr
+	}
+	defer cursor.Close(ctx)
+
+	projectCount := 0
+	memberCount := 0
+	for cursor.Next(ctx) {
+		var project model.Project
+		if decodeErr := cursor.Decode(&project); decodeErr != nil {
+			return projectCount, memberCount, decodeErr
+		}
+
+		// Members inferred from the tasks of the project, as GetEmployeeByProject did before memberships
+		epicIds, epicErr := projectEpicIds(ctx, project.Id)
+		if epicErr != nil {
+			return projectCount, memberCount, epicErr
+		}
+		taskMembers, distinctErr := taskCollection.Distinct(ctx, "members", bson.M{"epic": bson.M{"$in": epicIds}})
+		if distinctErr != nil {
+			return projectCount, memberCount, distinctErr
+		}
+
+		roles := map[primitive.ObjectID]string{}
+		for _, taskMember := range taskMembers {
+			if employeeId, isId := taskMember.(primitive.ObjectID); isId && !employeeId.IsZero() {
+				roles[employeeId] = model.ProjectRoleContributor
+			}
+		}
+		if !project.Leader.IsZero() {
+			roles[project.Leader] = model.ProjectRoleLeader
+		}
+
+		added := 0
+		for employeeId, role := range roles {
+			result, upsertErr := projectMemberCollection.UpdateOne(
+				ctx,
+				bson.M{"project": project.Id, "employee": employeeId},
+				bson.M{"$setOnInsert": model.ProjectMember{
+					Id:        primitive.NewObjectID(),
+					Project:   project.Id,
+					Employee:  employeeId,
+					Role:      role,
+					JoinedAt:  time.Now().Unix(),
+					CreatedAt: time.Now().Unix(),
+					UpdatedAt: time.Now().Unix(),
+				}},
+				options.Update().SetUpsert(true),
+			)
+			if upsertErr != nil {
+				return projectCount, memberCount, upsertErr
+			}
+			if result.UpsertedCount > 0 {
+				added++
+			}
+		}
+		if added > 0 {
+			projectCount++
+			memberCount += added
+		}
+	}
+
+	return projectCount, memberCount, cursor.Err()
+}
@@ -0,0 +1,274 @@
+/*
+Controller shaping the model documents sent in API responses to what the current account may see
+
+1. GetProfile: Get the account, employee and user information of the current account
+
+2. Redact: Remove the fields of model documents the current account may not see
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Fields of a set of models with their visibility, by lower-cased field name
+type redactionRules struct {
+	levels    map[string]string   // Visibility of the fields that are not public
+	ownerKeys map[string][]string // Owners whose ID the field holds
+}
+
+// The current account, as compared to the owners of documents
+type redactionViewer struct {
+	ids   map[string]string // Hex ID of the account, employee and user information, by owner
+	admin bool
+}
+
+// Fields are compared by rank, the strictest visibility wins when models disagree
+var visibilityRanks = map[string]int{
+	model.VisibilityPublic: 0,
+	model.VisibilityOwner:  1,
+	model.VisibilityAdmin:  2,
+	model.VisibilitySecret: 3,
+}
+
+// Rules of each model type, read from the struct tags once
+var modelRedactionRules sync.Map
+
+/*
+Get the account, employee and user information of the current account
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the profile
+*/
+func GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currentAccount := c.MustGet("currentAccount").(gin.H)
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"profile": Redact(c, currentAccount, model.Employee{}),
+		})
+	}
+}
+
+/*
+Keep only the fields of model documents that the current account may see, and decrypt their personal data.
+Fields are matched by their BSON, JSON or Go name, in documents at any depth
+
+params: c *gin.Context Context of the request, holding the current account
+
+value interface{} The documents, like []gin.H, gin.H, a model struct or a slice of them
+
+models ...interface{} The models the documents are made of, like model.Employee{}, Account and UserInfor always apply
+
+return: interface{} A copy of the documents as JSON values, nil if they cannot be encoded
+*/
+func Redact(c *gin.Context, value interface{}, models ...interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	// Work on a JSON copy, so structs, BSON documents and maps are all shaped alike
+	encoded, encodeErr := json.Marshal(value)
+	if encodeErr != nil {
+		log.Println("[Redact] Error encoding response: " + encodeErr.Error())
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var document interface{}
+	decodeErr := decoder.Decode(&document)
+	if decodeErr != nil {
+		log.Println("[Redact] Error decoding response: " + decodeErr.Error())
+		return nil
+	}
+
+	DecryptPIIFields(document)
+	rules := combinedRedactionRules(append(models, alwaysRedactedModels...))
+	return redactValue(document, rules, currentRedactionViewer(c), false)
+}
+
+/*
+Combine the rules of several models
+
+params: models []interface{} The models, as struct values or pointers
+
+return: redactionRules The rules of every field, the strictest one when models share a field name
+*/
+func combinedRedactionRules(models []interface{}) redactionRules {
+	combined := redactionRules{levels: map[string]string{}, ownerKeys: map[string][]string{}}
+	for _, modelValue := range models {
+		rules := modelRules(modelValue)
+		for name, level := range rules.levels {
+			if visibilityRanks[level] > visibilityRanks[combined.levels[name]] {
+				combined.levels[name] = level
+			}
+		}
+		for name, owners := range rules.ownerKeys {
+			combined.ownerKeys[name] = append(combined.ownerKeys[name], owners...)
+		}
+	}
+
+	return combined
+}
+
+/*
+Read the visibility and owner struct tags of a model, fields tagged json:"-" are secret
+
+params: modelValue interface{} The model, as a struct value or pointer
+
+return: redactionRules The rules of the model
+*/
+func modelRules(modelValue interface{}) redactionRules {
+	modelType := reflect.TypeOf(modelValue)
+	for modelType.Kind() == reflect.Pointer {
+		modelType = modelType.Elem()
+	}
+	if cached, found := modelRedactionRules.Load(modelType); found {
+		return cached.(redactionRules)
+	}
+
+	rules := redactionRules{levels: map[string]string{}, ownerKeys: map[string][]string{}}
+	if modelType.Kind() != reflect.Struct {
+		return rules
+	}
+	for i := 0; i < modelType.NumField(); i++ {
+		field := modelType.Field(i)
+		names := []string{field.Name}
+		if bsonName, _, _ := strings.Cut(field.Tag.Get("bson"), ","); bsonName != "" && bsonName != "-" {
+			names = append(names, bsonName)
+		}
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName != "" && jsonName != "-" {
+			names = append(names, jsonName)
+		}
+
+		level := field.Tag.Get("visibility")
+		if level == "" && jsonName == "-" {
+			level = model.VisibilitySecret
+		}
+		owner := field.Tag.Get("owner")
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if visibilityRanks[level] > 0 {
+				rules.levels[name] = level
+			}
+			if owner != "" {
+				rules.ownerKeys[name] = append(rules.ownerKeys[name], owner)
+			}
+		}
+	}
+
+	modelRedactionRules.Store(modelType, rules)
+	return rules
+}
+
+/*
+Get who the current account is, to compare it to the owners of documents
+
+params: c *gin.Context Context of the request
+
+return: redactionViewer The current account, without any ID if nobody is logged in
+*/
+func currentRedactionViewer(c *gin.Context) redactionViewer {
+	viewer := redactionViewer{ids: map[string]string{}}
+	currentAccount, exists := c.Get("currentAccount")
+	if !exists || currentAccount == nil {
+		return viewer
+	}
+
+	for owner, key := range map[string]string{model.OwnerAccount: "account_id", model.OwnerEmployee: "_id", model.OwnerUserInfor: "userinfor_id"} {
+		if id, ok := currentAccount.(gin.H)[key].(primitive.ObjectID); ok && !id.IsZero() {
+			viewer.ids[owner] = id.Hex()
+		}
+	}
+	for _, permission := range redactionAdminPermissions {
+		viewer.admin = viewer.admin || middleware.HasPermission(c, permission)
+	}
+
+	return viewer
+}
+
+/*
+Remove the fields the viewer may not see from a JSON value, in place
+
+params: value interface{} The decoded JSON value
+
+rules redactionRules The rules of the fields
+
+viewer redactionViewer The current account
+
+owned bool True if a parent document belongs to the viewer
+
+return: interface{} The redacted value
+*/
+func redactValue(value interface{}, rules redactionRules, viewer redactionViewer, owned bool) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		owned = owned || ownedByViewer(typedValue, rules, viewer)
+		for key, fieldValue := range typedValue {
+			switch rules.levels[strings.ToLower(key)] {
+			case model.VisibilitySecret:
+				delete(typedValue, key)
+				continue
+			case model.VisibilityAdmin:
+				if !viewer.admin {
+					delete(typedValue, key)
+					continue
+				}
+			case model.VisibilityOwner:
+				if !viewer.admin && !owned {
+					delete(typedValue, key)
+					continue
+				}
+			}
+			typedValue[key] = redactValue(fieldValue, rules, viewer, owned)
+		}
+	case []interface{}:
+		for i := range typedValue {
+			typedValue[i] = redactValue(typedValue[i], rules, viewer, owned)
+		}
+	}
+
+	return value
+}
+
+/*
+Check if a document belongs to the viewer, by any of its fields holding the ID of an owner
+
+params: document map[string]interface{} The decoded JSON document
+
+rules redactionRules The rules of the fields
+
+viewer redactionViewer The current account
+
+return: bool True if the document is the account, employee or user information of the viewer, or belongs to it
+*/
+func ownedByViewer(document map[string]interface{}, rules redactionRules, viewer redactionViewer) bool {
+	for key, fieldValue := range document {
+		id, isString := fieldValue.(string)
+		if !isString || id == "" {
+			continue
+		}
+		for _, owner := range rules.ownerKeys[strings.ToLower(key)] {
+			if viewer.ids[owner] == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
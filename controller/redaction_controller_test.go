@@ -0,0 +1,253 @@
+package controller
+
+import (
+	"backend/model"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDs of the account, employee and user information of the viewer, and of another employee
+var (
+	viewerAccountId   = primitive.NewObjectID()
+	viewerEmployeeId  = primitive.NewObjectID()
+	viewerUserInforId = primitive.NewObjectID()
+	otherAccountId    = primitive.NewObjectID()
+	otherUserInforId  = primitive.NewObjectID()
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+/*
+Build the context of a request by an account, as loaded by CookieAuth
+
+params: permissions ...string Permissions of the account, nil for a request without account
+
+return: *gin.Context The context
+*/
+func redactionTestContext(permissions ...string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if permissions == nil {
+		return c
+	}
+
+	granted := primitive.A{}
+	for _, permission := range permissions {
+		granted = append(granted, permission)
+	}
+	c.Set("currentAccount", gin.H{
+		"_id":          viewerEmployeeId,
+		"account_id":   viewerAccountId,
+		"userinfor_id": viewerUserInforId,
+		"permissions":  granted,
+	})
+
+	return c
+}
+
+/*
+Get a field of a redacted JSON value
+
+params: value interface{} The redacted value
+
+path string Keys and array indexes separated by dots, like "tasks.0.title"
+
+return: interface{} The value of the field
+
+bool True if the field is present
+*/
+func redactedField(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			fieldValue, exists := typedValue[key]
+			if !exists {
+				return nil, false
+			}
+			value = fieldValue
+		case []interface{}:
+			index, indexErr := strconv.Atoi(key)
+			if indexErr != nil || index >= len(typedValue) {
+				return nil, false
+			}
+			value = typedValue[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func TestRedact(t *testing.T) {
+	account := func(accountId primitive.ObjectID) model.Account {
+		return model.Account{
+			Id:                accountId,
+			Username:          "jdoe",
+			Password:          "$2a$10$hash",
+			PasswordHistory:   []string{"$2a$10$old"},
+			PasswordChangedAt: 1700000000,
+		}
+	}
+	userInfor := func(userInforId primitive.ObjectID) gin.H {
+		return gin.H{"_id": userInforId, "fullname": "Jane Doe", "email": "jane@example.com", "phone": "0123456789"}
+	}
+
+	tests := []struct {
+		name        string
+		permissions []string
+		value       interface{}
+		models      []interface{}
+		present     []string
+		absent      []string
+	}{
+		{
+			name:    "public fields are sent without account",
+			value:   account(otherAccountId),
+			present: []string{"username", "Account_Name"},
+			absent:  []string{"password", "Password", "PasswordHistory", "PasswordChangedAt"},
+		},
+		{
+			name:        "secret fields are never sent, even to admins",
+			permissions: []string{model.PermissionAll},
+			value:       gin.H{"password": "$2a$10$hash", "password_history": []string{"$2a$10$old"}, "username": "jdoe"},
+			present:     []string{"username"},
+			absent:      []string{"password", "password_history"},
+		},
+		{
+			name:        "json:\"-\" fields are secret",
+			permissions: []string{model.PermissionAll},
+			value:       gin.H{"account_id": viewerAccountId, "secret": "JBSWY3DPEHPK3PXP", "recovery_code_hashes": []string{"hash"}, "enabled": true},
+			models:      []interface{}{model.TwoFactor{}},
+			present:     []string{"enabled"},
+			absent:      []string{"secret", "recovery_code_hashes"},
+		},
+		{
+			name:        "owner fields are hidden from other accounts",
+			permissions: []string{model.PermissionEmployeeRead},
+			value:       account(otherAccountId),
+			present:     []string{"username"},
+			absent:      []string{"PasswordChangedAt"},
+		},
+		{
+			name:        "owner fields are sent to the owner",
+			permissions: []string{model.PermissionEmployeeRead},
+			value:       account(viewerAccountId),
+			present:     []string{"username", "PasswordChangedAt"},
+			absent:      []string{"Password"},
+		},
+		{
+			name:        "owner fields are sent to admins",
+			permissions: []string{model.PermissionAccountAdmin},
+			value:       account(otherAccountId),
+			present:     []string{"PasswordChangedAt"},
+			absent:      []string{"Password"},
+		},
+		{
+			name:        "admin fields are hidden from the owner",
+			permissions: []string{model.PermissionEmployeeRead},
+			value:       gin.H{"account_id": viewerAccountId, "ip": "10.0.0.1", "impersonator_account_id": otherAccountId},
+			models:      []interface{}{model.Session{}},
+			present:     []string{"ip"},
+			absent:      []string{"impersonator_account_id"},
+		},
+		{
+			name:        "admin fields are sent to admins",
+			permissions: []string{model.PermissionEmployeeAdmin},
+			value:       gin.H{"account_id": otherAccountId, "ip": "10.0.0.1", "impersonator_account_id": viewerAccountId},
+			models:      []interface{}{model.Session{}},
+			present:     []string{"ip", "impersonator_account_id"},
+		},
+		{
+			name:        "nested documents are redacted",
+			permissions: []string{model.PermissionEmployeeRead},
+			value: gin.H{"tasks": []gin.H{{
+				"title":    "Survey",
+				"accounts": []gin.H{{"_id": otherAccountId, "username": "jdoe", "password": "$2a$10$hash"}},
+				"userinfo": userInfor(otherUserInforId),
+			}}},
+			present: []string{"tasks.0.title", "tasks.0.accounts.0.username", "tasks.0.userinfo.fullname"},
+			absent:  []string{"tasks.0.accounts.0.password", "tasks.0.userinfo.email", "tasks.0.userinfo.phone"},
+		},
+		{
+			name:        "nested documents of the viewer keep their owner fields",
+			permissions: []string{model.PermissionEmployeeRead},
+			value:       gin.H{"employees": []gin.H{{"userinfo": userInfor(viewerUserInforId)}, {"userinfo": userInfor(otherUserInforId)}}},
+			present:     []string{"employees.0.userinfo.email", "employees.1.userinfo.fullname"},
+			absent:      []string{"employees.1.userinfo.email"},
+		},
+		{
+			name:        "documents inside a document of the viewer are owned",
+			permissions: []string{model.PermissionEmployeeRead},
+			value:       gin.H{"_id": viewerEmployeeId, "userinfo": gin.H{"email": "jane@example.com", "phone": "0123456789"}},
+			models:      []interface{}{model.Employee{}},
+			present:     []string{"userinfo.email", "userinfo.phone"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted := Redact(redactionTestContext(test.permissions...), test.value, test.models...)
+			if redacted == nil {
+				t.Fatal("Redact returned nil")
+			}
+			for _, path := range test.present {
+				if _, exists := redactedField(redacted, path); !exists {
+					t.Errorf("%s was removed: %v", path, redacted)
+				}
+			}
+			for _, path := range test.absent {
+				if _, exists := redactedField(redacted, path); exists {
+					t.Errorf("%s was sent: %v", path, redacted)
+				}
+			}
+		})
+	}
+}
+
+func TestRedactNil(t *testing.T) {
+	if redacted := Redact(redactionTestContext(), nil); redacted != nil {
+		t.Errorf("Redact(nil) = %v, want nil", redacted)
+	}
+}
+
+func TestOwnedByViewer(t *testing.T) {
+	rules := combinedRedactionRules([]interface{}{model.Employee{}, model.Account{}, model.UserInfor{}})
+	viewer := currentRedactionViewer(redactionTestContext(model.PermissionEmployeeRead))
+
+	tests := []struct {
+		name     string
+		document map[string]interface{}
+		want     bool
+	}{
+		{"account of the viewer", map[string]interface{}{"_id": viewerAccountId.Hex()}, true},
+		{"employee of the viewer", map[string]interface{}{"_id": viewerEmployeeId.Hex()}, true},
+		{"document holding the account of the viewer", map[string]interface{}{"account_id": viewerAccountId.Hex()}, true},
+		{"owner key matched ignoring case", map[string]interface{}{"UserInforId": viewerUserInforId.Hex()}, true},
+		{"other account", map[string]interface{}{"_id": otherAccountId.Hex(), "account_id": otherAccountId.Hex()}, false},
+		{"ID of the viewer in a field that is no owner", map[string]interface{}{"manager_id": viewerEmployeeId.Hex()}, false},
+		{"ID of the viewer for another owner", map[string]interface{}{"userinfor_id": viewerAccountId.Hex()}, false},
+		{"owner field that is not a string", map[string]interface{}{"account_id": map[string]interface{}{"$oid": viewerAccountId.Hex()}}, false},
+		{"empty owner field", map[string]interface{}{"account_id": ""}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ownedByViewer(test.document, rules, viewer); got != test.want {
+				t.Errorf("ownedByViewer(%v) = %v, want %v", test.document, got, test.want)
+			}
+		})
+	}
+
+	// Without a logged in account nothing is owned, not even documents without ID
+	anonymous := currentRedactionViewer(redactionTestContext())
+	if ownedByViewer(map[string]interface{}{"account_id": ""}, rules, anonymous) {
+		t.Error("a document is owned by a request without account")
+	}
+}
@@ -0,0 +1,671 @@
+/*
+Controller for handling data with Session model in DB
+
+1. RefreshToken: Rotate the refresh token and issue a new access token
+
+2. CreateSession: Create a session for an account and send the token cookies
+
+3. RevokeAccountSessions: Revoke every session of an account
+
+4. GetSessions: Get the active sessions of the logged in account
+
+5. RevokeSession: Sign out one session of the logged in account
+
+6. RevokeOtherSessions: Sign out every session of the logged in account except the current one
+
+7. GetAccountSessions: Get the active sessions of any account
+
+8. RevokeAccountSession: Sign out one session of any account
+
+9. RevokeAllAccountSessions: Sign out every session of any account
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Rotate the refresh token in the refresh_token cookie and issue a new access token
+
+params: None
+
+return: gin.HandlerFunc Handler function to refresh the access token
+*/
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Get the refresh token from cookie
+		refreshToken, cookieErr := c.Cookie("refresh_token")
+		if cookieErr != nil || refreshToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Unauthorized",
+			})
+			return
+		}
+		refreshTokenHash := HashToken(refreshToken)
+
+		// Generate the refresh token replacing the current one
+		newRefreshToken, generateErr := GenerateToken()
+		if generateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error generating token: "+generateErr.Error())
+			return
+		}
+
+		// Swap the refresh token of the active session in one operation, so the same token cannot be rotated twice
+		var session model.Session
+		rotateErr := sessionCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "refresh_token_hash", Value: refreshTokenHash},
+				{Key: "revoked", Value: false},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "refresh_token_hash", Value: HashToken(newRefreshToken)},
+					{Key: "previous_token_hash", Value: refreshTokenHash},
+					{Key: "lastSeenAt", Value: time.Now().Unix()},
+					{Key: "lastSeenIP", Value: c.ClientIP()},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&session)
+		if rotateErr != nil {
+			// A rotated token presented again means it was stolen, revoke the session it belonged to
+			if rotateErr == mongo.ErrNoDocuments {
+				sessionCollection.UpdateOne(
+					ctx,
+					bson.D{{Key: "previous_token_hash", Value: refreshTokenHash}},
+					bson.D{
+						{Key: "$set", Value: bson.D{
+							{Key: "revoked", Value: true},
+							{Key: "revokedAt", Value: time.Now().Unix()},
+						}},
+					},
+				)
+			}
+
+			ClearTokenCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Unauthorized",
+			})
+			return
+		}
+
+		// Get the current level of the account, it may have changed since the last token
+		levelName, levelErr := GetAccountLevelName(ctx, session.AccountId)
+		if levelErr != nil {
+			ClearTokenCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Unauthorized",
+			})
+			return
+		}
+
+		accessToken, tokenErr := CreateAccessToken(session.AccountId, levelName, session.Id, session.ImpersonatorId)
+		if tokenErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error creating token: "+tokenErr.Error())
+			return
+		}
+
+		// Send the cookies to client
+		SetTokenCookies(c, accessToken, newRefreshToken, time.Unix(session.ExpiresAt, 0))
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Token refreshed",
+			"level":   levelName,
+		})
+	}
+}
+
+/*
+Create a session for an account and send the access and refresh token cookies to the client
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request to set the cookies on
+
+accountId primitive.ObjectID ID of the logged in account
+
+levelName string Authorization level name of the account
+
+return: error The error if the session cannot be created
+*/
+func CreateSession(ctx context.Context, c *gin.Context, accountId primitive.ObjectID, levelName string) error {
+	session := model.Session{
+		Id:        primitive.NewObjectID(),
+		AccountId: accountId,
+		ExpiresAt: time.Now().Add(refreshTokenLifetime).Unix(),
+	}
+
+	sessionErr := startSession(ctx, c, session, levelName)
+	if sessionErr != nil {
+		return sessionErr
+	}
+
+	// Tell the owner about logins from a new device, the login goes on whatever the check finds
+	CheckLoginDevice(ctx, c, accountId, session.Id)
+	return nil
+}
+
+/*
+Insert a session with the device of the request and send its token cookies to the client
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request to set the cookies on
+
+session model.Session The session with its ID, account, expiration and impersonator if any
+
+levelName string Authorization level name of the account
+
+return: error The error if the session cannot be created
+*/
+func startSession(ctx context.Context, c *gin.Context, session model.Session, levelName string) error {
+	refreshToken, generateErr := GenerateToken()
+	if generateErr != nil {
+		return generateErr
+	}
+
+	session.RefreshTokenHash = HashToken(refreshToken)
+	session.UserAgent = c.Request.UserAgent()
+	session.IP = c.ClientIP()
+	session.LastSeenAt = time.Now().Unix()
+	session.LastSeenIP = c.ClientIP()
+	session.CreatedAt = time.Now().Unix()
+	session.UpdatedAt = time.Now().Unix()
+
+	_, insertErr := sessionCollection.InsertOne(ctx, session)
+	if insertErr != nil {
+		return insertErr
+	}
+
+	accessToken, tokenErr := CreateAccessToken(session.AccountId, levelName, session.Id, session.ImpersonatorId)
+	if tokenErr != nil {
+		return tokenErr
+	}
+
+	SetTokenCookies(c, accessToken, refreshToken, time.Unix(session.ExpiresAt, 0))
+	return nil
+}
+
+/*
+Create the signed and encrypted access token for a session
+
+params: accountId primitive.ObjectID ID of the logged in account
+
+levelName string Authorization level name of the account
+
+sessionId primitive.ObjectID ID of the session the token belongs to
+
+impersonatorId primitive.ObjectID ID of the admin account acting as the account, primitive.NilObjectID if none
+
+return: string The token encoded to be stored in a cookie
+
+error The error if signing or encryption fails
+*/
+func CreateAccessToken(accountId primitive.ObjectID, levelName string, sessionId primitive.ObjectID, impersonatorId primitive.ObjectID) (string, error) {
+	// Create token with user ID, level name and session ID in payload
+	claims := jwt.MapClaims{
+		"account_id": accountId.Hex(),
+		"level":      levelName,
+		"session_id": sessionId.Hex(),
+		"exp":        time.Now().Add(accessTokenLifetime).Unix(),
+	}
+	// Keep the identity of the real admin while impersonating
+	if !impersonatorId.IsZero() {
+		claims["impersonator_id"] = impersonatorId.Hex()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+
+	// Sign the token with the primary signing key, its ID in the header finds the key again after a rotation
+	kid, signingKey, keyErr := config.PrimaryKey(model.KeyPurposeSigning)
+	if keyErr != nil {
+		return "", keyErr
+	}
+	token.Header["kid"] = kid
+	signedToken, signingErr := token.SignedString(signingKey)
+	if signingErr != nil {
+		return "", signingErr
+	}
+
+	// Encrypt the token with the primary encryption key
+	encryptedToken, encryptErr := config.EncryptWithKeyring([]byte(signedToken))
+	if encryptErr != nil {
+		return "", encryptErr
+	}
+
+	// Encode the encrypted token to URL safe format
+	return url.QueryEscape(encryptedToken), nil
+}
+
+/*
+Send the access and refresh token cookies to the client
+
+params: c *gin.Context Context of the request to set the cookies on
+
+accessToken string The encoded access token
+
+refreshToken string The refresh token
+
+refreshExpiresAt time.Time Expiration time of the session
+*/
+func SetTokenCookies(c *gin.Context, accessToken, refreshToken string, refreshExpiresAt time.Time) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "access_token",
+		Value:    accessToken,
+		Expires:  time.Now().Add(accessTokenLifetime),
+		HttpOnly: true,
+		Secure:   false,                // Set to true to ensure the cookie is sent only over HTTPS
+		SameSite: http.SameSiteLaxMode, // Set the SameSite attribute for CSRF protection
+		Path:     "/",
+	})
+
+	// The refresh token is only sent to the refresh endpoint
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Expires:  refreshExpiresAt,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/token/refresh",
+	})
+}
+
+/*
+Remove the access and refresh token cookies from the client
+
+params: c *gin.Context Context of the request to clear the cookies on
+*/
+func ClearTokenCookies(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "access_token",
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/token/refresh",
+	})
+}
+
+/*
+Revoke every session of an account, the access tokens of these sessions are rejected by CookieAuth
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: error The error if the sessions cannot be revoked
+*/
+func RevokeAccountSessions(ctx context.Context, accountId primitive.ObjectID) error {
+	_, revokeErr := revokeSessions(ctx, bson.D{{Key: "account_id", Value: accountId}})
+	return revokeErr
+}
+
+/*
+Get the authorization level name of an account
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: string The level name of the account
+
+error The error if the account or its authorization does not exist
+*/
+func GetAccountLevelName(ctx context.Context, accountId primitive.ObjectID) (string, error) {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return "", accountQueryErr
+	}
+
+	var authorization model.Authorization
+	authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": account.Account_Authorization_Id}).Decode(&authorization)
+	if authorizationQueryErr != nil {
+		return "", authorizationQueryErr
+	}
+
+	return authorization.LevelName, nil
+}
+
+/*
+Get the active sessions of the logged in account, most recently seen first
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the sessions
+*/
+func GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+		sessions, queryErr := findActiveSessions(ctx, accountId)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying sessions: "+queryErr.Error())
+			return
+		}
+
+		// Tell the client which session it is using, requests with an access token have none
+		currentSessionId := ""
+		if currentSession, exists := c.Get("currentSession"); exists {
+			currentSessionId = currentSession.(primitive.ObjectID).Hex()
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"count":          len(sessions),
+			"currentSession": currentSessionId,
+			"sessions":       Redact(c, sessions, model.Session{}),
+		})
+	}
+}
+
+/*
+Sign out one session of the logged in account, its access token stops working and its refresh token cannot be rotated
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke a session
+*/
+func RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		sessionId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Session not found",
+			})
+			return
+		}
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+		revokedCount, revokeErr := revokeSessions(ctx, bson.D{
+			{Key: "_id", Value: sessionId},
+			{Key: "account_id", Value: accountId},
+		})
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking session: "+revokeErr.Error())
+			return
+		}
+
+		if revokedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Session not found",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditSessionRevoke, model.AuditOutcomeSuccess, "session", sessionId.Hex(), nil)
+
+		// Signing out the current session also removes its cookies
+		if currentSession, exists := c.Get("currentSession"); exists && currentSession.(primitive.ObjectID) == sessionId {
+			ClearTokenCookies(c)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Session revoked",
+		})
+	}
+}
+
+/*
+Sign out every session of the logged in account except the current one
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke the other sessions
+*/
+func RevokeOtherSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+		filter := bson.D{{Key: "account_id", Value: accountId}}
+		if currentSession, exists := c.Get("currentSession"); exists {
+			filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$ne", Value: currentSession.(primitive.ObjectID)}}})
+		}
+
+		revokedCount, revokeErr := revokeSessions(ctx, filter)
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditSessionRevokeOthers, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"revoked": revokedCount})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Other sessions revoked",
+			"revoked": revokedCount,
+		})
+	}
+}
+
+/*
+Get the active sessions of any account, most recently seen first
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the sessions of an account
+*/
+func GetAccountSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		accountId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Account not found",
+			})
+			return
+		}
+
+		sessions, queryErr := findActiveSessions(ctx, accountId)
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying sessions: "+queryErr.Error())
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"count":    len(sessions),
+			"sessions": Redact(c, sessions, model.Session{}),
+		})
+	}
+}
+
+/*
+Sign out one session of any account
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke a session of an account
+*/
+func RevokeAccountSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex strings to ObjectID
+		accountId, accountConvertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		sessionId, sessionConvertErr := primitive.ObjectIDFromHex(c.Param("sessionId"))
+		if accountConvertErr != nil || sessionConvertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Session not found",
+			})
+			return
+		}
+
+		revokedCount, revokeErr := revokeSessions(ctx, bson.D{
+			{Key: "_id", Value: sessionId},
+			{Key: "account_id", Value: accountId},
+		})
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking session: "+revokeErr.Error())
+			return
+		}
+
+		if revokedCount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Session not found",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditAccountSessionRevoke, model.AuditOutcomeSuccess, "session", sessionId.Hex(), gin.H{"account_id": accountId.Hex()})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Session revoked",
+		})
+	}
+}
+
+/*
+Sign out every session of any account
+
+params: None
+
+return: gin.HandlerFunc Handler function to revoke the sessions of an account
+*/
+func RevokeAllAccountSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Convert the hex string to ObjectID
+		accountId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Account not found",
+			})
+			return
+		}
+
+		revokedCount, revokeErr := revokeSessions(ctx, bson.D{{Key: "account_id", Value: accountId}})
+		if revokeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error revoking sessions: "+revokeErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditAccountSessionRevoke, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"revoked": revokedCount})
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Sessions revoked",
+			"revoked": revokedCount,
+		})
+	}
+}
+
+/*
+Get the sessions of an account that are not revoked or expired, most recently seen first
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: []model.Session The active sessions
+
+error The error of the DB query
+*/
+func findActiveSessions(ctx context.Context, accountId primitive.ObjectID) ([]model.Session, error) {
+	sessions := []model.Session{}
+	result, queryErr := sessionCollection.Find(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "revoked", Value: false},
+			{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		},
+		options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}),
+	)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	decodeErr := result.All(ctx, &sessions)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return sessions, nil
+}
+
+/*
+Revoke the sessions matching a filter that are not revoked yet
+
+params: ctx context.Context Context of the DB operations
+
+filter bson.D Filter of the sessions to revoke
+
+return: int64 Number of sessions revoked
+
+error The error if the sessions cannot be revoked
+*/
+func revokeSessions(ctx context.Context, filter bson.D) (int64, error) {
+	result, updateErr := sessionCollection.UpdateMany(
+		ctx,
+		append(filter, bson.E{Key: "revoked", Value: false}),
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "revoked", Value: true},
+				{Key: "revokedAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if updateErr != nil {
+		return 0, updateErr
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,467 @@
+/*
+Controller for logging in through the OpenID Connect identity provider
+
+1. SSOLogin: Send the browser to the identity provider
+
+2. SSOCallback: Finish the login when the identity provider sends the browser back
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errSSOEmailNotVerified = errors.New("email not verified by the identity provider")
+var errSSONoAccount = errors.New("no account for this email")
+var errSSOAmbiguousEmail = errors.New("several accounts have this email")
+var errSSOAlreadyLinked = errors.New("account linked to another user of the identity provider")
+var errSSOAccountPending = errors.New("the invitation of the account is not accepted")
+
+/*
+Start a login on the identity provider with the authorization code flow and PKCE, then redirect the browser to it
+
+params: None
+
+return: gin.HandlerFunc Handler function to start a single sign-on login
+*/
+func SSOLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		if !config.OIDCEnabled() {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Single sign-on is not configured",
+			})
+			return
+		}
+
+		// Random values of this login, only the hash of the code verifier is sent to the provider
+		state, stateErr := GenerateToken()
+		nonce, nonceErr := GenerateToken()
+		codeVerifier, verifierErr := GenerateToken()
+		if stateErr != nil || nonceErr != nil || verifierErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error generating token")
+			return
+		}
+		codeChallenge := sha256.Sum256([]byte(codeVerifier))
+
+		authorizationURL, discoveryErr := config.OIDCAuthorizationURL(ctx, state, nonce, base64.RawURLEncoding.EncodeToString(codeChallenge[:]))
+		if discoveryErr != nil {
+			c.JSON(http.StatusBadGateway, "Error reaching identity provider: "+discoveryErr.Error())
+			return
+		}
+
+		ssoLogin := model.SSOLogin{
+			Id:           primitive.NewObjectID(),
+			StateHash:    HashToken(state),
+			Nonce:        nonce,
+			CodeVerifier: codeVerifier,
+			ExpiresAt:    time.Now().Add(ssoLoginLifetime).Unix(),
+			CreatedAt:    time.Now().Unix(),
+			UpdatedAt:    time.Now().Unix(),
+		}
+		_, insertErr := ssoLoginCollection.InsertOne(ctx, ssoLogin)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting login: "+insertErr.Error())
+			return
+		}
+
+		// The state cookie ties the callback to this browser, it is sent on the redirect back from the provider
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     "sso_state",
+			Value:    state,
+			Expires:  time.Now().Add(ssoLoginLifetime),
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/sso/callback",
+		})
+
+		c.Redirect(http.StatusFound, authorizationURL)
+	}
+}
+
+/*
+Finish a login when the identity provider redirects the browser back, then redirect it to the frontend logged in
+
+The provider user is found by its subject, or linked to the account with its verified email, or given a new account when OIDC_JIT_PROVISIONING is true
+
+params: None
+
+return: gin.HandlerFunc Handler function of the single sign-on callback
+*/
+func SSOCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// The state must be the one given to this browser
+		state := c.Query("state")
+		stateCookie, _ := c.Cookie("sso_state")
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     "sso_state",
+			Value:    "",
+			Expires:  time.Now().Add(-time.Hour),
+			HttpOnly: true,
+			Secure:   false,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/sso/callback",
+		})
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(stateCookie)) != 1 {
+			failSSOLogin(c, "invalid_state", nil)
+			return
+		}
+
+		// Use up the login in one operation, so the callback cannot be replayed
+		var ssoLogin model.SSOLogin
+		useErr := ssoLoginCollection.FindOneAndUpdate(
+			ctx,
+			bson.D{
+				{Key: "state_hash", Value: HashToken(state)},
+				{Key: "used", Value: false},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "used", Value: true},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		).Decode(&ssoLogin)
+		if useErr != nil {
+			failSSOLogin(c, "expired", nil)
+			return
+		}
+
+		if providerErr := c.Query("error"); providerErr != "" {
+			failSSOLogin(c, "provider_error", gin.H{"error": providerErr})
+			return
+		}
+
+		identity, exchangeErr := config.OIDCExchangeCode(ctx, c.Query("code"), ssoLogin.CodeVerifier, ssoLogin.Nonce)
+		if exchangeErr != nil {
+			log.Println("[SSO] Error exchanging code: " + exchangeErr.Error())
+			failSSOLogin(c, "invalid_token", gin.H{"error": exchangeErr.Error()})
+			return
+		}
+
+		accountId, resolveErr := resolveSSOAccount(ctx, c, identity)
+		if resolveErr != nil {
+			reason := "error"
+			switch resolveErr {
+			case errSSOEmailNotVerified:
+				reason = "email_not_verified"
+			case errSSONoAccount:
+				reason = "no_account"
+			case errSSOAmbiguousEmail, errSSOAlreadyLinked:
+				reason = "cannot_link"
+			case errSSOAccountPending:
+				reason = "account_pending"
+			default:
+				log.Println("[SSO] Error finding account: " + resolveErr.Error())
+			}
+			failSSOLogin(c, reason, gin.H{"subject": identity.Subject, "email": identity.Email})
+			return
+		}
+
+		// The two-factor policy of the account applies like on a password login
+		requireTwoFactor, _ := AccountRequiresTwoFactor(ctx, accountId)
+		challengeKind := GetLoginChallengeKind(ctx, accountId, requireTwoFactor)
+		if challengeKind != "" {
+			challengeToken, challengeErr := CreateLoginChallenge(ctx, accountId, challengeKind)
+			if challengeErr != nil {
+				failSSOLogin(c, "error", gin.H{"error": challengeErr.Error()})
+				return
+			}
+
+			// The token is in the fragment so it is not sent to servers, the login continues on /login/two-factor
+			c.Redirect(http.StatusFound, os.Getenv("FRONTEND_URL")+"/login?two_factor="+challengeKind+"#challengeToken="+url.QueryEscape(challengeToken))
+			return
+		}
+
+		levelName, levelErr := GetAccountLevelName(ctx, accountId)
+		if levelErr != nil {
+			failSSOLogin(c, "no_account", gin.H{"account_id": accountId.Hex()})
+			return
+		}
+
+		// Create a session and send the same token cookies as a password login
+		sessionErr := CreateSession(ctx, c, accountId, levelName)
+		if sessionErr != nil {
+			failSSOLogin(c, "error", gin.H{"error": sessionErr.Error()})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"method": "sso", "subject": identity.Subject})
+
+		c.Redirect(http.StatusFound, os.Getenv("FRONTEND_URL")+"/")
+	}
+}
+
+/*
+Record a failed single sign-on login and redirect the browser to the login page of the frontend with the reason
+
+params: c *gin.Context Context of the request
+
+reason string Short reason shown to the frontend in sso_error
+
+details gin.H Additional information for the audit log, nil if none
+*/
+func failSSOLogin(c *gin.Context, reason string, details gin.H) {
+	if details == nil {
+		details = gin.H{}
+	}
+	details["method"] = "sso"
+	details["reason"] = reason
+	RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", "", details)
+
+	c.Redirect(http.StatusFound, os.Getenv("FRONTEND_URL")+"/login?sso_error="+url.QueryEscape(reason))
+}
+
+/*
+Find the account of a provider user, linking it by verified email or creating it on first login
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request, for the audit log
+
+identity config.OIDCIdentity The identity in the verified ID token
+
+return: primitive.ObjectID ID of the account
+
+error The error if no account can be found or created
+*/
+func resolveSSOAccount(ctx context.Context, c *gin.Context, identity config.OIDCIdentity) (primitive.ObjectID, error) {
+	var ssoIdentity model.SSOIdentity
+	findErr := ssoIdentityCollection.FindOneAndUpdate(
+		ctx,
+		bson.D{
+			{Key: "issuer", Value: identity.Issuer},
+			{Key: "subject", Value: identity.Subject},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "email", Value: identity.Email},
+				{Key: "lastLoginAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	).Decode(&ssoIdentity)
+	if findErr == nil {
+		return ssoIdentity.AccountId, nil
+	}
+	if findErr != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, findErr
+	}
+
+	// Only an email the provider vouches for can link the user to an account
+	if identity.Email == "" || !identity.EmailVerified {
+		return primitive.NilObjectID, errSSOEmailNotVerified
+	}
+
+	accountId, accountErr := findAccountIdByEmail(ctx, identity.Email)
+	switch {
+	case accountErr == errSSONoAccount && os.Getenv("OIDC_JIT_PROVISIONING") == "true":
+		accountId, accountErr = provisionSSOAccount(ctx, c, identity)
+		if accountErr != nil {
+			return primitive.NilObjectID, accountErr
+		}
+	case accountErr != nil:
+		return primitive.NilObjectID, accountErr
+	default:
+		// An account is linked to a single user of the provider
+		linkedCount, countErr := ssoIdentityCollection.CountDocuments(ctx, bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "issuer", Value: identity.Issuer},
+		})
+		if countErr != nil {
+			return primitive.NilObjectID, countErr
+		}
+		if linkedCount > 0 {
+			return primitive.NilObjectID, errSSOAlreadyLinked
+		}
+	}
+
+	ssoIdentity = model.SSOIdentity{
+		Id:          primitive.NewObjectID(),
+		AccountId:   accountId,
+		Issuer:      identity.Issuer,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: time.Now().Unix(),
+		CreatedAt:   time.Now().Unix(),
+		UpdatedAt:   time.Now().Unix(),
+	}
+	_, insertErr := ssoIdentityCollection.InsertOne(ctx, ssoIdentity)
+	if insertErr != nil {
+		return primitive.NilObjectID, insertErr
+	}
+
+	RecordAuditEvent(c, model.AuditSSOLink, model.AuditOutcomeSuccess, "account", accountId.Hex(), gin.H{"issuer": identity.Issuer, "subject": identity.Subject, "email": identity.Email})
+	return accountId, nil
+}
+
+/*
+Find the account of the only employee with an email, ignoring case
+
+params: ctx context.Context Context of the DB operations
+
+email string The email
+
+return: primitive.ObjectID ID of the account
+
+error errSSONoAccount if no employee has the email, errSSOAmbiguousEmail if several have it, errSSOAccountPending if the invitation of the employee is not accepted
+*/
+func findAccountIdByEmail(ctx context.Context, email string) (primitive.ObjectID, error) {
+	var userInfors []model.UserInfor
+	// Plaintext emails are matched ignoring case by the regex, encrypted ones by their blind index
+	filter := bson.M{"email": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.TrimSpace(email)) + "$", Options: "i"}}
+	if index := config.BlindIndex("email", email); index != "" {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"email_index": index}}}
+	}
+	result, queryErr := userInforCollection.Find(ctx, filter, options.Find().SetLimit(2))
+	if queryErr != nil {
+		return primitive.NilObjectID, queryErr
+	}
+	decodeErr := result.All(ctx, &userInfors)
+	if decodeErr != nil {
+		return primitive.NilObjectID, decodeErr
+	}
+
+	if len(userInfors) == 0 {
+		return primitive.NilObjectID, errSSONoAccount
+	}
+	if len(userInfors) > 1 {
+		return primitive.NilObjectID, errSSOAmbiguousEmail
+	}
+
+	var employee model.Employee
+	employeeQueryErr := employeeCollection.FindOne(ctx, bson.M{"userinfor_id": userInfors[0].Id}).Decode(&employee)
+	if employeeQueryErr == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, errSSONoAccount
+	}
+	if employeeQueryErr != nil {
+		return primitive.NilObjectID, employeeQueryErr
+	}
+	if employee.State == model.EmployeeStatePending {
+		return primitive.NilObjectID, errSSOAccountPending
+	}
+
+	return employee.AccountID, nil
+}
+
+/*
+Create an active account, user information and employee for a provider user, with the level named in OIDC_DEFAULT_AUTHORIZATION
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request, for the audit log
+
+identity config.OIDCIdentity The identity in the verified ID token
+
+return: primitive.ObjectID ID of the new account
+
+error The error if the level does not exist, the username is taken or an insert fails
+*/
+func provisionSSOAccount(ctx context.Context, c *gin.Context, identity config.OIDCIdentity) (primitive.ObjectID, error) {
+	var authorization model.Authorization
+	authorizationErr := authorizationCollection.FindOne(ctx, bson.M{"levelName": os.Getenv("OIDC_DEFAULT_AUTHORIZATION")}).Decode(&authorization)
+	if authorizationErr != nil {
+		return primitive.NilObjectID, errors.New("OIDC_DEFAULT_AUTHORIZATION is not an authorization level")
+	}
+
+	// The email is the username, the account has a random password nobody knows
+	username := strings.ToLower(strings.TrimSpace(identity.Email))
+	takenCount, countErr := accountCollection.CountDocuments(ctx, bson.M{"username": username})
+	if countErr != nil {
+		return primitive.NilObjectID, countErr
+	}
+	if takenCount > 0 {
+		return primitive.NilObjectID, errors.New("username " + username + " is taken")
+	}
+	randomPassword, generateErr := GenerateToken()
+	if generateErr != nil {
+		return primitive.NilObjectID, generateErr
+	}
+	hashedPassword, hashingErr := HashPassword(randomPassword)
+	if hashingErr != nil {
+		return primitive.NilObjectID, hashingErr
+	}
+
+	fullname := strings.TrimSpace(identity.Name)
+	if fullname == "" {
+		fullname = username
+	}
+
+	account := model.Account{
+		Id:                       primitive.NewObjectID(),
+		Username:                 username,
+		Password:                 hashedPassword,
+		Account_Name:             fullname,
+		Account_Authorization_Id: authorization.Id,
+		CreatedAt:                time.Now().Unix(),
+		UpdatedAt:                time.Now().Unix(),
+	}
+	userInfor := model.UserInfor{
+		Id:         primitive.NewObjectID(),
+		FullName:   fullname,
+		Office:     -1,
+		Department: -1,
+		Position:   -1,
+		Manager_ID: primitive.NilObjectID,
+		Email:      identity.Email,
+		CreatedAt:  time.Now().Unix(),
+		UpdatedAt:  time.Now().Unix(),
+	}
+	employee := model.Employee{
+		Id:          primitive.NewObjectID(),
+		State:       model.EmployeeStateActive,
+		AccountID:   account.Id,
+		UserInforId: userInfor.Id,
+		CreatedAt:   time.Now().Unix(),
+		UpdatedAt:   time.Now().Unix(),
+	}
+
+	_, accountInsertErr := accountCollection.InsertOne(ctx, account)
+	if accountInsertErr != nil {
+		return primitive.NilObjectID, accountInsertErr
+	}
+	userInforInsertErr := ProtectUserInfor(&userInfor)
+	if userInforInsertErr == nil {
+		_, userInforInsertErr = userInforCollection.InsertOne(ctx, userInfor)
+	}
+	if userInforInsertErr != nil {
+		accountCollection.DeleteOne(ctx, bson.M{"_id": account.Id})
+		return primitive.NilObjectID, userInforInsertErr
+	}
+	_, employeeInsertErr := employeeCollection.InsertOne(ctx, employee)
+	if employeeInsertErr != nil {
+		accountCollection.DeleteOne(ctx, bson.M{"_id": account.Id})
+		userInforCollection.DeleteOne(ctx, bson.M{"_id": userInfor.Id})
+		return primitive.NilObjectID, employeeInsertErr
+	}
+
+	RecordAuditEvent(c, model.AuditAccountCreate, model.AuditOutcomeSuccess, "account", account.Id.Hex(), gin.H{"username": username, "authorization": authorization.LevelName, "method": "sso"})
+	return account.Id, nil
+}
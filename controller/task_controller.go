@@ -3,11 +3,11 @@ Controller for handling data with Task model in DB
 
 1. CreateTask: Create one or many Tasks
 
-2. GetTasks: Get all Tasks from the DB
+2. GetTasks: Get the Tasks of the projects of the logged in employee
 
 3. GetTaskById: Get the Task by specified ID
 
-4. SearchTask: Search for Tasks by title
+4. SearchTask: Search for Tasks of the projects of the logged in employee by title
 
 5. UpdateTask: Update one or many Tasks by specified ID(s)
 
@@ -62,11 +62,94 @@ func CreateTask() gin.HandlerFunc {
 
 		fmt.Println("tasks:", tasks)
 
+		// Only leaders and contributors of the project owning the epic can create tasks
+		projectId, epicErr := GetProjectOfEpic(ctx, tasks.Epic)
+		if epicErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Epic not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
+		// A subtask belongs to the epic of its parent, and has no subtask itself. Checked after the access, so tasks of other projects stay hidden
+		if !tasks.Parent.IsZero() {
+			var parent model.Task
+			parentQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": tasks.Parent}).Decode(&parent)
+			if parentQueryErr != nil || parent.Epic != tasks.Epic || !parent.Parent.IsZero() {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "The parent must be a task of the same epic that is not a subtask",
+				})
+				return
+			}
+		}
+
+		// New tasks start in the initial status of the project workflow, later moves go through its transitions
+		workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+		if workflowErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying workflow: "+workflowErr.Error())
+			return
+		}
+		if tasks.Status != "" && tasks.Status != workflow.InitialStatus {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "New tasks start in " + workflow.InitialStatus,
+				"reason":  "illegal_transition",
+			})
+			return
+		}
+		tasks.Status = workflow.InitialStatus
+		tasks.Project = projectId
+
+		// Checklist items sent with the task keep their order
+		for i := range tasks.Checklist {
+			tasks.Checklist[i].Id = primitive.NewObjectID()
+			tasks.Checklist[i].Order = i
+			tasks.Checklist[i].CreatedAt = time.Now().Unix()
+			tasks.Checklist[i].UpdatedAt = time.Now().Unix()
+			itemErr := validate.Struct(&tasks.Checklist[i])
+			if itemErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "Invalid checklist item: " + itemErr.Error(),
+				})
+				return
+			}
+		}
+
+		// New tasks go to the bottom of their column
+		lastRank, rankErr := NextTaskRank(ctx, projectId, tasks.Status)
+		if rankErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error ranking task: "+rankErr.Error())
+			return
+		}
+
 		// Check if validation failed for any task in the array
 		var validationErrFlg = false
 		// Validation result array
 		var validationErrResult []gin.H
 
+		// Validate the dates, priority and estimates of the task
+		planErr := validate.StructPartial(tasks, taskPlanFields...)
+		if planErr != nil {
+			singleValidationErr := gin.H{
+				"element": 1,
+				"error":   []gin.H{},
+			}
+			for _, ve := range planErr.(validator.ValidationErrors) {
+				singleValidationErr["error"] = append(singleValidationErr["error"].([]gin.H), gin.H{
+					"field": ve.Field(),
+					"tag":   ve.Tag(),
+				})
+			}
+			validationErrResult = append(validationErrResult, singleValidationErr)
+			validationErrFlg = true
+		}
+
 		// If validation failed for any task in the array
 		if validationErrFlg {
 			// Return the validation errors to the client
@@ -76,8 +159,14 @@ func CreateTask() gin.HandlerFunc {
 			return
 		}
 
-		// Insert the specified document to DB
-		result, insertErr := taskCollection.InsertOne(ctx, tasks)
+		// Insert the specified document to DB, below a task created at the same time if it took the rank
+		var result *mongo.InsertOneResult
+		_, insertErr := rankedWrite(lastRank, "", func(rank string) error {
+			tasks.Rank = rank
+			var rankedInsertErr error
+			result, rankedInsertErr = taskCollection.InsertOne(ctx, tasks)
+			return rankedInsertErr
+		})
 		if insertErr != nil {
 			c.JSON(http.StatusInternalServerError, "Error inserting task: "+insertErr.Error())
 			return
@@ -99,7 +188,7 @@ func CreateTask() gin.HandlerFunc {
 }
 
 /*
-Get all Task from the DB
+Get the Tasks of the projects of the logged in employee, filtered and sorted by the query
 
 params: None
 
@@ -114,7 +203,30 @@ func GetTasks() gin.HandlerFunc {
 		//var tasks []model.Task // Use for the FindOne population method
 		var tasks []gin.H
 
-		// Define pipeline to join collections and sort the result
+		// Only the tasks of the projects of the employee, every project for project admins, or the project of the query
+		scope, scopeOk := taskScope(ctx, c, false)
+		if !scopeOk {
+			return
+		}
+		if len(scope) == 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"count": 0,
+				"tasks": []gin.H{},
+			})
+			return
+		}
+
+		// Filter and sort the tasks by the query, by title by default
+		listStages, listErr := taskListStages(c, "title")
+		if listErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": listErr.Error(),
+			})
+			return
+		}
+
+		// Define pipeline to join collections
 		pipeline := mongo.Pipeline{
 			bson.D{
 				{Key: "$lookup", Value: bson.D{
@@ -140,13 +252,11 @@ func GetTasks() gin.HandlerFunc {
 					{Key: "as", Value: "user_info"},
 				}},
 			},
-			bson.D{
-				{Key: "$sort", Value: bson.D{
-					{Key: "title", Value: 1},
-				}},
-			},
 		}
 
+		// Filter and sort before the lookups
+		pipeline = append(append(mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"$or": scope}}}}, listStages...), pipeline...)
+
 		// Use the $lookup stage to aggregate data from the Epics and Employee collections
 		result, aggregateErr := taskCollection.Aggregate(ctx, pipeline)
 		if aggregateErr != nil {
@@ -164,10 +274,17 @@ func GetTasks() gin.HandlerFunc {
 		// Close the cursor after getting data to prevent memory leak
 		result.Close(ctx)
 
+		// Flag the tasks waiting on an unfinished blocking task
+		blockedErr := AddBlockedFlags(ctx, tasks)
+		if blockedErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+			return
+		}
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"count": len(tasks),
-			"tasks": tasks,
+			"tasks": Redact(c, tasks, model.Task{}),
 		})
 	}
 }
@@ -187,12 +304,12 @@ func GetTaskById() gin.HandlerFunc {
 		// Create an instance of the Task model
 		var task []gin.H
 
-		// Convert the hex string to an ObjectID
-		queryId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
-		if convertErr != nil {
-			c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+		// Only members of the project owning the task can read it
+		rollupTask, projectId, accessOk := checkTaskAccess(ctx, c)
+		if !accessOk {
 			return
 		}
+		queryId := rollupTask.Id
 
 		// Define pipeline to filter the data by ID and join collections
 		pipeline := mongo.Pipeline{
@@ -244,15 +361,44 @@ func GetTaskById() gin.HandlerFunc {
 		// Close the cursor after getting data to prevent memory leak
 		result.Close(ctx)
 
+		if len(task) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+
+		// Flag the task if it waits on an unfinished blocking task
+		blockedErr := AddBlockedFlags(ctx, task)
+		if blockedErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+			return
+		}
+
+		// Count the done checklist items and subtasks
+		workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+		if workflowErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying workflow: "+workflowErr.Error())
+			return
+		}
+		rollup, subtasks, rollupErr := TaskRollup(ctx, rollupTask, workflow)
+		if rollupErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying subtasks: "+rollupErr.Error())
+			return
+		}
+		task[0]["rollup"] = rollup
+		task[0]["subtasks"] = subtasks
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
-			"task": task[0],
+			"task": Redact(c, task[0], model.Task{}),
 		})
 	}
 }
 
 /*
-Search for Tasks by title
+Search for Tasks of the projects of the logged in employee by title, filtered and sorted by the query
 
 params: None
 
@@ -269,6 +415,29 @@ func SearchTask() gin.HandlerFunc {
 		// Get the search data from request query
 		query := c.Query("q")
 
+		// Only the tasks of the projects of the employee, every project for project admins, or the project of the query
+		scope, scopeOk := taskScope(ctx, c, false)
+		if !scopeOk {
+			return
+		}
+		if len(scope) == 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"count": 0,
+				"tasks": []gin.H{},
+			})
+			return
+		}
+
+		// Filter and sort the tasks by the query, by title by default
+		listStages, listErr := taskListStages(c, "title")
+		if listErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": listErr.Error(),
+			})
+			return
+		}
+
 		// Define a pipeline to filter the data by title and join collections
 		pipeline := mongo.Pipeline{
 			bson.D{
@@ -303,13 +472,11 @@ func SearchTask() gin.HandlerFunc {
 					{Key: "as", Value: "user_info"},
 				}},
 			},
-			bson.D{
-				{Key: "$sort", Value: bson.D{
-					{Key: "title", Value: 1},
-				}},
-			},
 		}
 
+		// Filter and sort before the lookups
+		pipeline = append(append(mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{"$or": scope}}}}, listStages...), pipeline...)
+
 		// Use the defined stages to aggregate data from the Epics and Employee collections
 		result, aggregateErr := taskCollection.Aggregate(ctx, pipeline)
 		if aggregateErr != nil {
@@ -327,10 +494,17 @@ func SearchTask() gin.HandlerFunc {
 		// Close the cursor after getting data to prevent memory leak
 		result.Close(ctx)
 
+		// Flag the tasks waiting on an unfinished blocking task
+		blockedErr := AddBlockedFlags(ctx, tasks)
+		if blockedErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+			return
+		}
+
 		// Send response to client
 		c.JSON(http.StatusOK, gin.H{
 			"count": len(tasks),
-			"tasks": tasks,
+			"tasks": Redact(c, tasks, model.Task{}),
 		})
 	}
 }
@@ -353,9 +527,19 @@ func UpdateTask() gin.HandlerFunc {
 
 		// Create an array of the Task
 		var tasks []model.Task
+		// The dates, priority and estimates sent for each task, to tell the kept fields from the removed ones
+		var planPatches []taskPlanPatch_struct
 
 		// Bind the request body to the task model
-		bindingErr := c.BindJSON(&tasks)
+		body, readingErr := c.GetRawData()
+		if readingErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error reading request body: "+readingErr.Error())
+			return
+		}
+		bindingErr := json.Unmarshal(body, &tasks)
+		if bindingErr == nil {
+			bindingErr = json.Unmarshal(body, &planPatches)
+		}
 		if bindingErr != nil {
 			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
 			return
@@ -391,7 +575,7 @@ func UpdateTask() gin.HandlerFunc {
 			}
 
 			// Temp variable to decode the FindOne result
-			var result bson.M
+			var result model.Task
 			// Validate the ID existence in DB
 			decodeErr := taskCollection.FindOne(ctx, bson.M{"_id": task.Id}).Decode(&result)
 			if decodeErr != nil {
@@ -431,6 +615,25 @@ func UpdateTask() gin.HandlerFunc {
 				}
 			}
 
+			// The due date stays after the start date when one of them is kept, the task validation checks them when both are sent
+			if decodeErr == nil && (planPatches[i].StartDate == nil || planPatches[i].DueDate == nil) {
+				planErr := validate.StructPartial(mergeTaskPlan(result, task, planPatches[i]), "DueDate")
+				if planErr != nil {
+					if singleValidationErr == nil {
+						singleValidationErr = gin.H{
+							"element": i + 1,
+							"error":   []gin.H{},
+						}
+					}
+					for _, ve := range planErr.(validator.ValidationErrors) {
+						singleValidationErr["error"] = append(singleValidationErr["error"].([]gin.H), gin.H{
+							"field": ve.Field(),
+							"tag":   ve.Tag(),
+						})
+					}
+				}
+			}
+
 			// If validation failed for the current task
 			if singleValidationErr != nil {
 				// Add the single validation error to the validation error result array
@@ -452,13 +655,14 @@ func UpdateTask() gin.HandlerFunc {
 
 		// Check the length of tasks array to update appropriately
 		if len(tasks) == 1 {
-			// Update the fields of an task in DB
-			update := bson.M{
-				"$set": bson.M{
-					"title":       tasks[0].Title,
-					"description": tasks[0].Description,
-					"updatedAt":   time.Now(),
-				},
+			// Update the fields of an task in DB, the status only changes through TransitionTask, dates, priority and estimates sent as null are removed
+			planSet, planUnset := taskPlanUpdate(tasks[0], planPatches[0])
+			planSet["title"] = tasks[0].Title
+			planSet["description"] = tasks[0].Description
+			planSet["updatedAt"] = time.Now()
+			update := bson.M{"$set": planSet}
+			if len(planUnset) > 0 {
+				update["$unset"] = planUnset
 			}
 
 			// Find and update the task in DB
@@ -478,12 +682,13 @@ func UpdateTask() gin.HandlerFunc {
 
 			for i, task := range tasks {
 				// Update the fields of each task in DB
-				update := bson.M{
-					"$set": bson.M{
-						"title":       task.Title,
-						"description": task.Description,
-						"updatedAt":   time.Now(),
-					},
+				planSet, planUnset := taskPlanUpdate(task, planPatches[i])
+				planSet["title"] = task.Title
+				planSet["description"] = task.Description
+				planSet["updatedAt"] = time.Now()
+				update := bson.M{"$set": planSet}
+				if len(planUnset) > 0 {
+					update["$unset"] = planUnset
 				}
 
 				// Find and update each task in DB
@@ -509,7 +714,7 @@ func UpdateTask() gin.HandlerFunc {
 }
 
 /*
-Delete one or many Tasks by specified ID(s)
+Delete the Task of the :id parameter, or the Tasks of the IDs in the JSON body
 
 params: None
 
@@ -520,30 +725,76 @@ func DeleteTask() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
 		defer cancel()
 
-		// Read the raw body from the request
-		body, readingErr := io.ReadAll(c.Request.Body)
-		if readingErr != nil {
-			c.JSON(http.StatusInternalServerError, "Error reading request body: "+readingErr.Error())
-			return
-		}
-
-		// Unmarshal the raw body into a slice of string
-		var objectIdStrings []string
-		unmarshalErr := json.Unmarshal(body, &objectIdStrings)
-		if unmarshalErr != nil {
-			c.JSON(http.StatusBadRequest, "Error unmarshalling request body: "+unmarshalErr.Error())
-			return
-		}
-
-		// Convert each element in the slice to ObjectID
+		// Convert each task ID, the :id parameter or the IDs of the JSON body, to ObjectID
 		var deleteArr []primitive.ObjectID
-		for _, objectIdString := range objectIdStrings {
-			id, convertErr := primitive.ObjectIDFromHex(objectIdString)
+		if c.Param("id") != "" {
+			id, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
 			if convertErr != nil {
 				c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
 				return
 			}
 			deleteArr = append(deleteArr, id)
+		} else {
+			// Read the raw body from the request
+			body, readingErr := io.ReadAll(c.Request.Body)
+			if readingErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error reading request body: "+readingErr.Error())
+				return
+			}
+
+			// Unmarshal the raw body into a slice of string
+			var objectIdStrings []string
+			unmarshalErr := json.Unmarshal(body, &objectIdStrings)
+			if unmarshalErr != nil {
+				c.JSON(http.StatusBadRequest, "Error unmarshalling request body: "+unmarshalErr.Error())
+				return
+			}
+
+			for _, objectIdString := range objectIdStrings {
+				id, convertErr := primitive.ObjectIDFromHex(objectIdString)
+				if convertErr != nil {
+					c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+					return
+				}
+				deleteArr = append(deleteArr, id)
+			}
+		}
+
+		// Only leaders and contributors of the projects owning the tasks can delete them
+		for _, taskId := range deleteArr {
+			if _, _, accessOk := checkTaskIdAccess(ctx, c, taskId, model.ProjectRoleLeader, model.ProjectRoleContributor); !accessOk {
+				return
+			}
+		}
+
+		// Subtasks of deleted tasks are deleted with them or become tasks of their own, as chosen with ?subtasks=cascade|promote
+		subtaskFilter := bson.M{"parent": bson.M{"$in": deleteArr}, "_id": bson.M{"$nin": deleteArr}}
+		subtaskIds, subtaskQueryErr := taskCollection.Distinct(ctx, "_id", subtaskFilter)
+		if subtaskQueryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying subtasks: "+subtaskQueryErr.Error())
+			return
+		}
+		if len(subtaskIds) > 0 {
+			switch c.Query("subtasks") {
+			case "cascade":
+				for _, subtaskId := range subtaskIds {
+					deleteArr = append(deleteArr, subtaskId.(primitive.ObjectID))
+				}
+			case "promote":
+				_, promoteErr := taskCollection.UpdateMany(ctx, subtaskFilter, bson.M{"$unset": bson.M{"parent": ""}, "$set": bson.M{"updatedAt": time.Now()}})
+				if promoteErr != nil {
+					c.JSON(http.StatusInternalServerError, "Error promoting subtasks: "+promoteErr.Error())
+					return
+				}
+			default:
+				c.JSON(http.StatusConflict, gin.H{
+					"success":  false,
+					"message":  "The tasks have subtasks, choose to delete them with subtasks=cascade or keep them with subtasks=promote",
+					"reason":   "subtasks_choice",
+					"subtasks": len(subtaskIds),
+				})
+				return
+			}
 		}
 
 		// Check the length of the delete array to delete appropriately
@@ -555,6 +806,13 @@ func DeleteTask() gin.HandlerFunc {
 				return
 			}
 
+			// Delete the links of the task
+			_, linkDeleteErr := taskLinkCollection.DeleteMany(ctx, bson.M{"$or": bson.A{bson.M{"source": deleteArr[0]}, bson.M{"target": deleteArr[0]}}})
+			if linkDeleteErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error deleting task links: "+linkDeleteErr.Error())
+				return
+			}
+
 			// Send response to client
 			c.JSON(http.StatusOK, gin.H{
 				"msg": strconv.Itoa(int(result.DeletedCount)) + " task deleted",
@@ -567,6 +825,13 @@ func DeleteTask() gin.HandlerFunc {
 				return
 			}
 
+			// Delete the links of the tasks
+			_, linkDeleteErr := taskLinkCollection.DeleteMany(ctx, bson.M{"$or": bson.A{bson.M{"source": bson.M{"$in": deleteArr}}, bson.M{"target": bson.M{"$in": deleteArr}}}})
+			if linkDeleteErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error deleting task links: "+linkDeleteErr.Error())
+				return
+			}
+
 			// Send response to client
 			c.JSON(http.StatusOK, gin.H{
 				"msg": strconv.Itoa(int(result.DeletedCount)) + " tasks deleted",
@@ -0,0 +1,595 @@
+/*
+Controller for handling data with TaskLink model in DB
+
+1. CreateTaskLink: Link a Task to another Task of its Project
+
+2. DeleteTaskLink: Delete a link of a Task
+
+3. GetTaskGraph: Get the Tasks linked to a Task, directly or not
+
+4. GetEpicGraph: Get the Tasks linked to the Tasks of an Epic, directly or not
+
+5. AddBlockedFlags: Flag the Tasks with a blocking Task that is not done
+
+6. AddEmbeddedBlockedFlags: Flag the Tasks embedded in documents with a blocking Task that is not done
+*/
+package controller
+
+import (
+	"backend/model"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Most tasks in a dependency graph, larger graphs are cut
+const taskGraphLimit = 500
+
+// Link types with a direction, which may not form cycles
+var directedTaskLinks = []string{model.TaskLinkBlocks, model.TaskLinkDuplicates}
+
+type createTaskLink_struct struct {
+	Target primitive.ObjectID `json:"target" validate:"required"`
+	Type   string             `json:"type" validate:"required,oneof=blocks relates_to duplicates"`
+}
+
+/*
+Link a Task to another Task of its Project, a blocks or duplicates link closing a cycle is refused
+
+params: None
+
+return: gin.HandlerFunc Handler function to create a task link
+*/
+func CreateTaskLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		sourceId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+			return
+		}
+
+		var request createTaskLink_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid link: " + validationErr.Error(),
+			})
+			return
+		}
+		if request.Target == sourceId {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "A task cannot be linked to itself",
+			})
+			return
+		}
+
+		projectId, sourceErr := projectOfTask(ctx, sourceId)
+		if sourceErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
+		// Links may cross epics, not projects
+		targetProjectId, targetErr := projectOfTask(ctx, request.Target)
+		if targetErr != nil || targetProjectId != projectId {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The target must be a task of the same project",
+			})
+			return
+		}
+
+		// Relates to links have no direction, either one counts
+		existingFilter := bson.M{"type": request.Type, "source": sourceId, "target": request.Target}
+		if request.Type == model.TaskLinkRelatesTo {
+			existingFilter = bson.M{"type": request.Type, "$or": bson.A{
+				bson.M{"source": sourceId, "target": request.Target},
+				bson.M{"source": request.Target, "target": sourceId},
+			}}
+		}
+		existingCount, countErr := taskLinkCollection.CountDocuments(ctx, existingFilter)
+		if countErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying links: "+countErr.Error())
+			return
+		}
+		if existingCount > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The tasks are already linked",
+				"reason":  "link_exists",
+			})
+			return
+		}
+
+		cycle, cycleErr := taskLinkCycle(ctx, request.Type, sourceId, request.Target)
+		if cycleErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying links: "+cycleErr.Error())
+			return
+		}
+		if cycle != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The link would close a cycle",
+				"reason":  "link_cycle",
+				"cycle":   cycle,
+			})
+			return
+		}
+
+		link := model.TaskLink{
+			Id:        primitive.NewObjectID(),
+			Project:   projectId,
+			Source:    sourceId,
+			Target:    request.Target,
+			Type:      request.Type,
+			CreatedBy: CurrentEmployeeId(c),
+			CreatedAt: time.Now().Unix(),
+		}
+		_, insertErr := taskLinkCollection.InsertOne(ctx, link)
+		if insertErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error inserting link: "+insertErr.Error())
+			return
+		}
+
+		// Check again, a link created meanwhile in the other direction would have closed a cycle with this one
+		cycle, cycleErr = taskLinkCycle(ctx, request.Type, sourceId, request.Target)
+		if cycleErr != nil {
+			// The link is not kept unchecked
+			_, _ = taskLinkCollection.DeleteOne(ctx, bson.M{"_id": link.Id})
+			c.JSON(http.StatusInternalServerError, "Error querying links: "+cycleErr.Error())
+			return
+		}
+		if cycle != nil {
+			_, _ = taskLinkCollection.DeleteOne(ctx, bson.M{"_id": link.Id})
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The link would close a cycle",
+				"reason":  "link_cycle",
+				"cycle":   cycle,
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusCreated, gin.H{
+			"success": true,
+			"message": "Tasks linked",
+			"link":    link,
+		})
+	}
+}
+
+/*
+Delete a link of a Task, as its source or its target
+
+params: None
+
+return: gin.HandlerFunc Handler function to delete a task link
+*/
+func DeleteTaskLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		taskId, taskConvertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		linkId, linkConvertErr := primitive.ObjectIDFromHex(c.Param("link"))
+		if taskConvertErr != nil || linkConvertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid ID")
+			return
+		}
+
+		projectId, taskErr := projectOfTask(ctx, taskId)
+		if taskErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
+		result, deleteErr := taskLinkCollection.DeleteOne(ctx, bson.M{
+			"_id": linkId,
+			"$or": bson.A{bson.M{"source": taskId}, bson.M{"target": taskId}},
+		})
+		if deleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error deleting link: "+deleteErr.Error())
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Link not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Link deleted",
+		})
+	}
+}
+
+/*
+Get the Tasks linked to a Task, directly or through other Tasks, with the links between them
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the dependency graph of a task
+*/
+func GetTaskGraph() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		taskId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+			return
+		}
+		projectId, taskErr := projectOfTask(ctx, taskId)
+		if taskErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		sendTaskGraph(ctx, c, projectId, []primitive.ObjectID{taskId})
+	}
+}
+
+/*
+Get the Tasks linked to the Tasks of an Epic, directly or through other Tasks, with the links between them
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the dependency graph of an epic
+*/
+func GetEpicGraph() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		epicId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid epic ID: "+convertErr.Error())
+			return
+		}
+		projectId, epicErr := GetProjectOfEpic(ctx, epicId)
+		if epicErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Epic not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		taskIds, distinctErr := taskCollection.Distinct(ctx, "_id", bson.M{"epic": epicId})
+		if distinctErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying tasks: "+distinctErr.Error())
+			return
+		}
+		seeds := []primitive.ObjectID{}
+		for _, taskId := range taskIds {
+			if id, isId := taskId.(primitive.ObjectID); isId {
+				seeds = append(seeds, id)
+			}
+		}
+
+		sendTaskGraph(ctx, c, projectId, seeds)
+	}
+}
+
+/*
+Flag the Tasks with a blocking Task that is not in a done status of the workflow of its project.
+Sets blocked and blockedBy, the IDs of the unfinished blocking tasks, on each task
+
+params: ctx context.Context Context of the DB operations
+
+tasks []gin.H The tasks, with their _id
+
+return: error The error of the DB queries
+*/
+func AddBlockedFlags(ctx context.Context, tasks []gin.H) error {
+	taskIds := []primitive.ObjectID{}
+	for _, task := range tasks {
+		if taskId, isId := task["_id"].(primitive.ObjectID); isId {
+			taskIds = append(taskIds, taskId)
+		}
+		task["blocked"] = false
+		task["blockedBy"] = []primitive.ObjectID{}
+	}
+	if len(taskIds) == 0 {
+		return nil
+	}
+
+	var links []model.TaskLink
+	linkResult, linkQueryErr := taskLinkCollection.Find(ctx, bson.M{"type": model.TaskLinkBlocks, "target": bson.M{"$in": taskIds}})
+	if linkQueryErr != nil {
+		return linkQueryErr
+	}
+	if decodeErr := linkResult.All(ctx, &links); decodeErr != nil {
+		return decodeErr
+	}
+	if len(links) == 0 {
+		return nil
+	}
+
+	sourceIds := []primitive.ObjectID{}
+	for _, link := range links {
+		sourceIds = append(sourceIds, link.Source)
+	}
+	var sources []model.Task
+	sourceResult, sourceQueryErr := taskCollection.Find(ctx, bson.M{"_id": bson.M{"$in": sourceIds}})
+	if sourceQueryErr != nil {
+		return sourceQueryErr
+	}
+	if decodeErr := sourceResult.All(ctx, &sources); decodeErr != nil {
+		return decodeErr
+	}
+
+	sourceStatuses := map[primitive.ObjectID]string{}
+	for _, source := range sources {
+		sourceStatuses[source.Id] = source.Status
+	}
+
+	// A blocking task is unfinished while its status is not in the done category of its project workflow
+	workflows := map[primitive.ObjectID]model.Workflow{}
+	blockers := map[primitive.ObjectID][]primitive.ObjectID{}
+	for _, link := range links {
+		sourceStatus, exists := sourceStatuses[link.Source]
+		if !exists {
+			continue
+		}
+		workflow, loaded := workflows[link.Project]
+		if !loaded {
+			var workflowErr error
+			workflow, workflowErr = ProjectWorkflow(ctx, link.Project)
+			if workflowErr != nil {
+				return workflowErr
+			}
+			workflows[link.Project] = workflow
+		}
+		if status, known := workflowStatus(workflow, sourceStatus); !known || status.Category != model.WorkflowCategoryDone {
+			blockers[link.Target] = append(blockers[link.Target], link.Source)
+		}
+	}
+	for _, task := range tasks {
+		taskId, _ := task["_id"].(primitive.ObjectID)
+		if blockedBy, blocked := blockers[taskId]; blocked {
+			task["blocked"] = true
+			task["blockedBy"] = blockedBy
+		}
+	}
+
+	return nil
+}
+
+/*
+Flag the Tasks embedded in documents by a lookup, like the tasks of epics, see AddBlockedFlags
+
+params: ctx context.Context Context of the DB operations
+
+documents []gin.H The documents, with their tasks in the field
+
+field string Name of the field holding the tasks
+
+return: error The error of the DB queries
+*/
+func AddEmbeddedBlockedFlags(ctx context.Context, documents []gin.H, field string) error {
+	tasks := []gin.H{}
+	for _, document := range documents {
+		embedded, isArray := document[field].(primitive.A)
+		if !isArray {
+			continue
+		}
+		for _, item := range embedded {
+			switch task := item.(type) {
+			case gin.H:
+				tasks = append(tasks, task)
+			case primitive.M:
+				tasks = append(tasks, gin.H(task))
+			case map[string]interface{}:
+				tasks = append(tasks, gin.H(task))
+			}
+		}
+	}
+
+	return AddBlockedFlags(ctx, tasks)
+}
+
+/*
+Send the Tasks linked to some Tasks, directly or not, with the links between them
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project of the tasks
+
+seeds []primitive.ObjectID IDs of the tasks the graph starts from
+*/
+func sendTaskGraph(ctx context.Context, c *gin.Context, projectId primitive.ObjectID, seeds []primitive.ObjectID) {
+	// Follow the links of every type both ways, one layer of tasks per query
+	visited := map[primitive.ObjectID]bool{}
+	for _, seed := range seeds {
+		visited[seed] = true
+	}
+	linkIds := map[primitive.ObjectID]bool{}
+	links := []model.TaskLink{}
+	frontier := seeds
+	truncated := false
+	for len(frontier) > 0 {
+		var layer []model.TaskLink
+		result, queryErr := taskLinkCollection.Find(ctx, bson.M{"project": projectId, "$or": bson.A{
+			bson.M{"source": bson.M{"$in": frontier}},
+			bson.M{"target": bson.M{"$in": frontier}},
+		}})
+		if queryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying links: "+queryErr.Error())
+			return
+		}
+		if decodeErr := result.All(ctx, &layer); decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding links: "+decodeErr.Error())
+			return
+		}
+
+		frontier = nil
+		for _, link := range layer {
+			if linkIds[link.Id] {
+				continue
+			}
+			for _, taskId := range []primitive.ObjectID{link.Source, link.Target} {
+				if !visited[taskId] && len(visited) >= taskGraphLimit {
+					truncated = true
+				} else if !visited[taskId] {
+					visited[taskId] = true
+					frontier = append(frontier, taskId)
+				}
+			}
+			if visited[link.Source] && visited[link.Target] {
+				linkIds[link.Id] = true
+				links = append(links, link)
+			}
+		}
+	}
+
+	taskIds := []primitive.ObjectID{}
+	for taskId := range visited {
+		taskIds = append(taskIds, taskId)
+	}
+	var tasks []gin.H
+	result, queryErr := taskCollection.Find(ctx, bson.M{"_id": bson.M{"$in": taskIds}})
+	if queryErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error querying tasks: "+queryErr.Error())
+		return
+	}
+	if decodeErr := result.All(ctx, &tasks); decodeErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error decoding tasks: "+decodeErr.Error())
+		return
+	}
+	if tasks == nil {
+		tasks = []gin.H{}
+	}
+	if blockedErr := AddBlockedFlags(ctx, tasks); blockedErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+		return
+	}
+
+	// Send response to client
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"tasks":     Redact(c, tasks, model.Task{}),
+		"links":     links,
+		"truncated": truncated,
+	})
+}
+
+/*
+Find the path a new directed link would close into a cycle, following links of its type from its target
+
+params: ctx context.Context Context of the DB operations
+
+linkType string Type of the link, relates to links never form cycles
+
+source primitive.ObjectID ID of the source task of the link
+
+target primitive.ObjectID ID of the target task of the link
+
+return: []primitive.ObjectID The tasks of the cycle from the source back to it, nil if there is none
+
+error The error of the DB queries
+*/
+func taskLinkCycle(ctx context.Context, linkType string, source, target primitive.ObjectID) ([]primitive.ObjectID, error) {
+	directed := false
+	for _, directedType := range directedTaskLinks {
+		directed = directed || directedType == linkType
+	}
+	if !directed {
+		return nil, nil
+	}
+
+	// Breadth first from the target, remembering how each task was reached
+	previous := map[primitive.ObjectID]primitive.ObjectID{target: source}
+	frontier := []primitive.ObjectID{target}
+	for len(frontier) > 0 {
+		var layer []model.TaskLink
+		result, queryErr := taskLinkCollection.Find(ctx, bson.M{"type": linkType, "source": bson.M{"$in": frontier}})
+		if queryErr != nil {
+			return nil, queryErr
+		}
+		if decodeErr := result.All(ctx, &layer); decodeErr != nil {
+			return nil, decodeErr
+		}
+
+		frontier = nil
+		for _, link := range layer {
+			if link.Target == source {
+				cycle := []primitive.ObjectID{source}
+				for taskId := link.Source; taskId != source; taskId = previous[taskId] {
+					cycle = append([]primitive.ObjectID{taskId}, cycle...)
+				}
+				return append([]primitive.ObjectID{source}, cycle...), nil
+			}
+			if _, seen := previous[link.Target]; !seen {
+				previous[link.Target] = link.Source
+				frontier = append(frontier, link.Target)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+/*
+Get the project of a Task, through its epic
+
+params: ctx context.Context Context of the DB operations
+
+taskId primitive.ObjectID ID of the task
+
+return: primitive.ObjectID ID of the project
+
+error The error if the task or its epic does not exist
+*/
+func projectOfTask(ctx context.Context, taskId primitive.ObjectID) (primitive.ObjectID, error) {
+	var task model.Task
+	taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId}).Decode(&task)
+	if taskQueryErr != nil {
+		return primitive.NilObjectID, taskQueryErr
+	}
+
+	return GetProjectOfEpic(ctx, task.Epic)
+}
@@ -0,0 +1,517 @@
+/*
+Controller for the dates, priorities and estimates of Tasks
+
+1. GetOverdueTasks: Get the unfinished Tasks past their due date
+
+2. GetTasksDueThisWeek: Get the unfinished Tasks due in the current week
+
+3. GetMyUpcomingTasks: Get the unfinished Tasks of the logged in employee due in the next days
+
+4. EnsureTaskPlanIndexes: Create the due date indexes of the Tasks, at startup
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Most tasks returned by a due date query, longer lists are cut
+const plannedTaskLimit = 500
+
+// Days ahead the upcoming tasks are looked for, when not specified
+const upcomingTaskDays = 14
+
+// Most days ahead the upcoming tasks can be looked for
+const upcomingTaskMaxDays = 90
+
+// Fields the task lists sort by, the priority sorts by its position in the scale
+var taskSortFields = map[string]string{
+	"title":         "title",
+	"dueDate":       "dueDate",
+	"startDate":     "startDate",
+	"priority":      "priorityRank",
+	"storyPoints":   "storyPoints",
+	"estimateHours": "estimateHours",
+	"createdAt":     "createdAt",
+}
+
+// Fields of a task the plan validation checks
+var taskPlanFields = []string{"StartDate", "DueDate", "Priority", "StoryPoints", "EstimateHours"}
+
+// The dates, priority and estimates of a task update as sent: nil when not sent, null to remove them
+type taskPlanPatch_struct struct {
+	StartDate     json.RawMessage `json:"startDate"`
+	DueDate       json.RawMessage `json:"dueDate"`
+	Priority      json.RawMessage `json:"priority"`
+	StoryPoints   json.RawMessage `json:"storyPoints"`
+	EstimateHours json.RawMessage `json:"estimateHours"`
+}
+
+// A field of a task update with its value and the JSON sent for it
+type taskPlanField struct {
+	name  string
+	value interface{}
+	sent  json.RawMessage
+}
+
+/*
+Get the unfinished Tasks past their due date, within the projects of the logged in employee
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the overdue tasks
+*/
+func GetOverdueTasks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		sendPlannedTasks(ctx, c, bson.M{"dueDate": bson.M{"$lt": time.Now()}})
+	}
+}
+
+/*
+Get the unfinished Tasks due from Monday to Sunday of the current week, within the projects of the logged in employee
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the tasks due this week
+*/
+func GetTasksDueThisWeek() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		// Weeks start on Monday at midnight, server time
+		now := time.Now()
+		weekStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		weekStart = weekStart.AddDate(0, 0, -(int(weekStart.Weekday())+6)%7)
+
+		sendPlannedTasks(ctx, c, bson.M{"dueDate": bson.M{"$gte": weekStart, "$lt": weekStart.AddDate(0, 0, 7)}})
+	}
+}
+
+/*
+Get the unfinished Tasks of the logged in employee due in the next days, 14 unless the days query says otherwise
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the upcoming tasks of the employee
+*/
+func GetMyUpcomingTasks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		days := upcomingTaskDays
+		if c.Query("days") != "" {
+			var daysErr error
+			days, daysErr = strconv.Atoi(c.Query("days"))
+			if daysErr != nil || days < 1 || days > upcomingTaskMaxDays {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"message": "Days must be a number from 1 to " + strconv.Itoa(upcomingTaskMaxDays),
+				})
+				return
+			}
+		}
+
+		now := time.Now()
+		sendPlannedTasks(ctx, c, bson.M{
+			"members": CurrentEmployeeId(c),
+			"dueDate": bson.M{"$gte": now, "$lt": now.AddDate(0, 0, days)},
+		})
+	}
+}
+
+/*
+Send the unfinished Tasks matching a filter within the projects of the logged in employee, or the project query.
+The list filters and sort of the query apply, the earliest due first by default
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+filter bson.M Filter of the tasks on their dates and members
+*/
+func sendPlannedTasks(ctx context.Context, c *gin.Context, filter bson.M) {
+	scope, scopeOk := taskScope(ctx, c, true)
+	if !scopeOk {
+		return
+	}
+
+	listStages, listErr := taskListStages(c, "dueDate")
+	if listErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": listErr.Error(),
+		})
+		return
+	}
+
+	// Without a project in reach there is nothing to look for
+	tasks := []gin.H{}
+	if len(scope) > 0 {
+		pipeline := mongo.Pipeline{
+			bson.D{{Key: "$match", Value: filter}},
+			bson.D{{Key: "$match", Value: bson.M{"$or": scope}}},
+		}
+		pipeline = append(pipeline, listStages...)
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: plannedTaskLimit + 1}})
+
+		result, aggregateErr := taskCollection.Aggregate(ctx, pipeline)
+		if aggregateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error aggregating tasks: "+aggregateErr.Error())
+			return
+		}
+		decodeErr := result.All(ctx, &tasks)
+		if decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding tasks: "+decodeErr.Error())
+			return
+		}
+	}
+
+	truncated := len(tasks) > plannedTaskLimit
+	if truncated {
+		tasks = tasks[:plannedTaskLimit]
+	}
+
+	// Flag the tasks waiting on an unfinished blocking task
+	blockedErr := AddBlockedFlags(ctx, tasks)
+	if blockedErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error checking blocked tasks: "+blockedErr.Error())
+		return
+	}
+
+	// Send response to client
+	c.JSON(http.StatusOK, gin.H{
+		"count":     len(tasks),
+		"truncated": truncated,
+		"tasks":     Redact(c, tasks, model.Task{}),
+	})
+}
+
+/*
+Get the filters matching the Tasks of the projects the logged in employee is a member or the leader of,
+every project for project admins, or only the project of the project query.
+Sends the error response to the client when the project query is invalid or not accessible
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+unfinished bool True to match only the tasks out of the done category of their workflow
+
+return: []bson.M One filter per project, on the epics of the project, empty if no project is in reach
+
+bool True if the filters could be built
+*/
+func taskScope(ctx context.Context, c *gin.Context, unfinished bool) ([]bson.M, bool) {
+	projectFilter := bson.M{}
+	if c.Query("project") != "" {
+		projectId, idErr := primitive.ObjectIDFromHex(c.Query("project"))
+		if idErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid project ID")
+			return nil, false
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return nil, false
+		}
+		projectFilter = bson.M{"_id": projectId}
+	} else if !middleware.HasPermission(c, model.PermissionProjectAdmin) {
+		employeeId := CurrentEmployeeId(c)
+		var members []model.ProjectMember
+		memberResult, memberQueryErr := projectMemberCollection.Find(ctx, bson.M{"employee": employeeId})
+		if memberQueryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying project members: "+memberQueryErr.Error())
+			return nil, false
+		}
+		if decodeErr := memberResult.All(ctx, &members); decodeErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error decoding project members: "+decodeErr.Error())
+			return nil, false
+		}
+
+		// Projects created before memberships existed only know their leader
+		projectIds := []primitive.ObjectID{}
+		for _, member := range members {
+			projectIds = append(projectIds, member.Project)
+		}
+		projectFilter = bson.M{"$or": []bson.M{
+			{"_id": bson.M{"$in": projectIds}},
+			{"leader": employeeId},
+		}}
+	}
+
+	var projects []model.Project
+	projectResult, projectQueryErr := projectCollection.Find(ctx, projectFilter)
+	if projectQueryErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error querying projects: "+projectQueryErr.Error())
+		return nil, false
+	}
+	if decodeErr := projectResult.All(ctx, &projects); decodeErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error decoding projects: "+decodeErr.Error())
+		return nil, false
+	}
+	if len(projects) == 0 {
+		return nil, true
+	}
+
+	projectIds := []primitive.ObjectID{}
+	for _, project := range projects {
+		projectIds = append(projectIds, project.Id)
+	}
+	var epics []model.Epic
+	epicResult, epicQueryErr := epicCollection.Find(ctx, bson.M{"project": bson.M{"$in": projectIds}})
+	if epicQueryErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error querying epics: "+epicQueryErr.Error())
+		return nil, false
+	}
+	if decodeErr := epicResult.All(ctx, &epics); decodeErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error decoding epics: "+decodeErr.Error())
+		return nil, false
+	}
+	projectEpics := map[primitive.ObjectID][]primitive.ObjectID{}
+	for _, epic := range epics {
+		projectEpics[epic.Project] = append(projectEpics[epic.Project], epic.Id)
+	}
+
+	// A task is unfinished while its status is not in the done category, unknown statuses count as the initial one
+	scope := []bson.M{}
+	for _, project := range projects {
+		if len(projectEpics[project.Id]) == 0 {
+			continue
+		}
+		if !unfinished {
+			scope = append(scope, bson.M{"epic": bson.M{"$in": projectEpics[project.Id]}})
+			continue
+		}
+		workflow := model.DefaultWorkflow
+		if project.Workflow != nil {
+			workflow = *project.Workflow
+		}
+		doneStatuses := []string{}
+		for _, status := range workflow.Statuses {
+			if status.Category == model.WorkflowCategoryDone {
+				doneStatuses = append(doneStatuses, status.Name)
+			}
+		}
+		scope = append(scope, bson.M{
+			"epic":   bson.M{"$in": projectEpics[project.Id]},
+			"status": bson.M{"$nin": doneStatuses},
+		})
+	}
+
+	return scope, true
+}
+
+/*
+Get the stages filtering and sorting a list of Tasks by the query of the request.
+The priority query takes a comma separated list of priorities, the dueBefore, dueAfter, startBefore and startAfter queries
+a YYYY-MM-DD date in server time or an RFC 3339 time, before bounds are excluded and after bounds included.
+The sort query takes a field, descending with a leading minus, and ties keep the creation order
+
+params: c *gin.Context Context of the request
+
+defaultSort string Field to sort by when the sort query is empty
+
+return: mongo.Pipeline The stages, to run before the lookups of the list
+
+error The error if a query is invalid
+*/
+func taskListStages(c *gin.Context, defaultSort string) (mongo.Pipeline, error) {
+	filter := bson.M{}
+
+	if c.Query("priority") != "" {
+		priorities := strings.Split(c.Query("priority"), ",")
+		for _, priority := range priorities {
+			if !slices.Contains(model.TaskPriorities, priority) {
+				return nil, errors.New("Priority must be one of " + strings.Join(model.TaskPriorities, ", "))
+			}
+		}
+		filter["priority"] = bson.M{"$in": priorities}
+	}
+
+	dateBounds := []struct{ query, field, operator string }{
+		{"dueBefore", "dueDate", "$lt"},
+		{"dueAfter", "dueDate", "$gte"},
+		{"startBefore", "startDate", "$lt"},
+		{"startAfter", "startDate", "$gte"},
+	}
+	for _, bound := range dateBounds {
+		if c.Query(bound.query) == "" {
+			continue
+		}
+		date, dateErr := parseTaskDate(c.Query(bound.query))
+		if dateErr != nil {
+			return nil, errors.New("Invalid " + bound.query + " date")
+		}
+		fieldFilter, exists := filter[bound.field].(bson.M)
+		if !exists {
+			fieldFilter = bson.M{}
+			filter[bound.field] = fieldFilter
+		}
+		fieldFilter[bound.operator] = date
+	}
+
+	sortQuery := c.DefaultQuery("sort", defaultSort)
+	order := 1
+	if strings.HasPrefix(sortQuery, "-") {
+		sortQuery = strings.TrimPrefix(sortQuery, "-")
+		order = -1
+	}
+	sortField, sortable := taskSortFields[sortQuery]
+	if !sortable {
+		return nil, errors.New("Tasks cannot be sorted by " + sortQuery)
+	}
+
+	stages := mongo.Pipeline{}
+	if len(filter) > 0 {
+		stages = append(stages, bson.D{{Key: "$match", Value: filter}})
+	}
+	if sortField == "priorityRank" {
+		// Tasks without a priority rank -1, below the lowest priority
+		stages = append(stages, bson.D{{Key: "$addFields", Value: bson.M{
+			"priorityRank": bson.M{"$indexOfArray": bson.A{model.TaskPriorities, "$priority"}},
+		}}})
+	}
+	stages = append(stages, bson.D{{Key: "$sort", Value: bson.D{
+		{Key: sortField, Value: order},
+		{Key: "_id", Value: 1},
+	}}})
+	if sortField == "priorityRank" {
+		stages = append(stages, bson.D{{Key: "$unset", Value: "priorityRank"}})
+	}
+
+	return stages, nil
+}
+
+/*
+Parse a date of a task list query
+
+params: value string A YYYY-MM-DD date, midnight in server time, or an RFC 3339 time
+
+return: time.Time The time
+
+error The error if the value is neither
+*/
+func parseTaskDate(value string) (time.Time, error) {
+	date, dateErr := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if dateErr == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+/*
+Get the update of the dates, priority and estimates of a Task, the fields not sent are kept and the ones sent as null removed
+
+params: task model.Task The task as sent by the client
+
+patch taskPlanPatch_struct The same fields as sent by the client
+
+return: bson.M The fields to set
+
+bson.M The fields to unset
+*/
+func taskPlanUpdate(task model.Task, patch taskPlanPatch_struct) (bson.M, bson.M) {
+	set := bson.M{}
+	unset := bson.M{}
+	for _, field := range taskPlanPatchFields(task, patch) {
+		if field.sent == nil {
+			continue
+		}
+		if string(field.sent) == "null" {
+			unset[field.name] = ""
+		} else {
+			set[field.name] = field.value
+		}
+	}
+
+	return set, unset
+}
+
+/*
+Apply the dates, priority and estimates sent by the client to a stored Task, to validate the result
+
+params: stored model.Task The task in DB
+
+task model.Task The task as sent by the client
+
+patch taskPlanPatch_struct The same fields as sent by the client
+
+return: model.Task The stored task with the fields sent, zero for the ones sent as null
+*/
+func mergeTaskPlan(stored, task model.Task, patch taskPlanPatch_struct) model.Task {
+	if patch.StartDate != nil {
+		stored.StartDate = task.StartDate
+	}
+	if patch.DueDate != nil {
+		stored.DueDate = task.DueDate
+	}
+	if patch.Priority != nil {
+		stored.Priority = task.Priority
+	}
+	if patch.StoryPoints != nil {
+		stored.StoryPoints = task.StoryPoints
+	}
+	if patch.EstimateHours != nil {
+		stored.EstimateHours = task.EstimateHours
+	}
+
+	return stored
+}
+
+/*
+Pair the dates, priority and estimates of a Task with the JSON sent for them
+
+params: task model.Task The task as sent by the client
+
+patch taskPlanPatch_struct The same fields as sent by the client
+
+return: []taskPlanField The fields, by BSON name
+*/
+func taskPlanPatchFields(task model.Task, patch taskPlanPatch_struct) []taskPlanField {
+	return []taskPlanField{
+		{"startDate", task.StartDate, patch.StartDate},
+		{"dueDate", task.DueDate, patch.DueDate},
+		{"priority", task.Priority, patch.Priority},
+		{"storyPoints", task.StoryPoints, patch.StoryPoints},
+		{"estimateHours", task.EstimateHours, patch.EstimateHours},
+	}
+}
+
+/*
+Create the indexes of the due date queries: the epic and due date, and the members and due date of the tasks
+
+return: error The error of the DB operation
+*/
+func EnsureTaskPlanIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	_, indexErr := taskCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "epic", Value: 1}, {Key: "dueDate", Value: 1}}, Options: options.Index().SetName("epic_dueDate")},
+		{Keys: bson.D{{Key: "members", Value: 1}, {Key: "dueDate", Value: 1}}, Options: options.Index().SetName("members_dueDate")},
+	})
+	if indexErr != nil {
+		return errors.New("[Task] Error creating the due date indexes: " + indexErr.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"backend/model"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTaskPlanUpdate(t *testing.T) {
+	dueDate := time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		body      string
+		wantSet   bson.M
+		wantUnset bson.M
+	}{
+		{
+			name:      "fields not sent are kept",
+			body:      `{"title": "Survey", "description": "Site survey"}`,
+			wantSet:   bson.M{},
+			wantUnset: bson.M{},
+		},
+		{
+			name:      "fields sent are set",
+			body:      `{"dueDate": "2026-10-30T00:00:00Z", "priority": "high", "storyPoints": 3}`,
+			wantSet:   bson.M{"dueDate": dueDate, "priority": model.TaskPriorityHigh, "storyPoints": 3.0},
+			wantUnset: bson.M{},
+		},
+		{
+			name:      "fields sent as null are removed",
+			body:      `{"startDate": null, "priority": null, "estimateHours": null}`,
+			wantSet:   bson.M{},
+			wantUnset: bson.M{"startDate": "", "priority": "", "estimateHours": ""},
+		},
+		{
+			name:      "zero values are set, not removed",
+			body:      `{"storyPoints": 0, "dueDate": null}`,
+			wantSet:   bson.M{"storyPoints": 0.0},
+			wantUnset: bson.M{"dueDate": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var task model.Task
+			var patch taskPlanPatch_struct
+			if err := json.Unmarshal([]byte(test.body), &task); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(test.body), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			set, unset := taskPlanUpdate(task, patch)
+			if !reflect.DeepEqual(set, test.wantSet) {
+				t.Errorf("set = %v, want %v", set, test.wantSet)
+			}
+			if !reflect.DeepEqual(unset, test.wantUnset) {
+				t.Errorf("unset = %v, want %v", unset, test.wantUnset)
+			}
+		})
+	}
+}
+
+func TestMergeTaskPlan(t *testing.T) {
+	startDate := time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)
+	stored := model.Task{StartDate: startDate, DueDate: startDate.AddDate(0, 0, 10), Priority: model.TaskPriorityLow}
+
+	var task model.Task
+	var patch taskPlanPatch_struct
+	body := `{"dueDate": "2026-10-19T00:00:00Z", "priority": null}`
+	_ = json.Unmarshal([]byte(body), &task)
+	_ = json.Unmarshal([]byte(body), &patch)
+
+	merged := mergeTaskPlan(stored, task, patch)
+	if !merged.StartDate.Equal(startDate) || merged.Priority != "" || merged.DueDate.Day() != 19 {
+		t.Errorf("mergeTaskPlan = %+v", merged)
+	}
+
+	// A due date before the kept start date is refused
+	if validate.StructPartial(merged, "DueDate") == nil {
+		t.Error("a due date before the stored start date passed validation")
+	}
+}
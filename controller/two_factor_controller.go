@@ -0,0 +1,932 @@
+/*
+Controller for handling data with TwoFactor and LoginChallenge models in DB
+
+1. GetTwoFactorStatus: Get the two-factor authentication status of the logged in account
+
+2. SetupTwoFactor: Generate a new TOTP secret for the logged in account
+
+3. EnableTwoFactor: Enable two-factor authentication after verifying a first code
+
+4. DisableTwoFactor: Disable two-factor authentication of the logged in account
+
+5. RegenerateRecoveryCodes: Replace the recovery codes of the logged in account
+
+6. LoginTwoFactor: Finish a login with a TOTP or recovery code
+
+7. LoginTwoFactorSetup: Generate a TOTP secret during a login that requires enrollment
+
+8. LoginTwoFactorEnable: Enable two-factor authentication and finish a login that requires enrollment
+
+9. GetLoginChallengeKind: Find which second step a login needs
+
+10. CreateLoginChallenge: Create a challenge for the second step of a login
+*/
+package controller
+
+import (
+	"backend/config"
+	"backend/model"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type twoFactor_struct struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"` // TOTP code, or recovery code where accepted
+	Password       string `json:"password"`
+}
+
+var errTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var errInvalidTwoFactorCode = errors.New("invalid code")
+
+/*
+Get the two-factor authentication status of the logged in account
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the two-factor status
+*/
+func GetTwoFactorStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		required, requiredErr := AccountRequiresTwoFactor(ctx, accountId)
+		if requiredErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying authorization: "+requiredErr.Error())
+			return
+		}
+
+		// An account without record has never set up two-factor authentication
+		var twoFactor model.TwoFactor
+		_ = twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId}).Decode(&twoFactor)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":           true,
+			"enabled":           twoFactor.Enabled,
+			"required":          required,
+			"recoveryCodesLeft": len(twoFactor.RecoveryCodeHashes),
+			"enabledAt":         twoFactor.EnabledAt,
+		})
+	}
+}
+
+/*
+Generate a new TOTP secret for the logged in account, it is used only after EnableTwoFactor
+
+params: None
+
+return: gin.HandlerFunc Handler function to set up two-factor authentication
+*/
+func SetupTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		secret, uri, setupErr := startTwoFactorSetup(ctx, accountId)
+		if setupErr == errTwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Two-factor authentication is already enabled",
+			})
+			return
+		}
+		if setupErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error setting up two-factor authentication: "+setupErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"secret":  secret,
+			"uri":     uri,
+		})
+	}
+}
+
+/*
+Enable two-factor authentication of the logged in account after verifying a first code from the new secret
+
+params: None
+
+return: gin.HandlerFunc Handler function to enable two-factor authentication
+*/
+func EnableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		recoveryCodes, enableErr := enableTwoFactor(ctx, accountId, request.Code)
+		if enableErr == errInvalidTwoFactorCode {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Invalid code",
+			})
+			return
+		}
+		if enableErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Two-factor authentication is not set up",
+			})
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditTwoFactorEnable, model.AuditOutcomeSuccess, "account", accountId.Hex(), nil)
+
+		// The recovery codes are shown only once
+		c.JSON(http.StatusOK, gin.H{
+			"success":       true,
+			"message":       "Two-factor authentication enabled",
+			"recoveryCodes": recoveryCodes,
+		})
+	}
+}
+
+/*
+Disable two-factor authentication of the logged in account, the password and a code are needed
+
+params: None
+
+return: gin.HandlerFunc Handler function to disable two-factor authentication
+*/
+func DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		// Accounts of a level that requires two-factor authentication cannot turn it off
+		required, requiredErr := AccountRequiresTwoFactor(ctx, accountId)
+		if requiredErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying authorization: "+requiredErr.Error())
+			return
+		}
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Two-factor authentication is required for your authorization level",
+			})
+			return
+		}
+
+		var account model.Account
+		accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+		if accountQueryErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying account: "+accountQueryErr.Error())
+			return
+		}
+
+		// The password and the code count like a login, a stolen session cannot guess them
+		if !AllowLoginAttempt(ctx, c, account.Username, model.AuditTwoFactorDisable) {
+			return
+		}
+		if !VerifyPassword(request.Password, account.Password) {
+			RecordLoginFailure(ctx, account.Username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditTwoFactorDisable, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"reason": "wrong_password"})
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Incorrect password",
+				"field":   "password",
+			})
+			return
+		}
+
+		var twoFactor model.TwoFactor
+		twoFactorQueryErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId, "enabled": true}).Decode(&twoFactor)
+		if twoFactorQueryErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Two-factor authentication is not enabled",
+			})
+			return
+		}
+
+		if !verifyTwoFactorCode(ctx, twoFactor, request.Code) {
+			RecordLoginFailure(ctx, account.Username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditTwoFactorDisable, model.AuditOutcomeFailure, "account", accountId.Hex(), gin.H{"reason": "invalid_two_factor_code"})
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid code",
+				"field":   "code",
+			})
+			return
+		}
+		ResetLoginFailures(ctx, account.Username, c.ClientIP())
+
+		_, deleteErr := twoFactorCollection.DeleteOne(ctx, bson.M{"_id": twoFactor.Id})
+		if deleteErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error disabling two-factor authentication: "+deleteErr.Error())
+			return
+		}
+
+		RecordAuditEvent(c, model.AuditTwoFactorDisable, model.AuditOutcomeSuccess, "account", accountId.Hex(), nil)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
+
+/*
+Replace the recovery codes of the logged in account, the old codes stop working
+
+params: None
+
+return: gin.HandlerFunc Handler function to regenerate the recovery codes
+*/
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		accountId := c.MustGet("currentAccount").(gin.H)["account_id"].(primitive.ObjectID)
+
+		var twoFactor model.TwoFactor
+		twoFactorQueryErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId, "enabled": true}).Decode(&twoFactor)
+		if twoFactorQueryErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Two-factor authentication is not enabled",
+			})
+			return
+		}
+
+		// Only a TOTP code is accepted, a recovery code cannot be used to renew the recovery codes
+		step, valid := verifyTOTPOfRecord(twoFactor, request.Code)
+		if !valid || !useTOTPStep(ctx, twoFactor.Id, step) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid code",
+				"field":   "code",
+			})
+			return
+		}
+
+		recoveryCodes, recoveryCodeHashes, generateErr := GenerateRecoveryCodes()
+		if generateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error generating recovery codes: "+generateErr.Error())
+			return
+		}
+
+		_, updateErr := twoFactorCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: twoFactor.Id}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "recovery_code_hashes", Value: recoveryCodeHashes},
+					{Key: "updatedAt", Value: time.Now().Unix()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating recovery codes: "+updateErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":       true,
+			"recoveryCodes": recoveryCodes,
+		})
+	}
+}
+
+/*
+Finish a login that is waiting for the second factor, with a TOTP code or a recovery code
+
+params: None
+
+return: gin.HandlerFunc Handler function to verify the second factor of a login
+*/
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		challenge, challengeErr := findLoginChallenge(ctx, request.ChallengeToken, model.LoginChallengeVerify)
+		if challengeErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		// Codes are throttled like passwords, a new challenge does not start the count again
+		username, usernameErr := getAccountUsername(ctx, challenge.AccountId)
+		if usernameErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying account: "+usernameErr.Error())
+			return
+		}
+		if !AllowLoginAttempt(ctx, c, username, model.AuditLogin) {
+			return
+		}
+
+		var twoFactor model.TwoFactor
+		twoFactorQueryErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": challenge.AccountId, "enabled": true}).Decode(&twoFactor)
+		if twoFactorQueryErr != nil || !verifyTwoFactorCode(ctx, twoFactor, request.Code) {
+			failLoginChallenge(ctx, challenge.Id)
+			RecordLoginFailure(ctx, username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", challenge.AccountId.Hex(), gin.H{"reason": "invalid_two_factor_code"})
+			go CheckLoginFailures(c.Copy(), challenge.AccountId)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid code",
+				"field":   "code",
+			})
+			return
+		}
+
+		completeLogin(ctx, c, challenge, nil)
+	}
+}
+
+/*
+Generate a TOTP secret during a login of an account whose authorization level requires two-factor authentication
+
+params: None
+
+return: gin.HandlerFunc Handler function to set up two-factor authentication during a login
+*/
+func LoginTwoFactorSetup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		challenge, challengeErr := findLoginChallenge(ctx, request.ChallengeToken, model.LoginChallengeSetup)
+		if challengeErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		secret, uri, setupErr := startTwoFactorSetup(ctx, challenge.AccountId)
+		if setupErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error setting up two-factor authentication: "+setupErr.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"secret":  secret,
+			"uri":     uri,
+		})
+	}
+}
+
+/*
+Enable two-factor authentication with a first code and finish a login that requires enrollment
+
+params: None
+
+return: gin.HandlerFunc Handler function to enable two-factor authentication during a login
+*/
+func LoginTwoFactorEnable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		var request twoFactor_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+
+		challenge, challengeErr := findLoginChallenge(ctx, request.ChallengeToken, model.LoginChallengeSetup)
+		if challengeErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid or expired login, please log in again",
+			})
+			return
+		}
+
+		// Codes are throttled like passwords, a new challenge does not start the count again
+		username, usernameErr := getAccountUsername(ctx, challenge.AccountId)
+		if usernameErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying account: "+usernameErr.Error())
+			return
+		}
+		if !AllowLoginAttempt(ctx, c, username, model.AuditLogin) {
+			return
+		}
+
+		recoveryCodes, enableErr := enableTwoFactor(ctx, challenge.AccountId, request.Code)
+		if enableErr == nil {
+			RecordAuditEvent(c, model.AuditTwoFactorEnable, model.AuditOutcomeSuccess, "account", challenge.AccountId.Hex(), nil)
+		}
+		if enableErr != nil {
+			failLoginChallenge(ctx, challenge.Id)
+			RecordLoginFailure(ctx, username, c.ClientIP())
+			RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeFailure, "account", challenge.AccountId.Hex(), gin.H{"reason": "invalid_two_factor_code"})
+			go CheckLoginFailures(c.Copy(), challenge.AccountId)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Invalid code",
+				"field":   "code",
+			})
+			return
+		}
+
+		completeLogin(ctx, c, challenge, recoveryCodes)
+	}
+}
+
+/*
+Find which second step a login of an account needs after the password matched
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+requireTwoFactor bool True if the authorization level of the account requires two-factor authentication
+
+return: string model.LoginChallengeVerify, model.LoginChallengeSetup, or empty if the session can be created right away
+*/
+func GetLoginChallengeKind(ctx context.Context, accountId primitive.ObjectID, requireTwoFactor bool) string {
+	var twoFactor model.TwoFactor
+	twoFactorQueryErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId, "enabled": true}).Decode(&twoFactor)
+	if twoFactorQueryErr == nil {
+		return model.LoginChallengeVerify
+	}
+
+	if requireTwoFactor {
+		return model.LoginChallengeSetup
+	}
+
+	return ""
+}
+
+/*
+Create a short lived challenge for the second step of a login
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account logging in
+
+kind string model.LoginChallengeVerify or model.LoginChallengeSetup
+
+return: string The challenge token to send to the client
+
+error The error if the challenge cannot be created
+*/
+func CreateLoginChallenge(ctx context.Context, accountId primitive.ObjectID, kind string) (string, error) {
+	token, generateErr := GenerateToken()
+	if generateErr != nil {
+		return "", generateErr
+	}
+
+	challenge := model.LoginChallenge{
+		Id:        primitive.NewObjectID(),
+		AccountId: accountId,
+		TokenHash: HashToken(token),
+		Kind:      kind,
+		ExpiresAt: time.Now().Add(loginChallengeLifetime).Unix(),
+		CreatedAt: time.Now().Unix(),
+		UpdatedAt: time.Now().Unix(),
+	}
+	_, insertErr := loginChallengeCollection.InsertOne(ctx, challenge)
+	if insertErr != nil {
+		return "", insertErr
+	}
+
+	return token, nil
+}
+
+/*
+Check if the authorization level of an account requires two-factor authentication
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: bool True if two-factor authentication is required
+
+error The error if the account or its authorization cannot be found
+*/
+func AccountRequiresTwoFactor(ctx context.Context, accountId primitive.ObjectID) (bool, error) {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return false, accountQueryErr
+	}
+
+	var authorization model.Authorization
+	authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": account.Account_Authorization_Id}).Decode(&authorization)
+	if authorizationQueryErr != nil {
+		return false, authorizationQueryErr
+	}
+
+	return authorization.RequireTwoFactor, nil
+}
+
+/*
+Create a session for the account of a login challenge and send the response of a successful login
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+challenge model.LoginChallenge The verified challenge
+
+recoveryCodes []string Recovery codes to show once to the user, nil if none were generated
+*/
+func completeLogin(ctx context.Context, c *gin.Context, challenge model.LoginChallenge, recoveryCodes []string) {
+	if !useLoginChallenge(ctx, challenge.Id) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Invalid or expired login, please log in again",
+		})
+		return
+	}
+
+	levelName, levelErr := GetAccountLevelName(ctx, challenge.AccountId)
+	if levelErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error querying authorization: "+levelErr.Error())
+		return
+	}
+
+	// Create a session and send the token cookies to client
+	sessionErr := CreateSession(ctx, c, challenge.AccountId, levelName)
+	if sessionErr != nil {
+		c.JSON(http.StatusInternalServerError, "Error creating session: "+sessionErr.Error())
+		return
+	}
+
+	// The login completed, its failed passwords and codes are forgotten
+	if username, usernameErr := getAccountUsername(ctx, challenge.AccountId); usernameErr == nil {
+		ResetLoginFailures(ctx, username, c.ClientIP())
+	}
+
+	RecordAuditEvent(c, model.AuditLogin, model.AuditOutcomeSuccess, "account", challenge.AccountId.Hex(), gin.H{"challenge": challenge.Kind})
+
+	response := gin.H{
+		"success": true,
+		"message": "Login successful",
+		"level":   levelName,
+	}
+	if recoveryCodes != nil {
+		response["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+/*
+Mark a login challenge as used in one operation, so it cannot be used twice
+
+params: ctx context.Context Context of the DB operations
+
+challengeId primitive.ObjectID ID of the challenge
+
+return: bool True if this call used the challenge, false if it was already used or cannot be updated
+*/
+func useLoginChallenge(ctx context.Context, challengeId primitive.ObjectID) bool {
+	result, updateErr := loginChallengeCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: challengeId},
+			{Key: "used", Value: false},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "used", Value: true},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+
+	return updateErr == nil && result.ModifiedCount > 0
+}
+
+/*
+Find a login challenge that can still be used
+
+params: ctx context.Context Context of the DB operations
+
+token string The challenge token from the client
+
+kind string The kind of challenge expected by the route
+
+return: model.LoginChallenge The challenge
+
+error The error if the challenge does not exist, is used, expired or has too many failed attempts
+*/
+func findLoginChallenge(ctx context.Context, token, kind string) (model.LoginChallenge, error) {
+	var challenge model.LoginChallenge
+	queryErr := loginChallengeCollection.FindOne(ctx, bson.D{
+		{Key: "token_hash", Value: HashToken(token)},
+		{Key: "kind", Value: kind},
+		{Key: "used", Value: false},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
+		{Key: "attempts", Value: bson.D{{Key: "$lt", Value: loginChallengeMaxAttempts}}},
+	}).Decode(&challenge)
+
+	return challenge, queryErr
+}
+
+/*
+Count a failed code for a login challenge, it stops working after loginChallengeMaxAttempts
+
+params: ctx context.Context Context of the DB operations
+
+challengeId primitive.ObjectID ID of the challenge
+*/
+func failLoginChallenge(ctx context.Context, challengeId primitive.ObjectID) {
+	_, _ = loginChallengeCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: challengeId}},
+		bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+			{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now().Unix()}}},
+		},
+	)
+}
+
+/*
+Generate and store a new TOTP secret for an account that has not enabled two-factor authentication
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: string The secret to show to the user
+
+string The otpauth URI of the secret
+
+error errTwoFactorEnabled if two-factor authentication is already enabled, or the error of the DB operations
+*/
+func startTwoFactorSetup(ctx context.Context, accountId primitive.ObjectID) (string, string, error) {
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return "", "", accountQueryErr
+	}
+
+	var existing model.TwoFactor
+	existingErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId}).Decode(&existing)
+	if existingErr == nil && existing.Enabled {
+		return "", "", errTwoFactorEnabled
+	}
+	if existingErr != nil && existingErr != mongo.ErrNoDocuments {
+		return "", "", existingErr
+	}
+
+	secret, generateErr := GenerateTOTPSecret()
+	if generateErr != nil {
+		return "", "", generateErr
+	}
+
+	encryptedSecret, encryptErr := encryptTOTPSecret(secret)
+	if encryptErr != nil {
+		return "", "", encryptErr
+	}
+
+	// Replace any secret that was set up but never enabled
+	_, upsertErr := twoFactorCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "account_id", Value: accountId},
+			{Key: "enabled", Value: false},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "secret", Value: encryptedSecret},
+				{Key: "recovery_code_hashes", Value: []string{}},
+				{Key: "last_used_step", Value: 0},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+			{Key: "$setOnInsert", Value: bson.D{
+				{Key: "createdAt", Value: time.Now().Unix()},
+				{Key: "enabledAt", Value: 0},
+			}},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if upsertErr != nil {
+		return "", "", upsertErr
+	}
+
+	return secret, TOTPURI(secret, account.Username), nil
+}
+
+/*
+Enable two-factor authentication of an account after verifying a code from the secret that was set up
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+code string The TOTP code entered by the user
+
+return: []string The recovery codes to show once to the user
+
+error errInvalidTwoFactorCode if the code is wrong, or the error if no secret was set up
+*/
+func enableTwoFactor(ctx context.Context, accountId primitive.ObjectID, code string) ([]string, error) {
+	var twoFactor model.TwoFactor
+	queryErr := twoFactorCollection.FindOne(ctx, bson.M{"account_id": accountId, "enabled": false}).Decode(&twoFactor)
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	step, valid := verifyTOTPOfRecord(twoFactor, code)
+	if !valid {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	recoveryCodes, recoveryCodeHashes, generateErr := GenerateRecoveryCodes()
+	if generateErr != nil {
+		return nil, generateErr
+	}
+
+	result, updateErr := twoFactorCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: twoFactor.Id},
+			{Key: "enabled", Value: false},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "enabled", Value: true},
+				{Key: "recovery_code_hashes", Value: recoveryCodeHashes},
+				{Key: "last_used_step", Value: step},
+				{Key: "enabledAt", Value: time.Now().Unix()},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+	if updateErr != nil {
+		return nil, updateErr
+	}
+	if result.ModifiedCount == 0 {
+		return nil, errTwoFactorEnabled
+	}
+
+	return recoveryCodes, nil
+}
+
+/*
+Check a TOTP code or a recovery code of an enabled two-factor record, and use it up
+
+params: ctx context.Context Context of the DB operations
+
+twoFactor model.TwoFactor The two-factor record of the account
+
+code string The code entered by the user
+
+return: bool True if the code is valid and was not used before
+*/
+func verifyTwoFactorCode(ctx context.Context, twoFactor model.TwoFactor, code string) bool {
+	if step, valid := verifyTOTPOfRecord(twoFactor, code); valid {
+		return useTOTPStep(ctx, twoFactor.Id, step)
+	}
+
+	// Remove the recovery code in the same operation that matches it, so it works only once
+	result, updateErr := twoFactorCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: twoFactor.Id},
+			{Key: "recovery_code_hashes", Value: HashRecoveryCode(code)},
+		},
+		bson.D{
+			{Key: "$pull", Value: bson.D{{Key: "recovery_code_hashes", Value: HashRecoveryCode(code)}}},
+			{Key: "$set", Value: bson.D{{Key: "updatedAt", Value: time.Now().Unix()}}},
+		},
+	)
+
+	return updateErr == nil && result.ModifiedCount == 1
+}
+
+/*
+Check a TOTP code against the secret of a two-factor record
+
+params: twoFactor model.TwoFactor The two-factor record
+
+code string The code entered by the user
+
+return: int64 The time step of the code
+
+bool True if the code is valid
+*/
+func verifyTOTPOfRecord(twoFactor model.TwoFactor, code string) (int64, bool) {
+	secret, decryptErr := decryptTOTPSecret(twoFactor.Secret)
+	if decryptErr != nil {
+		return 0, false
+	}
+
+	return VerifyTOTP(secret, code, twoFactor.LastUsedStep)
+}
+
+/*
+Store the time step of an accepted TOTP code, failing if a code of the same step was already used
+
+params: ctx context.Context Context of the DB operations
+
+twoFactorId primitive.ObjectID ID of the two-factor record
+
+step int64 The time step of the code
+
+return: bool True if the step was not used before
+*/
+func useTOTPStep(ctx context.Context, twoFactorId primitive.ObjectID, step int64) bool {
+	result, updateErr := twoFactorCollection.UpdateOne(
+		ctx,
+		bson.D{
+			{Key: "_id", Value: twoFactorId},
+			{Key: "last_used_step", Value: bson.D{{Key: "$lt", Value: step}}},
+		},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "last_used_step", Value: step},
+				{Key: "updatedAt", Value: time.Now().Unix()},
+			}},
+		},
+	)
+
+	return updateErr == nil && result.ModifiedCount == 1
+}
+
+/*
+Encrypt a TOTP secret with the primary encryption key before storing it in DB
+
+params: secret string The base32 secret
+
+return: string The encrypted secret with its key ID
+
+error The error if the encryption fails
+*/
+func encryptTOTPSecret(secret string) (string, error) {
+	return config.EncryptWithKeyring([]byte(secret))
+}
+
+/*
+Decrypt a TOTP secret stored in DB
+
+params: encryptedSecret string The encrypted secret
+
+return: string The base32 secret
+
+error The error if the decryption fails
+*/
+func decryptTOTPSecret(encryptedSecret string) (string, error) {
+	secret, decryptErr := config.DecryptWithKeyring(encryptedSecret)
+	if decryptErr != nil {
+		return "", decryptErr
+	}
+
+	return string(secret), nil
+}
@@ -47,7 +47,7 @@ func UserInforGetAll() gin.HandlerFunc {
 			}
 			userInfor = append(userInfor, singleUserInfor)
 		}
-		c.JSON(http.StatusOK, userInfor)
+		c.JSON(http.StatusOK, Redact(c, userInfor, model.UserInfor{}))
 
 	}
 }
@@ -134,7 +134,7 @@ func GetUserInforByID() gin.HandlerFunc {
 			// Send response to client
 			c.JSON(http.StatusOK, gin.H{
 				"count":      len(userInfors),
-				"user_infor": userInfors,
+				"user_infor": Redact(c, userInfors, model.UserInfor{}),
 			})
 		}
 
@@ -270,6 +270,7 @@ func GetUserInforByIDInDirect(UserInforID string) (map[string]interface{}, error
 			"address":          userInfors[0].Address,
 			"gender":           userInfors[0].Gender,
 		}
+		DecryptPIIFields(data)
 		return data, nil
 	}
 	return nil, errors.New("No Data")
@@ -499,7 +500,7 @@ func UpdateProfileImage() gin.HandlerFunc {
 			c.JSON(http.StatusOK, gin.H{
 				"message":   "Updated account successfully",
 				"state":     "success",
-				"userInfor": user_infor,
+				"userInfor": Redact(c, user_infor, model.UserInfor{}),
 			})
 		}
 
@@ -586,27 +587,28 @@ func UpdateUserInfor() gin.HandlerFunc {
 			return
 		}
 
+		// Encrypt the personal data before it reaches the database
+		piiFields, protectErr := ProtectedPIIFields(user_infor.Email, user_infor.Phone, user_infor.Address)
+		if protectErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error encrypting user infor: "+protectErr.Error())
+			return
+		}
+
 		filter := bson.D{{Key: "_id", Value: id}}
 		var update bson.D
 		if user_infor.Profile_Image == "" {
-			update = bson.D{{Key: "$set", Value: bson.D{
+			update = bson.D{{Key: "$set", Value: append(bson.D{
 				{Key: "fullname", Value: user_infor.FullName},
-				{Key: "email", Value: user_infor.Email},
-				{Key: "phone", Value: user_infor.Phone},
 				{Key: "gender", Value: user_infor.Gender},
-				{Key: "address", Value: user_infor.Address},
 				{Key: "updatedAt", Value: time.Now().Unix()},
-			}}}
+			}, piiFields...)}}
 		} else {
-			update = bson.D{{Key: "$set", Value: bson.D{
+			update = bson.D{{Key: "$set", Value: append(bson.D{
 				{Key: "fullname", Value: user_infor.FullName},
-				{Key: "email", Value: user_infor.Email},
-				{Key: "phone", Value: user_infor.Phone},
 				{Key: "gender", Value: user_infor.Gender},
-				{Key: "address", Value: user_infor.Address},
 				{Key: "profile_image", Value: user_infor.Profile_Image},
 				{Key: "updatedAt", Value: time.Now().Unix()},
-			}}}
+			}, piiFields...)}}
 		}
 
 		if err1 := userInforCollection.FindOneAndUpdate(ctx, filter, update).Decode(&user_infor); err1 != nil {
@@ -0,0 +1,659 @@
+/*
+Controller for the task Workflow of each Project
+
+1. GetProjectWorkflow: Get the statuses and transitions of a Project
+
+2. UpdateProjectWorkflow: Replace the Workflow of a Project
+
+3. TransitionTask: Move a Task to another status of its workflow
+
+4. RunWorkflowCommand: Give existing Projects and Tasks a workflow from the command line
+
+5. ProjectWorkflow: Get the Workflow of a Project
+
+6. ValidateWorkflow: Check that a Workflow is consistent
+*/
+package controller
+
+import (
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type updateWorkflow_struct struct {
+	Workflow model.Workflow    `json:"workflow"`
+	Moves    map[string]string `json:"moves"` // New status of the tasks in a removed status, by removed status
+}
+
+type transitionTask_struct struct {
+	Status string `json:"status" validate:"required"`
+}
+
+/*
+Get the statuses and transitions of a Project, with the statuses the current account may move tasks between
+
+params: None
+
+return: gin.HandlerFunc Handler function to get the workflow of a project
+*/
+func GetProjectWorkflow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid project ID: "+convertErr.Error())
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId) {
+			return
+		}
+
+		workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+		if workflowErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"workflow": workflow,
+			"role":     workflowRole(ctx, c, projectId),
+		})
+	}
+}
+
+/*
+Replace the Workflow of a Project, only leaders of the project can.
+The tasks in a status the new workflow removes must be moved with moves, or the update is refused
+
+params: None
+
+return: gin.HandlerFunc Handler function to update the workflow of a project
+*/
+func UpdateProjectWorkflow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		projectId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid project ID: "+convertErr.Error())
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader) {
+			return
+		}
+
+		var request updateWorkflow_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		if problems := ValidateWorkflow(request.Workflow); len(problems) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success":  false,
+				"message":  "Invalid workflow",
+				"problems": problems,
+			})
+			return
+		}
+
+		epicIds, epicErr := projectEpicIds(ctx, projectId)
+		if epicErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying epics: "+epicErr.Error())
+			return
+		}
+		usedStatuses, distinctErr := taskCollection.Distinct(ctx, "status", bson.M{"epic": bson.M{"$in": epicIds}})
+		if distinctErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying task statuses: "+distinctErr.Error())
+			return
+		}
+
+		// Every status still in use must exist in the new workflow or be moved to one that does
+		var unmoved []string
+		for _, usedStatus := range usedStatuses {
+			status, _ := usedStatus.(string)
+			if _, found := workflowStatus(request.Workflow, status); found {
+				continue
+			}
+			if _, found := workflowStatus(request.Workflow, request.Moves[status]); !found {
+				unmoved = append(unmoved, status)
+			}
+		}
+		if len(unmoved) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"success":  false,
+				"message":  "Tasks are in statuses the workflow removes, move them to another status",
+				"reason":   "statuses_in_use",
+				"statuses": unmoved,
+			})
+			return
+		}
+
+		updateResult, updateErr := projectCollection.UpdateOne(
+			ctx,
+			bson.D{{Key: "_id", Value: projectId}},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "workflow", Value: request.Workflow},
+					{Key: "updatedAt", Value: time.Now()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating project: "+updateErr.Error())
+			return
+		}
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Project not found",
+			})
+			return
+		}
+
+		// Move the tasks of the removed statuses
+		movedCount := int64(0)
+		for _, usedStatus := range usedStatuses {
+			status, _ := usedStatus.(string)
+			if _, found := workflowStatus(request.Workflow, status); found {
+				continue
+			}
+			moveResult, moveErr := taskCollection.UpdateMany(
+				ctx,
+				bson.D{
+					{Key: "epic", Value: bson.D{{Key: "$in", Value: epicIds}}},
+					{Key: "status", Value: usedStatus},
+				},
+				bson.D{
+					{Key: "$set", Value: bson.D{
+						{Key: "status", Value: request.Moves[status]},
+						{Key: "updatedAt", Value: time.Now()},
+					}},
+				},
+			)
+			if moveErr != nil {
+				c.JSON(http.StatusInternalServerError, "Error moving tasks: "+moveErr.Error())
+				return
+			}
+			movedCount += moveResult.ModifiedCount
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"message":    "Workflow updated",
+			"workflow":   request.Workflow,
+			"movedTasks": movedCount,
+		})
+	}
+}
+
+/*
+Move a Task to another status, only along a transition of the workflow of its project that the role of the current account may use
+
+params: None
+
+return: gin.HandlerFunc Handler function to change the status of a task
+*/
+func TransitionTask() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
+		defer cancel()
+
+		taskId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			c.JSON(http.StatusBadRequest, "Invalid task ID: "+convertErr.Error())
+			return
+		}
+
+		var request transitionTask_struct
+		bindingErr := c.BindJSON(&request)
+		if bindingErr != nil {
+			c.JSON(http.StatusBadRequest, "Request binding error: "+bindingErr.Error())
+			return
+		}
+		validationErr := validate.Struct(&request)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "The status is required",
+			})
+			return
+		}
+
+		var task model.Task
+		taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId}).Decode(&task)
+		if taskQueryErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Task not found",
+			})
+			return
+		}
+		projectId, epicErr := GetProjectOfEpic(ctx, task.Epic)
+		if epicErr != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"message": "Epic not found",
+			})
+			return
+		}
+		if !CheckProjectAccess(ctx, c, projectId, model.ProjectRoleLeader, model.ProjectRoleContributor) {
+			return
+		}
+
+		workflow, workflowErr := ProjectWorkflow(ctx, projectId)
+		if workflowErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error querying workflow: "+workflowErr.Error())
+			return
+		}
+		target, found := workflowStatus(workflow, request.Status)
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Unknown status",
+				"reason":  "unknown_status",
+			})
+			return
+		}
+
+		// A status the workflow does not know, set before the migration, counts as the initial status
+		from := task.Status
+		if _, known := workflowStatus(workflow, from); !known {
+			from = workflow.InitialStatus
+		}
+		role := workflowRole(ctx, c, projectId)
+		if !transitionAllowed(workflow, from, target.Name, role) {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The workflow does not allow moving the task from " + from + " to " + target.Name,
+				"reason":  "illegal_transition",
+				"allowed": allowedStatuses(workflow, from, role),
+			})
+			return
+		}
+
+		// Only update if nobody moved the task since it was read
+		statusFilter := interface{}(task.Status)
+		if task.Status == "" {
+			statusFilter = bson.M{"$in": bson.A{nil, ""}}
+		}
+		updateResult, updateErr := taskCollection.UpdateOne(
+			ctx,
+			bson.D{
+				{Key: "_id", Value: taskId},
+				{Key: "status", Value: statusFilter},
+			},
+			bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "status", Value: target.Name},
+					{Key: "updatedAt", Value: time.Now()},
+				}},
+			},
+		)
+		if updateErr != nil {
+			c.JSON(http.StatusInternalServerError, "Error updating task: "+updateErr.Error())
+			return
+		}
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"success": false,
+				"message": "The task was moved meanwhile, reload it",
+				"reason":  "status_changed",
+			})
+			return
+		}
+
+		// Send response to client
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"message":  "Task moved to " + target.Name,
+			"status":   target.Name,
+			"category": target.Category,
+		})
+	}
+}
+
+/*
+Give existing Projects and Tasks a workflow from the command line: workflow migrate
+
+params: args []string The arguments after "workflow"
+
+return: error The error if the command is unknown or fails
+*/
+func RunWorkflowCommand(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if len(args) == 1 && args[0] == "migrate" {
+		projectCount, taskCount, migrateErr := migrateWorkflows(ctx)
+		if migrateErr != nil {
+			return migrateErr
+		}
+		fmt.Printf("Set the default workflow on %d projects and fixed the status of %d tasks\n", projectCount, taskCount)
+		return nil
+	}
+
+	return fmt.Errorf("usage: workflow migrate")
+}
+
+/*
+Get the Workflow of a Project
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+return: model.Workflow The workflow, the default one if the project has none
+
+error The error if the project does not exist
+*/
+func ProjectWorkflow(ctx context.Context, projectId primitive.ObjectID) (model.Workflow, error) {
+	var project model.Project
+	projectQueryErr := projectCollection.FindOne(ctx, bson.M{"_id": projectId}).Decode(&project)
+	if projectQueryErr != nil {
+		return model.Workflow{}, projectQueryErr
+	}
+	if project.Workflow == nil {
+		return model.DefaultWorkflow, nil
+	}
+
+	return *project.Workflow, nil
+}
+
+/*
+Check that a Workflow is consistent: valid fields, unique status names, and an initial status and transitions between known statuses
+
+params: workflow model.Workflow The workflow
+
+return: []string The problems found, empty if the workflow is valid
+*/
+func ValidateWorkflow(workflow model.Workflow) []string {
+	var problems []string
+	validationErr := validate.Struct(&workflow)
+	if validationErr != nil {
+		problems = append(problems, validationErr.Error())
+		return problems
+	}
+
+	names := map[string]bool{}
+	for _, status := range workflow.Statuses {
+		key := strings.ToLower(strings.TrimSpace(status.Name))
+		if key == "" || names[key] {
+			problems = append(problems, "duplicate status "+status.Name)
+		}
+		names[key] = true
+	}
+	if _, found := workflowStatus(workflow, workflow.InitialStatus); !found {
+		problems = append(problems, "unknown initial status "+workflow.InitialStatus)
+	}
+
+	transitions := map[string]bool{}
+	for _, transition := range workflow.Transitions {
+		_, fromFound := workflowStatus(workflow, transition.From)
+		_, toFound := workflowStatus(workflow, transition.To)
+		if !fromFound || !toFound {
+			problems = append(problems, "transition between unknown statuses "+transition.From+" and "+transition.To)
+			continue
+		}
+		if transition.From == transition.To {
+			problems = append(problems, "transition from "+transition.From+" to itself")
+		}
+		if transitions[transition.From+"\x00"+transition.To] {
+			problems = append(problems, "duplicate transition from "+transition.From+" to "+transition.To)
+		}
+		transitions[transition.From+"\x00"+transition.To] = true
+	}
+
+	return problems
+}
+
+/*
+Find a status of a Workflow by its exact name
+
+params: workflow model.Workflow The workflow
+
+name string Name of the status
+
+return: model.WorkflowStatus The status
+
+bool True if the workflow has the status
+*/
+func workflowStatus(workflow model.Workflow, name string) (model.WorkflowStatus, bool) {
+	for _, status := range workflow.Statuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+
+	return model.WorkflowStatus{}, false
+}
+
+/*
+Get the role the workflow applies to the current account, project admins move tasks like leaders
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project
+
+return: string The role within the project
+*/
+func workflowRole(ctx context.Context, c *gin.Context, projectId primitive.ObjectID) string {
+	if middleware.HasPermission(c, model.PermissionProjectAdmin) {
+		return model.ProjectRoleLeader
+	}
+
+	return CurrentProjectRole(ctx, c, projectId)
+}
+
+/*
+Check if a role may move a task between two statuses
+
+params: workflow model.Workflow The workflow of the project
+
+from string Current status of the task
+
+to string Wanted status of the task
+
+role string Role of the account within the project
+
+return: bool True if a transition allows the move for the role
+*/
+func transitionAllowed(workflow model.Workflow, from, to, role string) bool {
+	if role != model.ProjectRoleLeader && role != model.ProjectRoleContributor {
+		return false
+	}
+	for _, transition := range workflow.Transitions {
+		if transition.From == from && transition.To == to {
+			return len(transition.Roles) == 0 || slices.Contains(transition.Roles, role)
+		}
+	}
+
+	return false
+}
+
+/*
+Get the statuses a role may move a task to from its current status
+
+params: workflow model.Workflow The workflow of the project
+
+from string Current status of the task
+
+role string Role of the account within the project
+
+return: []string Names of the statuses
+*/
+func allowedStatuses(workflow model.Workflow, from, role string) []string {
+	allowed := []string{}
+	for _, transition := range workflow.Transitions {
+		if transition.From == from && transitionAllowed(workflow, from, transition.To, role) {
+			allowed = append(allowed, transition.To)
+		}
+	}
+
+	return allowed
+}
+
+/*
+Get the IDs of the epics of a Project
+
+params: ctx context.Context Context of the DB operations
+
+projectId primitive.ObjectID ID of the project
+
+return: []primitive.ObjectID IDs of the epics
+
+error The error of the DB query
+*/
+func projectEpicIds(ctx context.Context, projectId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	epicIds := []primitive.ObjectID{}
+	result, queryErr := epicCollection.Find(ctx, bson.M{"project": projectId})
+	if queryErr != nil {
+		return nil, queryErr
+	}
+	defer result.Close(ctx)
+
+	for result.Next(ctx) {
+		var epic model.Epic
+		if decodeErr := result.Decode(&epic); decodeErr != nil {
+			return nil, decodeErr
+		}
+		epicIds = append(epicIds, epic.Id)
+	}
+
+	return epicIds, result.Err()
+}
+
+/*
+Set the default workflow on every Project without one, and give every Task a status of its project workflow.
+A free-form status matching a status or a category of the workflow, ignoring case, spaces and punctuation, becomes that status, any other the initial status
+
+params: ctx context.Context Context of the DB operations
+
+return: int The number of updated projects
+
+int The number of updated tasks
+
+error The error of the DB operations
+*/
+func migrateWorkflows(ctx context.Context) (int, int, error) {
+	projectResult, projectUpdateErr := projectCollection.UpdateMany(
+		ctx,
+		bson.M{"workflow": bson.M{"$exists": false}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "workflow", Value: model.DefaultWorkflow}}}},
+	)
+	if projectUpdateErr != nil {
+		return 0, 0, projectUpdateErr
+	}
+
+	cursor, queryErr := projectCollection.Find(ctx, bson.M{})
+	if queryErr != nil {
+		return int(projectResult.ModifiedCount), 0, queryErr
+	}
+	defer cursor.Close(ctx)
+
+	taskCount := 0
+	for cursor.Next(ctx) {
+		var project model.Project
+		if decodeErr := cursor.Decode(&project); decodeErr != nil {
+			return int(projectResult.ModifiedCount), taskCount, decodeErr
+		}
+		workflow := model.DefaultWorkflow
+		if project.Workflow != nil {
+			workflow = *project.Workflow
+		}
+
+		epicIds, epicErr := projectEpicIds(ctx, project.Id)
+		if epicErr != nil {
+			return int(projectResult.ModifiedCount), taskCount, epicErr
+		}
+		usedStatuses, distinctErr := taskCollection.Distinct(ctx, "status", bson.M{"epic": bson.M{"$in": epicIds}})
+		if distinctErr != nil {
+			return int(projectResult.ModifiedCount), taskCount, distinctErr
+		}
+		// Tasks without any status are missing from Distinct
+		usedStatuses = append(usedStatuses, nil)
+
+		for _, usedStatus := range usedStatuses {
+			status, _ := usedStatus.(string)
+			if _, found := workflowStatus(workflow, status); found {
+				continue
+			}
+			statusFilter := usedStatus
+			if usedStatus == nil {
+				statusFilter = bson.M{"$in": bson.A{nil, ""}}
+			}
+			moveResult, moveErr := taskCollection.UpdateMany(
+				ctx,
+				bson.D{
+					{Key: "epic", Value: bson.D{{Key: "$in", Value: epicIds}}},
+					{Key: "status", Value: statusFilter},
+				},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: matchWorkflowStatus(workflow, status)}}}},
+			)
+			if moveErr != nil {
+				return int(projectResult.ModifiedCount), taskCount, moveErr
+			}
+			taskCount += int(moveResult.ModifiedCount)
+		}
+	}
+
+	return int(projectResult.ModifiedCount), taskCount, cursor.Err()
+}
+
+/*
+Find the status of a Workflow closest to a free-form status
+
+params: workflow model.Workflow The workflow
+
+status string The free-form status, like "in-progress" or "DONE"
+
+return: string Name of the status of the same name or category, the initial status if none matches
+*/
+func matchWorkflowStatus(workflow model.Workflow, status string) string {
+	normalize := func(value string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(value))
+	}
+
+	key := normalize(status)
+	if key == "" {
+		return workflow.InitialStatus
+	}
+	for _, workflowStatus := range workflow.Statuses {
+		if normalize(workflowStatus.Name) == key {
+			return workflowStatus.Name
+		}
+	}
+	for _, workflowStatus := range workflow.Statuses {
+		if normalize(workflowStatus.Category) == key {
+			return workflowStatus.Name
+		}
+	}
+
+	return workflow.InitialStatus
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"backend/config"
+	"backend/controller"
 	"backend/middleware"
 	"backend/routes"
+	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	// "github.com/rs/cors"
@@ -10,31 +14,80 @@ import (
 )
 
 func main() {
+	// Every command needs the database
+	if pingErr := config.PingDB(); pingErr != nil {
+		log.Fatal(pingErr)
+	}
+
+	// Manage the keyring without starting the server: go run . keys rotate signing
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if keyErr := controller.RunKeyCommand(os.Args[2:]); keyErr != nil {
+			log.Fatal(keyErr)
+		}
+		return
+	}
+
+	// Encrypt the personal data stored before PII_ENCRYPTION was turned on: go run . pii encrypt
+	if len(os.Args) > 1 && os.Args[1] == "pii" {
+		if piiErr := controller.RunPIICommand(os.Args[2:]); piiErr != nil {
+			log.Fatal(piiErr)
+		}
+		return
+	}
+
+	// Give existing projects the default workflow and their tasks a status of it: go run . workflow migrate
+	if len(os.Args) > 1 && os.Args[1] == "workflow" {
+		if workflowErr := controller.RunWorkflowCommand(os.Args[2:]); workflowErr != nil {
+			log.Fatal(workflowErr)
+		}
+		return
+	}
+
+	// Make the leaders and task members of existing projects members of them: go run . members migrate
+	if len(os.Args) > 1 && os.Args[1] == "members" {
+		if membersErr := controller.RunMembersCommand(os.Args[2:]); membersErr != nil {
+			log.Fatal(membersErr)
+		}
+		return
+	}
+
+	// Give the authorization levels stored before permissions existed their permissions: go run . authorizations migrate [admin level]
+	if len(os.Args) > 1 && os.Args[1] == "authorizations" {
+		if authorizationErr := controller.RunAuthorizationCommand(os.Args[2:]); authorizationErr != nil {
+			log.Fatal(authorizationErr)
+		}
+		return
+	}
+
+	// Indexes the routes rely on, the server does not start without them
+	if indexErr := controller.EnsureBoardIndex(); indexErr != nil {
+		log.Fatal(indexErr)
+	}
+	if indexErr := controller.EnsureTaskPlanIndexes(); indexErr != nil {
+		log.Fatal(indexErr)
+	}
+	if indexErr := controller.EnsureLoginThrottleIndex(); indexErr != nil {
+		log.Fatal(indexErr)
+	}
+
 	// Routers
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	// Middleware
-	router.Use(middleware.CookieAuth())
-
-	// CORS settings
+	// CORS settings, first so preflight requests and rejections below carry the CORS headers
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowCredentials = true //for cookies
 	corsConfig.AllowOrigins = []string{"http://localhost:5173", "http://localhost:5173/", "http://127.0.0.1:5173", "http://127.0.0.1:5173/", "https://www.trietandfriends.site/", "https://www.trietandfriends.site"}
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "access_token"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "access_token", middleware.CSRFHeaderName}
 
 	router.Use(cors.New(corsConfig))
 
-	routes.MainRoute(router)
-	routes.TaskRoute(router)
-	routes.ProjectRoute(router)
-	routes.EpicRoute(router)
-	routes.AuthRoute(router)
-	routes.AccountRoute(router)
-	routes.UserInforRoute(router)
-	routes.EmployeeRoute(router)
-	routes.MessageRoute(router)
+	// Middleware, the CSRF check runs before the cookie is used for anything
+	router.Use(middleware.CSRFProtection())
+	router.Use(middleware.CookieAuth())
+
+	routes.Register(router)
 
 	// Old CORS settings
 	// corsOptions := cors.New(cors.Options{
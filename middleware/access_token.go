@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"backend/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Authenticate a request with a personal access token from the Authorization header
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+token string The token after "Bearer "
+*/
+func bearerAuth(ctx context.Context, c *gin.Context, token string) {
+	// Find the token by its hash
+	hashedToken := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	var accessToken model.PersonalAccessToken
+	queryErr := personalAccessTokenCollection.FindOne(ctx, bson.D{
+		{Key: "token_hash", Value: hex.EncodeToString(hashedToken[:])},
+		{Key: "revoked", Value: false},
+	}).Decode(&accessToken)
+	if queryErr != nil || (accessToken.ExpiresAt != 0 && accessToken.ExpiresAt <= time.Now().Unix()) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Unauthorized",
+		})
+		c.Abort()
+		return
+	}
+
+	account, accountErr := LoadCurrentAccount(ctx, accessToken.AccountId)
+	if accountErr != nil || account == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Unauthorized",
+		})
+		c.Abort()
+		return
+	}
+
+	// The token can only use the permissions it was given that the account still has
+	account["permissions"] = scopePermissions(GrantedPermissions(account), accessToken.Permissions)
+
+	// Guests only reach the projects shared with them
+	if !checkGuestScope(ctx, c, account) {
+		c.Abort()
+		return
+	}
+
+	// Record the use of the token
+	_, _ = personalAccessTokenCollection.UpdateOne(
+		ctx,
+		bson.D{{Key: "_id", Value: accessToken.Id}},
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "lastUsedAt", Value: time.Now().Unix()},
+				{Key: "lastUsedIP", Value: c.ClientIP()},
+			}},
+		},
+	)
+
+	// Set the current account and token in request context
+	c.Set("currentAccount", account)
+	c.Set("currentAccessToken", accessToken.Id)
+	c.Next()
+}
+
+/*
+Keep the permissions of a token that the account still has
+
+params: granted map[string]bool The permissions of the account
+
+scopes []string The permissions of the token
+
+return: primitive.A The permissions the request may use
+*/
+func scopePermissions(granted map[string]bool, scopes []string) primitive.A {
+	permissions := primitive.A{}
+	for _, scope := range scopes {
+		if scope == model.PermissionAll {
+			// A token with every permission gets the permissions of the account
+			permissions = primitive.A{}
+			for permission := range granted {
+				permissions = append(permissions, permission)
+			}
+			return permissions
+		}
+
+		if granted[model.PermissionAll] || granted[scope] {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	return permissions
+}
@@ -1,11 +1,12 @@
 package middleware
 
 import (
+	"backend/config"
+	"backend/model"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,12 +21,18 @@ func CookieAuth() gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), timeoutLimit)
 		defer cancel()
 
-		// If user is trying to login, skip the middleware
-		if c.Request.URL.Path == "/login" {
+		// If user is trying to login or refresh the token, skip the middleware
+		if publicPaths[c.Request.URL.Path] {
 			c.Next()
 			return
 		}
 
+		// Integrations authenticate with a personal access token instead of the cookie
+		if authorizationHeader := c.GetHeader("Authorization"); strings.HasPrefix(authorizationHeader, "Bearer ") {
+			bearerAuth(ctx, c, strings.TrimPrefix(authorizationHeader, "Bearer "))
+			return
+		}
+
 		// Get the token from cookie in the request header
 		encryptedToken, cookieErr := c.Cookie("access_token")
 		if cookieErr != nil {
@@ -37,9 +44,9 @@ func CookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Decode the token from base64
-		decodedToken, decodingErr := base64.StdEncoding.DecodeString(encryptedToken)
-		if decodingErr != nil {
+		// Decrypt the token with the encryption key named in it
+		decryptedToken, decryptErr := config.DecryptWithKeyring(encryptedToken)
+		if decryptErr != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"message": "Unauthorized",
@@ -48,20 +55,14 @@ func CookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Decrypt the token with encryption key
-		decryptedToken, decryptErr := Decrypt([]byte(decodedToken), []byte(os.Getenv("ENCRYPTION_KEY")))
-		if decryptErr != nil {
-			c.JSON(http.StatusInternalServerError, "Error decrypting token: "+decryptErr.Error())
-			c.Abort()
-			return
-		}
-
 		// Verify the signature of the token
 		token, parsingErr := jwt.Parse(string(decryptedToken), func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
+			// Tokens created before key IDs existed have no kid and use the legacy key
+			kid, _ := token.Header["kid"].(string)
+			return config.LookupKey(model.KeyPurposeSigning, kid)
 		})
 
 		// If verification fails
@@ -99,10 +100,9 @@ func CookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		//! Check if account level in database is the same as the level in the token
-		// Convert the account ID in token to ObjectID
-		accountId, convertErr := primitive.ObjectIDFromHex(claims["account_id"].(string))
-		if convertErr != nil {
+		// Check if the session of the token is still active
+		sessionId, sessionIdErr := primitive.ObjectIDFromHex(fmt.Sprint(claims["session_id"]))
+		if sessionIdErr != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"message": "Unauthorized",
@@ -110,81 +110,34 @@ func CookieAuth() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-
-		// Use the ObjectID to get all information about the currently logged in account
-		var account gin.H
-		pipeline := mongo.Pipeline{
+		// Record when and where the session was last seen while checking it
+		sessionResult, sessionQueryErr := sessionCollection.UpdateOne(
+			ctx,
 			bson.D{
-				{Key: "$match", Value: bson.D{
-					{Key: "account_id", Value: accountId},
-				}},
+				{Key: "_id", Value: sessionId},
+				{Key: "revoked", Value: false},
+				{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now().Unix()}}},
 			},
 			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "accounts"},
-					{Key: "localField", Value: "account_id"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "account"},
-				}},
-			},
-			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "user_infor"},
-					{Key: "localField", Value: "userinfor_id"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "userinfor"},
-				}},
-			},
-			bson.D{
-				{Key: "$lookup", Value: bson.D{
-					{Key: "from", Value: "authorizations"},
-					{Key: "localField", Value: "account.account_authorization_id"},
-					{Key: "foreignField", Value: "_id"},
-					{Key: "as", Value: "authorization"},
-				}},
-			},
-			bson.D{
-				{Key: "$project", Value: bson.D{
-					{Key: "account_id", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$account._id", 0}},
-					}},
-					{Key: "userinfor_id", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor._id", 0}},
-					}},
-					{Key: "profile_image", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.profile_image", 0}},
-					}},
-					{Key: "fullname", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.fullname", 0}},
-					}},
-					{Key: "office", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.office", 0}},
-					}},
-					{Key: "department", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.department", 0}},
-					}},
-					{Key: "position", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.position", 0}},
-					}},
-					{Key: "gender", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$userinfor.gender", 0}},
-					}},
-					{Key: "state", Value: "$state"},
-					{Key: "authorization", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$authorization.levelName", 0}},
-					}},
-					{Key: "username", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$account.username", 0}},
-					}},
-					{Key: "account_name", Value: bson.D{
-						{Key: "$arrayElemAt", Value: bson.A{"$account.account_name", 0}},
-					}},
+				{Key: "$set", Value: bson.D{
+					{Key: "lastSeenAt", Value: time.Now().Unix()},
+					{Key: "lastSeenIP", Value: c.ClientIP()},
 				}},
 			},
+		)
+		if sessionQueryErr != nil || sessionResult.MatchedCount == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Session revoked",
+			})
+			c.Abort()
+			return
 		}
-		accountQueryResult, aggregateErr := employeeCollection.Aggregate(ctx, pipeline)
-		if aggregateErr != nil {
-			fmt.Print(aggregateErr.Error())
+
+		//! Check if account level in database is the same as the level in the token
+		// Convert the account ID in token to ObjectID
+		accountId, convertErr := primitive.ObjectIDFromHex(claims["account_id"].(string))
+		if convertErr != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"success": false,
 				"message": "Unauthorized",
@@ -192,16 +145,16 @@ func CookieAuth() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if accountQueryResult.Next(ctx) {
-			decodeErr := accountQueryResult.Decode(&account)
-			if decodeErr != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"success": false,
-					"message": "Unauthorized",
-				})
-				c.Abort()
-				return
-			}
+
+		// Use the ObjectID to get all information about the currently logged in account
+		account, accountErr := LoadCurrentAccount(ctx, accountId)
+		if accountErr != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": "Unauthorized",
+			})
+			c.Abort()
+			return
 		}
 
 		// Check if the account's role in database is the same as the role in the token
@@ -214,8 +167,143 @@ func CookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		// Set the current account in request context
+		// Guests only reach the projects shared with them
+		if !checkGuestScope(ctx, c, account) {
+			c.Abort()
+			return
+		}
+
+		// Set the current account and session in request context
 		c.Set("currentAccount", account)
+		c.Set("currentSession", sessionId)
+
+		// An admin acting as the account keeps its own identity, every request it makes is recorded
+		if impersonatorId, impersonating := claims["impersonator_id"].(string); impersonating {
+			impersonator, impersonatorErr := loadImpersonator(ctx, impersonatorId)
+			if impersonatorErr != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"success": false,
+					"message": "Unauthorized",
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("impersonator", impersonator)
+			c.Next()
+			recordImpersonatedRequest(c, impersonator, account)
+			return
+		}
+
 		c.Next()
 	}
 }
+
+/*
+Get all information about an account through its employee, with the level name and permissions of its authorization
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: gin.H The account, nil if it has no employee and is not a guest
+
+error The error of the DB query
+*/
+func LoadCurrentAccount(ctx context.Context, accountId primitive.ObjectID) (gin.H, error) {
+	var account gin.H
+	pipeline := mongo.Pipeline{
+		bson.D{
+			{Key: "$match", Value: bson.D{
+				{Key: "account_id", Value: accountId},
+			}},
+		},
+		bson.D{
+			{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "accounts"},
+				{Key: "localField", Value: "account_id"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "account"},
+			}},
+		},
+		bson.D{
+			{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "user_infor"},
+				{Key: "localField", Value: "userinfor_id"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "userinfor"},
+			}},
+		},
+		bson.D{
+			{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "authorizations"},
+				{Key: "localField", Value: "account.account_authorization_id"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "authorization"},
+			}},
+		},
+		bson.D{
+			{Key: "$project", Value: bson.D{
+				{Key: "account_id", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$account._id", 0}},
+				}},
+				{Key: "userinfor_id", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor._id", 0}},
+				}},
+				{Key: "profile_image", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.profile_image", 0}},
+				}},
+				{Key: "fullname", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.fullname", 0}},
+				}},
+				{Key: "office", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.office", 0}},
+				}},
+				{Key: "department", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.department", 0}},
+				}},
+				{Key: "position", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.position", 0}},
+				}},
+				{Key: "gender", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$userinfor.gender", 0}},
+				}},
+				{Key: "state", Value: "$state"},
+				{Key: "authorization", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$authorization.levelName", 0}},
+				}},
+				{Key: "permissions", Value: bson.D{
+					{Key: "$ifNull", Value: bson.A{
+						bson.D{{Key: "$arrayElemAt", Value: bson.A{"$authorization.permissions", 0}}},
+						bson.A{},
+					}},
+				}},
+				{Key: "username", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$account.username", 0}},
+				}},
+				{Key: "account_name", Value: bson.D{
+					{Key: "$arrayElemAt", Value: bson.A{"$account.account_name", 0}},
+				}},
+			}},
+		},
+	}
+	accountQueryResult, aggregateErr := employeeCollection.Aggregate(ctx, pipeline)
+	if aggregateErr != nil {
+		return nil, aggregateErr
+	}
+	defer accountQueryResult.Close(ctx)
+
+	if accountQueryResult.Next(ctx) {
+		decodeErr := accountQueryResult.Decode(&account)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+	}
+
+	// Guests have an account but no employee
+	if account == nil {
+		return loadGuestAccount(ctx, accountId)
+	}
+
+	return account, nil
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Double-submit CSRF token, the cookie is sent by the browser and the header only by the frontend that read the token
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// Methods that must not change anything, so they need no CSRF token
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+/*
+Reject unsafe requests whose X-CSRF-Token header does not match the csrf_token cookie, a cross-site page can send the cookie but cannot read it to set the header
+
+params: None
+
+return: gin.HandlerFunc Handler function checking the CSRF token
+*/
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if safeMethods[c.Request.Method] {
+			c.Next()
+			return
+		}
+
+		// Personal access tokens are not sent by the browser on their own
+		if strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+			c.Next()
+			return
+		}
+
+		cookieToken, _ := c.Cookie(CSRFCookieName)
+		headerToken := c.GetHeader(CSRFHeaderName)
+		if cookieToken == "" || headerToken == "" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Missing CSRF token, get one from /csrf-token and send it in the " + CSRFHeaderName + " header",
+				"reason":  "csrf_missing",
+			})
+			c.Abort()
+			return
+		}
+
+		if !ValidCSRFToken(cookieToken) || subtle.ConstantTimeCompare([]byte(cookieToken), []byte(headerToken)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Invalid CSRF token",
+				"reason":  "csrf_invalid",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+Check that a CSRF token has the form of the generated ones
+
+params: token string The token from the cookie
+
+return: bool True if the token is 32 random bytes encoded as hex
+*/
+func ValidCSRFToken(token string) bool {
+	decoded, decodeErr := hex.DecodeString(token)
+	return decodeErr == nil && len(decoded) == 32
+}
@@ -0,0 +1,360 @@
+package middleware
+
+import (
+	"backend/model"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// What a project route names, to find the project a guest reaches through it
+const (
+	guestScopeProject     = "project"      // The :id parameter is a project
+	guestScopeEpic        = "epic"         // The :id parameter is an epic
+	guestScopeMessage     = "message"      // The :id parameter is a message
+	guestScopeTask        = "task"         // The :id parameter is a task
+	guestScopeBodyProject = "body_project" // The project field of the JSON body
+	guestScopeBodyEpic    = "body_epic"    // The epic field of the JSON body
+)
+
+// A project route a guest may use
+type guestRoute struct {
+	scope string
+	write bool // Needs the contributor role in the project
+}
+
+// Routes of the account itself that guests may use, by method and path
+var guestAccountRoutes = map[string]bool{
+	"GET /isAuthorized":               true,
+	"GET /profile":                    true,
+	"POST /logout":                    true,
+	"GET /sessions":                   true,
+	"DELETE /sessions/:id":            true,
+	"POST /sessions/revoke-others":    true,
+	"GET /devices":                    true,
+	"GET /two-factor":                 true,
+	"POST /two-factor/setup":          true,
+	"POST /two-factor/enable":         true,
+	"POST /two-factor/disable":        true,
+	"POST /two-factor/recovery-codes": true,
+	"POST /change-password/:id":       true,
+	"GET /password-policy":            true,
+	"POST /impersonation/stop":        true,
+	"GET /guest/projects":             true,
+}
+
+// Project, epic, task and message routes guests may use, only within the projects shared with them
+var guestProjectRoutes = map[string]guestRoute{
+	"GET /project/:id":                 {scope: guestScopeProject},
+	"GET /project/:id/members":         {scope: guestScopeProject},
+	"GET /project/:id/workflow":        {scope: guestScopeProject},
+	"GET /project/:id/board":           {scope: guestScopeProject},
+	"GET /epic/:id":                    {scope: guestScopeEpic},
+	"GET /epic-for-project/:id":        {scope: guestScopeProject},
+	"GET /get-leader-for-epic/:id":     {scope: guestScopeEpic},
+	"GET /epic/:id/board":              {scope: guestScopeEpic},
+	"GET /epic/:id/graph":              {scope: guestScopeEpic},
+	"POST /epic":                       {scope: guestScopeBodyProject, write: true},
+	"POST /task":                       {scope: guestScopeBodyEpic, write: true},
+	"GET /task/:id":                    {scope: guestScopeTask},
+	"DELETE /task/:id":                 {scope: guestScopeTask, write: true},
+	"POST /task/:id/transition":        {scope: guestScopeTask, write: true},
+	"POST /task/:id/move":              {scope: guestScopeTask, write: true},
+	"POST /task/:id/links":             {scope: guestScopeTask, write: true},
+	"DELETE /task/:id/links/:link":     {scope: guestScopeTask, write: true},
+	"GET /task/:id/graph":              {scope: guestScopeTask},
+	"POST /task/:id/checklist":         {scope: guestScopeTask, write: true},
+	"PUT /task/:id/checklist":          {scope: guestScopeTask, write: true},
+	"PUT /task/:id/checklist/:item":    {scope: guestScopeTask, write: true},
+	"DELETE /task/:id/checklist/:item": {scope: guestScopeTask, write: true},
+	"GET /get-message-by-id/:id":       {scope: guestScopeMessage},
+	"GET /get-message-by-project/:id":  {scope: guestScopeProject},
+	"POST /create-message":             {scope: guestScopeBodyProject, write: true},
+}
+
+/*
+Get all information about a guest account, with the level name of its authorization and only the permissions guests can use
+
+params: ctx context.Context Context of the DB operations
+
+accountId primitive.ObjectID ID of the account
+
+return: gin.H The account, nil if it is not a guest
+
+error The error of the DB queries
+*/
+func loadGuestAccount(ctx context.Context, accountId primitive.ObjectID) (gin.H, error) {
+	var guest model.Guest
+	guestQueryErr := guestCollection.FindOne(ctx, bson.M{"account_id": accountId}).Decode(&guest)
+	if guestQueryErr == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if guestQueryErr != nil {
+		return nil, guestQueryErr
+	}
+
+	var account model.Account
+	accountQueryErr := accountCollection.FindOne(ctx, bson.M{"_id": accountId}).Decode(&account)
+	if accountQueryErr != nil {
+		return nil, accountQueryErr
+	}
+
+	var authorization model.Authorization
+	authorizationQueryErr := authorizationCollection.FindOne(ctx, bson.M{"_id": account.Account_Authorization_Id}).Decode(&authorization)
+	if authorizationQueryErr != nil {
+		return nil, authorizationQueryErr
+	}
+
+	// A guest level granting more, even every permission, still gives the guest permissions only
+	permissions := primitive.A{}
+	for _, permission := range authorization.Permissions {
+		if slices.Contains(model.GuestPermissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	return gin.H{
+		"account_id":     account.Id,
+		"guest_id":       guest.Id,
+		"guest":          true,
+		"fullname":       guest.Name,
+		"company":        guest.Company,
+		"username":       account.Username,
+		"account_name":   account.Account_Name,
+		"authorization":  authorization.LevelName,
+		"permissions":    permissions,
+		"guest_projects": guest.Projects,
+		"expiresAt":      guest.ExpiresAt,
+	}, nil
+}
+
+/*
+Check if the current account is a guest
+
+params: c *gin.Context Context of the request
+
+return: bool True for guest accounts
+*/
+func IsGuest(c *gin.Context) bool {
+	currentAccount, exists := c.Get("currentAccount")
+	if !exists || currentAccount == nil {
+		return false
+	}
+
+	guest, _ := currentAccount.(gin.H)["guest"].(bool)
+	return guest
+}
+
+/*
+Get the role of the current guest within a project shared with it
+
+params: c *gin.Context Context of the request
+
+projectId primitive.ObjectID ID of the project
+
+return: string The role, viewer unless the project was shared as contributor, empty if it is not shared with the guest
+*/
+func GuestProjectRole(c *gin.Context, projectId primitive.ObjectID) string {
+	currentAccount, exists := c.Get("currentAccount")
+	if !exists || currentAccount == nil {
+		return ""
+	}
+
+	return guestProjectRole(currentAccount.(gin.H), projectId)
+}
+
+/*
+Get the role of a guest within a project shared with it
+
+params: account gin.H The guest account
+
+projectId primitive.ObjectID ID of the project
+
+return: string The role, viewer unless the project was shared as contributor, empty if it is not shared with the guest
+*/
+func guestProjectRole(account gin.H, projectId primitive.ObjectID) string {
+	sharedProjects, _ := account["guest_projects"].([]model.GuestProject)
+	for _, sharedProject := range sharedProjects {
+		if sharedProject.Project == projectId {
+			if sharedProject.Role == model.ProjectRoleContributor {
+				return model.ProjectRoleContributor
+			}
+			return model.ProjectRoleViewer
+		}
+	}
+
+	return ""
+}
+
+/*
+Only let a guest through to its own account routes and to the projects shared with it, before it has expired
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+account gin.H The current account
+
+return: bool True if the request may continue, the response is sent otherwise
+*/
+func checkGuestScope(ctx context.Context, c *gin.Context, account gin.H) bool {
+	if guest, _ := account["guest"].(bool); !guest {
+		return true
+	}
+
+	if expiresAt, _ := account["expiresAt"].(int64); expiresAt != 0 && expiresAt <= time.Now().Unix() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "Guest access expired",
+			"reason":  "guest_expired",
+		})
+		return false
+	}
+
+	routeKey := c.Request.Method + " " + c.FullPath()
+	if guestAccountRoutes[routeKey] {
+		return true
+	}
+
+	route, projectRoute := guestProjectRoutes[routeKey]
+	if !projectRoute {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Forbidden",
+			"reason":  "guest_scope",
+		})
+		return false
+	}
+
+	// Viewers may only read, writing needs the contributor role
+	projectId, projectErr := guestRouteProject(ctx, c, route.scope)
+	role := ""
+	if projectErr == nil {
+		role = guestProjectRole(account, projectId)
+	}
+	if role == "" || (route.write && role != model.ProjectRoleContributor) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"success": false,
+			"message": "Forbidden",
+			"reason":  "guest_scope",
+		})
+		return false
+	}
+
+	return true
+}
+
+/*
+Find the project a guest reaches through a project route
+
+params: ctx context.Context Context of the DB operations
+
+c *gin.Context Context of the request
+
+scope string What the route names, one of the guestScope constants
+
+return: primitive.ObjectID ID of the project
+
+error The error if the route does not name an existing project, epic, task or message
+*/
+func guestRouteProject(ctx context.Context, c *gin.Context, scope string) (primitive.ObjectID, error) {
+	switch scope {
+	case guestScopeProject:
+		return primitive.ObjectIDFromHex(c.Param("id"))
+	case guestScopeEpic:
+		epicId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			return primitive.NilObjectID, convertErr
+		}
+		return projectOfEpic(ctx, epicId)
+	case guestScopeMessage:
+		messageId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			return primitive.NilObjectID, convertErr
+		}
+		var message model.Message
+		messageQueryErr := messageCollection.FindOne(ctx, bson.M{"_id": messageId}).Decode(&message)
+		return message.Project, messageQueryErr
+	case guestScopeTask:
+		taskId, convertErr := primitive.ObjectIDFromHex(c.Param("id"))
+		if convertErr != nil {
+			return primitive.NilObjectID, convertErr
+		}
+		var task model.Task
+		taskQueryErr := taskCollection.FindOne(ctx, bson.M{"_id": taskId}).Decode(&task)
+		if taskQueryErr != nil {
+			return primitive.NilObjectID, taskQueryErr
+		}
+		return projectOfEpic(ctx, task.Epic)
+	case guestScopeBodyProject:
+		return bodyObjectId(c, "project")
+	case guestScopeBodyEpic:
+		epicId, bodyErr := bodyObjectId(c, "epic")
+		if bodyErr != nil {
+			return primitive.NilObjectID, bodyErr
+		}
+		return projectOfEpic(ctx, epicId)
+	}
+
+	return primitive.NilObjectID, errors.New("unknown guest route scope")
+}
+
+/*
+Get the project that owns an epic
+
+params: ctx context.Context Context of the DB operations
+
+epicId primitive.ObjectID ID of the epic
+
+return: primitive.ObjectID ID of the project
+
+error The error if the epic does not exist
+*/
+func projectOfEpic(ctx context.Context, epicId primitive.ObjectID) (primitive.ObjectID, error) {
+	var epic model.Epic
+	epicQueryErr := epicCollection.FindOne(ctx, bson.M{"_id": epicId}).Decode(&epic)
+	return epic.Project, epicQueryErr
+}
+
+/*
+Read an ID field of the JSON body, leaving the body for the handler to bind
+
+params: c *gin.Context Context of the request
+
+field string Name of the field, compared ignoring case like the JSON binding does
+
+return: primitive.ObjectID The ID
+
+error The error if the body cannot be read or the field is not an ID
+*/
+func bodyObjectId(c *gin.Context, field string) (primitive.ObjectID, error) {
+	body, readErr := io.ReadAll(c.Request.Body)
+	if readErr != nil {
+		return primitive.NilObjectID, readErr
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var document map[string]interface{}
+	decodeErr := json.Unmarshal(body, &document)
+	if decodeErr != nil {
+		return primitive.NilObjectID, decodeErr
+	}
+	for key, value := range document {
+		if id, isString := value.(string); isString && strings.EqualFold(key, field) {
+			return primitive.ObjectIDFromHex(id)
+		}
+	}
+
+	return primitive.NilObjectID, errors.New("missing " + field)
+}
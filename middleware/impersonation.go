@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"backend/model"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Reject the request if an admin is impersonating the current account, for actions only the real owner may take
+
+params: None
+
+return: gin.HandlerFunc Handler function checking the impersonation, must run after CookieAuth
+*/
+func ForbidImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonating := c.Get("impersonator"); impersonating {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Not allowed while impersonating",
+				"reason":  "impersonating",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+Load the admin account named in the impersonator_id claim, it must still be allowed to impersonate
+
+params: ctx context.Context Context of the DB operations
+
+impersonatorId string Hex ID of the admin account
+
+return: gin.H The admin account
+
+error The error if the account does not exist or lost the permission
+*/
+func loadImpersonator(ctx context.Context, impersonatorId string) (gin.H, error) {
+	accountId, convertErr := primitive.ObjectIDFromHex(impersonatorId)
+	if convertErr != nil {
+		return nil, convertErr
+	}
+
+	impersonator, accountErr := LoadCurrentAccount(ctx, accountId)
+	if accountErr != nil {
+		return nil, accountErr
+	}
+	if impersonator == nil {
+		return nil, errors.New("impersonator not found")
+	}
+
+	granted := GrantedPermissions(impersonator)
+	if !granted[model.PermissionAll] && !granted[model.PermissionAccountImpersonate] {
+		return nil, errors.New("impersonation not permitted")
+	}
+
+	return impersonator, nil
+}
+
+/*
+Record in the audit log a request made while impersonating, after it was handled
+
+params: c *gin.Context Context of the handled request
+
+impersonator gin.H The admin account
+
+account gin.H The impersonated account
+*/
+func recordImpersonatedRequest(c *gin.Context, impersonator, account gin.H) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	outcome := model.AuditOutcomeSuccess
+	if c.Writer.Status() >= http.StatusBadRequest {
+		outcome = model.AuditOutcomeFailure
+	}
+
+	event := model.AuditEvent{
+		Id:         primitive.NewObjectID(),
+		Action:     model.AuditImpersonatedRequest,
+		Outcome:    outcome,
+		TargetType: "request",
+		TargetId:   c.Request.Method + " " + c.Request.URL.Path,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		Details: map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"status": c.Writer.Status(),
+		},
+		CreatedAt: time.Now().Unix(),
+	}
+	event.ActorAccountId, _ = account["account_id"].(primitive.ObjectID)
+	event.ActorUsername, _ = account["username"].(string)
+	event.ImpersonatorId, _ = impersonator["account_id"].(primitive.ObjectID)
+	event.Impersonator, _ = impersonator["username"].(string)
+
+	_, insertErr := auditEventCollection.InsertOne(ctx, event)
+	if insertErr != nil {
+		log.Println("[AUDIT] Error recording impersonated request: " + insertErr.Error())
+	}
+}
@@ -2,42 +2,38 @@ package middleware
 
 import (
 	"backend/config"
-	"crypto/aes"
-	"crypto/cipher"
-	"fmt"
 	"time"
 )
 
 var timeoutLimit = 30 * time.Minute
+var accountCollection = config.GetCollection(config.ConnectDB(), "accounts")
+var auditEventCollection = config.GetCollection(config.ConnectDB(), "audit_events")
+var authorizationCollection = config.GetCollection(config.ConnectDB(), "authorizations")
 var employeeCollection = config.GetCollection(config.ConnectDB(), "employee")
-
-/*
-Decrypt the token from the client
-
-params: ciphertext []byte The encrypted token
-
-key []byte The key to decrypt the token
-
-return: []byte The decrypted token
-
-error The error if the decryption fails
-*/
-func Decrypt(ciphertext, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonceSize := aesGCM.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
-	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return aesGCM.Open(nil, nonce, ciphertext, nil)
+var epicCollection = config.GetCollection(config.ConnectDB(), "epics")
+var guestCollection = config.GetCollection(config.ConnectDB(), "guests")
+var messageCollection = config.GetCollection(config.ConnectDB(), "messages")
+var personalAccessTokenCollection = config.GetCollection(config.ConnectDB(), "personal_access_tokens")
+var sessionCollection = config.GetCollection(config.ConnectDB(), "sessions")
+var taskCollection = config.GetCollection(config.ConnectDB(), "tasks")
+
+// Paths that are reachable without an access token
+var publicPaths = map[string]bool{
+	"/login":                   true,
+	"/login/two-factor":        true,
+	"/login/two-factor/setup":  true,
+	"/login/two-factor/enable": true,
+	"/login/change-password":   true,
+	"/token/refresh":           true,
+	"/csrf-token":              true,
+
+	"/forgot-password":         true,
+	"/forgot-password/confirm": true,
+	"/invitation/accept":       true,
+	"/login-alert/confirm":     true,
+	"/login-alert/flag":        true,
+	"/magic-link":              true,
+	"/magic-link/confirm":      true,
+	"/sso/login":               true,
+	"/sso/callback":            true,
 }
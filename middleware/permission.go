@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"backend/model"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Only let the request through if the authorization level of the current account has every specified permission
+
+params: permissions ...string The permissions required by the route
+
+return: gin.HandlerFunc Handler function checking the permissions, must run after CookieAuth
+*/
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currentAccount, exists := c.Get("currentAccount")
+		if !exists || currentAccount == nil {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "Forbidden",
+				"reason":  "no_account",
+			})
+			c.Abort()
+			return
+		}
+
+		// Collect the permissions the account is missing
+		granted := GrantedPermissions(currentAccount.(gin.H))
+		var missing []string
+		for _, permission := range permissions {
+			if !granted[model.PermissionAll] && !granted[permission] {
+				missing = append(missing, permission)
+			}
+		}
+
+		if len(missing) > 0 {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success":     false,
+				"message":     "Forbidden",
+				"reason":      "missing_permission",
+				"permissions": missing,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+Get the set of permissions of the current account, as loaded by CookieAuth
+
+params: currentAccount gin.H The current account in request context
+
+return: map[string]bool The permissions granted to the account
+*/
+func GrantedPermissions(currentAccount gin.H) map[string]bool {
+	granted := make(map[string]bool)
+	if permissions, ok := currentAccount["permissions"].(primitive.A); ok {
+		for _, permission := range permissions {
+			if permissionString, ok := permission.(string); ok {
+				granted[permissionString] = true
+			}
+		}
+	}
+
+	return granted
+}
+
+/*
+Check if the current account has a permission
+
+params: c *gin.Context Context of the request
+
+permission string The permission to check
+
+return: bool True if the permission is granted
+*/
+func HasPermission(c *gin.Context, permission string) bool {
+	currentAccount, exists := c.Get("currentAccount")
+	if !exists || currentAccount == nil {
+		return false
+	}
+
+	granted := GrantedPermissions(currentAccount.(gin.H))
+	return granted[model.PermissionAll] || granted[permission]
+}
@@ -5,11 +5,13 @@ import (
 )
 
 type Account struct {
-	Id                       primitive.ObjectID `bson:"_id"`
+	Id                       primitive.ObjectID `bson:"_id" owner:"account"`
 	Username                 string             `bson:"username" json:"username"`
-	Password                 string             `bson:"password" json:"password"`
+	Password                 string             `bson:"password" json:"password" visibility:"secret"` // Bound from login requests, never sent back
 	Account_Name             string             `bson:"account_name"`
 	Account_Authorization_Id primitive.ObjectID `bson:"account_authorization_id"`
+	PasswordHistory          []string           `bson:"password_history" json:"-"`            // Hashes of the previous passwords, newest last
+	PasswordChangedAt        int64              `bson:"passwordChangedAt" visibility:"owner"` // 0 if never changed, the creation time counts then
 	CreatedAt                int64              `bson:"createdAt"`
 	UpdatedAt                int64              `bson:"updatedAt"`
 }
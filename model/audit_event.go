@@ -0,0 +1,73 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outcomes of an audited action
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditLogin                = "auth.login"
+	AuditLogout               = "auth.logout"
+	AuditPasswordChange       = "auth.password_change"
+	AuditPasswordResetRequest = "auth.password_reset_request"
+	AuditPasswordResetConfirm = "auth.password_reset_confirm"
+	AuditMagicLinkRequest     = "auth.magic_link_request"
+	AuditLoginAlert           = "auth.login_alert" // A login from a new device or after failed attempts was emailed
+	AuditLoginAlertConfirm    = "auth.login_alert_confirm"
+	AuditLoginAlertFlag       = "auth.login_alert_flag"
+	AuditInvitationAccept     = "auth.invitation_accept"
+	AuditSSOLink              = "auth.sso_link" // A user of the identity provider linked to an account
+	AuditTwoFactorEnable      = "auth.two_factor_enable"
+	AuditTwoFactorDisable     = "auth.two_factor_disable"
+	AuditSessionRevoke        = "session.revoke"
+	AuditSessionRevokeOthers  = "session.revoke_others"
+	AuditAccessTokenCreate    = "access_token.create"
+	AuditAccessTokenRevoke    = "access_token.revoke"
+	AuditAccountCreate        = "account.create"
+	AuditAccountUpdate        = "account.update"
+	AuditAccountDelete        = "account.delete"
+	AuditAccountDeleteAll     = "account.delete_all"
+	AuditAccountPasswordReset = "account.password_reset"
+	AuditPasswordPolicyUpdate = "password_policy.update"
+	AuditLoginUnlock          = "account.login_unlock"
+	AuditAccountSessionRevoke = "account.session_revoke"
+	AuditEmployeeCreate       = "employee.create"
+	AuditInvitationResend     = "invitation.resend"
+	AuditInvitationRevoke     = "invitation.revoke"
+	AuditGuestCreate          = "guest.create"
+	AuditGuestUpdate          = "guest.update"
+	AuditGuestDelete          = "guest.delete"
+	AuditGuestShare           = "guest.share" // A project was shared with a guest, or its role changed
+	AuditGuestUnshare         = "guest.unshare"
+	AuditAuthorizationCreate  = "authorization.create"
+	AuditAuthorizationUpdate  = "authorization.update"
+	AuditAuthorizationDelete  = "authorization.delete"
+	AuditKeyRotate            = "key.rotate"
+	AuditKeyRetire            = "key.retire"
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationStop    = "impersonation.stop"
+	AuditImpersonatedRequest  = "impersonation.request" // Any request made while impersonating
+)
+
+// Audit events are only ever inserted, never updated or deleted
+type AuditEvent struct {
+	Id             primitive.ObjectID     `bson:"_id,omitempty"`
+	Action         string                 `bson:"action"`
+	Outcome        string                 `bson:"outcome"`
+	ActorAccountId primitive.ObjectID     `bson:"actor_account_id,omitempty"` // Empty when nobody is logged in, like a failed login
+	ActorUsername  string                 `bson:"actor_username"`
+	ImpersonatorId primitive.ObjectID     `bson:"impersonator_account_id,omitempty"` // The admin acting as the actor
+	Impersonator   string                 `bson:"impersonator_username,omitempty"`
+	TargetType     string                 `bson:"target_type"`
+	TargetId       string                 `bson:"target_id"`
+	IP             string                 `bson:"ip"`
+	UserAgent      string                 `bson:"user_agent"`
+	Details        map[string]interface{} `bson:"details,omitempty"`
+	CreatedAt      int64                  `bson:"createdAt"`
+}
@@ -5,9 +5,79 @@ import (
 )
 
 type Authorization struct {
-	Id          primitive.ObjectID `bson:"_id,omitempty"`
-	LevelName   string             `bson:"levelName,omitempty" validate:"required"`
-	Description string             `bson:"description"`
-	CreatedAt   int64              `bson:"createdAt"`
-	UpdatedAt   int64              `bson:"updatedAt"`
+	Id               primitive.ObjectID `bson:"_id,omitempty"`
+	LevelName        string             `bson:"levelName,omitempty" validate:"required"`
+	Description      string             `bson:"description"`
+	Permissions      []string           `bson:"permissions"`
+	RequireTwoFactor bool               `bson:"require_two_factor" json:"require_two_factor"` // Accounts of this level must enroll two-factor authentication to log in
+	AllowMagicLink   bool               `bson:"allow_magic_link" json:"allow_magic_link"`     // Accounts of this level may log in with an emailed link instead of the password
+	CreatedAt        int64              `bson:"createdAt"`
+	UpdatedAt        int64              `bson:"updatedAt"`
+}
+
+// Permissions that can be granted to an authorization level
+const (
+	PermissionAll                = "*" // Grants every permission
+	PermissionAccountAdmin       = "account:admin"
+	PermissionAccountImpersonate = "account:impersonate" // Act as another account that has no more permissions
+	PermissionAuthorizationRead  = "authorization:read"
+	PermissionAuthorizationAdmin = "authorization:admin"
+	PermissionEmployeeRead       = "employee:read"
+	PermissionEmployeeWrite      = "employee:write"
+	PermissionEmployeeAdmin      = "employee:admin"
+	PermissionUserInforRead      = "userinfor:read"
+	PermissionUserInforWrite     = "userinfor:write"
+	PermissionProjectRead        = "project:read"
+	PermissionProjectWrite       = "project:write"
+	PermissionProjectAdmin       = "project:admin" // Access every project without being a member
+	PermissionEpicRead           = "epic:read"
+	PermissionEpicWrite          = "epic:write"
+	PermissionTaskRead           = "task:read"
+	PermissionTaskWrite          = "task:write"
+	PermissionMessageRead        = "message:read"
+	PermissionMessageWrite       = "message:write"
+	PermissionFileUpload         = "file:upload"
+	PermissionKeyAdmin           = "key:admin" // Rotate and retire the signing and encryption keys
+	PermissionAuditRead          = "audit:read"
+)
+
+var AllPermissions = []string{
+	PermissionAll,
+	PermissionAccountAdmin,
+	PermissionAccountImpersonate,
+	PermissionAuthorizationRead,
+	PermissionAuthorizationAdmin,
+	PermissionEmployeeRead,
+	PermissionEmployeeWrite,
+	PermissionEmployeeAdmin,
+	PermissionUserInforRead,
+	PermissionUserInforWrite,
+	PermissionProjectRead,
+	PermissionProjectWrite,
+	PermissionProjectAdmin,
+	PermissionEpicRead,
+	PermissionEpicWrite,
+	PermissionTaskRead,
+	PermissionTaskWrite,
+	PermissionMessageRead,
+	PermissionMessageWrite,
+	PermissionFileUpload,
+	PermissionKeyAdmin,
+	PermissionAuditRead,
+}
+
+// Permissions the "authorizations migrate" command grants to the levels stored before they had permissions
+var DefaultPermissions = []string{
+	PermissionEmployeeRead,
+	PermissionUserInforRead,
+	PermissionUserInforWrite,
+	PermissionProjectRead,
+	PermissionProjectWrite,
+	PermissionEpicRead,
+	PermissionEpicWrite,
+	PermissionTaskRead,
+	PermissionTaskWrite,
+	PermissionMessageRead,
+	PermissionMessageWrite,
+	PermissionFileUpload,
 }
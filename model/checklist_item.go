@@ -0,0 +1,18 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A step of a task, kept in the task
+type ChecklistItem struct {
+	Id        primitive.ObjectID `json:"_id" bson:"_id"`
+	Text      string             `json:"text" bson:"text" validate:"required,max=500"`
+	Done      bool               `json:"done" bson:"done"`
+	Assignee  primitive.ObjectID `json:"assignee,omitempty" bson:"assignee,omitempty"` // An employee member of the project
+	Order     int                `json:"order" bson:"order"`
+	CreatedAt int64              `json:"createdAt" bson:"createdAt"`
+	UpdatedAt int64              `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Task ->> [ChecklistItem] ->> Employee
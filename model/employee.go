@@ -4,11 +4,17 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Employee states set by the system
+const (
+	EmployeeStatePending = -1 // Account created, invitation not accepted yet
+	EmployeeStateActive  = 0
+)
+
 type Employee struct {
-	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	Id          primitive.ObjectID `bson:"_id,omitempty" owner:"employee"`
 	State       int                `bson:"state"`
-	AccountID   primitive.ObjectID `bson:"account_id"`
-	UserInforId primitive.ObjectID `bson:"userinfor_id"`
+	AccountID   primitive.ObjectID `bson:"account_id" owner:"account"`
+	UserInforId primitive.ObjectID `bson:"userinfor_id" owner:"userinfor"`
 	CreatedAt   int64              `bson:"createdAt"`
 	UpdatedAt   int64              `bson:"updatedAt"`
 }
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permissions a guest can use, whatever its authorization level grants
+var GuestPermissions = []string{
+	PermissionProjectRead,
+	PermissionEpicRead,
+	PermissionEpicWrite,
+	PermissionTaskRead,
+	PermissionTaskWrite,
+	PermissionMessageRead,
+	PermissionMessageWrite,
+}
+
+// A project shared with a guest, guests never lead a project
+type GuestProject struct {
+	Project  primitive.ObjectID `bson:"project" json:"project" validate:"required"`
+	Role     string             `bson:"role" json:"role" validate:"omitempty,oneof=viewer contributor"` // Viewer when empty, guests are read-only by default
+	SharedBy primitive.ObjectID `bson:"sharedBy" json:"sharedBy"`
+	SharedAt int64              `bson:"sharedAt" json:"sharedAt"`
+}
+
+// An external collaborator, like a client or contractor, with an account but no employee or user information
+type Guest struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountId primitive.ObjectID `bson:"account_id" json:"account_id" owner:"account"`
+	Name      string             `bson:"name" json:"name" validate:"required"`
+	Email     string             `bson:"email" json:"email" validate:"required,email" visibility:"owner"`
+	Company   string             `bson:"company" json:"company"`
+	Projects  []GuestProject     `bson:"projects" json:"projects"`
+	ExpiresAt int64              `bson:"expiresAt" json:"expiresAt"` // 0 if the access does not expire
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt int64              `bson:"createdAt" json:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Account ->> Guest ->> [Project]
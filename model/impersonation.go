@@ -0,0 +1,20 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// An admin acting as another account, the impersonated account can list these
+type Impersonation struct {
+	Id                    primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId             primitive.ObjectID `bson:"account_id"` // The impersonated account
+	Username              string             `bson:"username"`
+	ImpersonatorAccountId primitive.ObjectID `bson:"impersonator_account_id"`
+	ImpersonatorUsername  string             `bson:"impersonator_username"`
+	ImpersonatorSessionId primitive.ObjectID `bson:"impersonator_session_id" json:"-"` // Session of the admin to return to
+	SessionId             primitive.ObjectID `bson:"session_id"`
+	Reason                string             `bson:"reason" validate:"required,max=500"`
+	StartedAt             int64              `bson:"startedAt"`
+	ExpiresAt             int64              `bson:"expiresAt"`
+	EndedAt               int64              `bson:"endedAt"` // 0 until stopped, the session ends at expiresAt otherwise
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Statuses of an invitation
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+)
+
+type Invitation struct {
+	Id         primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	AccountId  primitive.ObjectID `json:"account_id" bson:"account_id"`
+	EmployeeId primitive.ObjectID `json:"employee_id" bson:"employee_id"`
+	Email      string             `json:"email" bson:"email"`
+	Name       string             `json:"name" bson:"name"`
+	TokenHash  string             `json:"-" bson:"token_hash"` // Only the hash is stored, the token itself is in the emailed link
+	Status     string             `json:"status" bson:"status"`
+	InvitedBy  primitive.ObjectID `json:"invited_by" bson:"invited_by"`
+	ExpiresAt  int64              `json:"expiresAt" bson:"expiresAt"`
+	AcceptedAt int64              `json:"acceptedAt" bson:"acceptedAt"`
+	CreatedAt  int64              `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  int64              `json:"updatedAt" bson:"updatedAt"`
+}
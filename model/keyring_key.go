@@ -0,0 +1,32 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Purposes of the keys in the keyring
+const (
+	KeyPurposeSigning    = "signing"    // Signs the JWT access tokens
+	KeyPurposeEncryption = "encryption" // Encrypts the access token cookies and secrets stored in DB
+)
+
+// States of a key, only the primary key is used for new tokens
+const (
+	KeyStatusPrimary = "primary"
+	KeyStatusActive  = "active"  // Still accepted to verify and decrypt
+	KeyStatusRetired = "retired" // Not accepted anymore
+)
+
+// Key ID of the keys read from JWT_SECRET and ENCRYPTION_KEY, used by tokens created before key IDs existed
+const LegacyKeyId = "env"
+
+type KeyringKey struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	Kid       string             `bson:"kid"`
+	Purpose   string             `bson:"purpose"`
+	Secret    string             `bson:"secret" json:"-"` // Encrypted with KEYRING_MASTER_KEY
+	Status    string             `bson:"status"`
+	RetiredAt int64              `bson:"retiredAt"`
+	CreatedAt int64              `bson:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt"`
+}
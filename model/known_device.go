@@ -0,0 +1,19 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A device an account logged in from, recognized by its user agent and IP prefix
+type KnownDevice struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId   primitive.ObjectID `bson:"account_id" owner:"account"`
+	Fingerprint string             `bson:"fingerprint" json:"-"` // Hash of the user agent and IP prefix
+	UserAgent   string             `bson:"userAgent"`
+	IPPrefix    string             `bson:"ipPrefix" visibility:"owner"` // The /24 of an IPv4 or the /48 of an IPv6
+	Confirmed   bool               `bson:"confirmed"`                   // The owner confirmed the login alert of this device
+	FirstSeenAt int64              `bson:"firstSeenAt"`
+	LastSeenAt  int64              `bson:"lastSeenAt"`
+	CreatedAt   int64              `bson:"createdAt"`
+	UpdatedAt   int64              `bson:"updatedAt"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions recorded in the lockout events
+const (
+	LockoutEventLocked   = "locked"
+	LockoutEventUnlocked = "unlocked"
+)
+
+type LockoutEvent struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	Action      string             `bson:"action"`
+	Kind        string             `bson:"kind"`
+	Value       string             `bson:"value"`
+	ClientIP    string             `bson:"client_ip"` // IP of the failed attempt that caused the lockout
+	LockedUntil int64              `bson:"lockedUntil"`
+	By          primitive.ObjectID `bson:"by,omitempty"` // Employee who unlocked
+	CreatedAt   int64              `bson:"createdAt"`
+}
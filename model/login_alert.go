@@ -0,0 +1,36 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Why a login was reported to the owner of the account
+const (
+	LoginAlertNewDevice      = "new_device" // The device is not confirmed yet
+	LoginAlertFailedAttempts = "failed_attempts"
+)
+
+// What the owner answered to a login alert
+const (
+	LoginAlertPending   = "pending"
+	LoginAlertConfirmed = "confirmed"
+	LoginAlertFlagged   = "flagged" // The session of the login was revoked
+)
+
+// A login reported by email, the emailed token lets the owner confirm or flag it without logging in
+type LoginAlert struct {
+	Id             primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId      primitive.ObjectID `bson:"account_id" owner:"account"`
+	DeviceId       primitive.ObjectID `bson:"device_id"`  // Nil for failed logins
+	SessionId      primitive.ObjectID `bson:"session_id"` // Nil for failed logins
+	Reasons        []string           `bson:"reasons"`
+	FailedAttempts int64              `bson:"failedAttempts"` // Failed logins of the account before this one
+	UserAgent      string             `bson:"userAgent"`
+	IP             string             `bson:"ip" visibility:"owner"`
+	TokenHash      string             `bson:"token_hash" json:"-"`
+	Status         string             `bson:"status"`
+	ResolvedAt     int64              `bson:"resolvedAt"`
+	ExpiresAt      int64              `bson:"expiresAt"`
+	CreatedAt      int64              `bson:"createdAt"`
+	UpdatedAt      int64              `bson:"updatedAt"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of second step that a login challenge waits for
+const (
+	LoginChallengeVerify = "verify" // The account has two-factor authentication, a code is needed
+	LoginChallengeSetup  = "setup"  // The authorization level requires two-factor authentication, it must be enrolled first
+
+	LoginChallengePasswordChange = "password_change" // The password expired, a new one must be set
+)
+
+type LoginChallenge struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId primitive.ObjectID `bson:"account_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Kind      string             `bson:"kind"`
+	Attempts  int                `bson:"attempts"`
+	Used      bool               `bson:"used"`
+	ExpiresAt int64              `bson:"expiresAt"`
+	CreatedAt int64              `bson:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt"`
+}
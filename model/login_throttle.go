@@ -0,0 +1,24 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// What a login throttle counts the failed attempts of
+const (
+	LoginThrottleUsername = "username"
+	LoginThrottleIP       = "ip"
+)
+
+type LoginThrottle struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty"`
+	Kind          string             `bson:"kind"`
+	Value         string             `bson:"value"` // The username or client IP
+	Failures      int                `bson:"failures"`
+	Lockouts      int                `bson:"lockouts"` // Number of lockouts since the last successful login, each one lasts twice as long
+	NextAttemptAt int64              `bson:"nextAttemptAt"`
+	LockedUntil   int64              `bson:"lockedUntil"`
+	LastFailureAt int64              `bson:"lastFailureAt"`
+	CreatedAt     int64              `bson:"createdAt"`
+	UpdatedAt     int64              `bson:"updatedAt"`
+}
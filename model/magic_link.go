@@ -0,0 +1,16 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A single use login link, the emailed token is signed and names this record
+type MagicLink struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId primitive.ObjectID `bson:"account_id"`
+	Used      bool               `bson:"used"`
+	UsedAt    int64              `bson:"usedAt"`
+	ExpiresAt int64              `bson:"expiresAt"`
+	CreatedAt int64              `bson:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt"`
+}
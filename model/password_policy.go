@@ -0,0 +1,28 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Password rules of every account, a single document edited by admins
+type PasswordPolicy struct {
+	Id              primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	MinLength       int                `bson:"min_length" json:"min_length" validate:"min=1,max=128"`
+	RequireUpper    bool               `bson:"require_upper" json:"require_upper"`
+	RequireLower    bool               `bson:"require_lower" json:"require_lower"`
+	RequireNumber   bool               `bson:"require_number" json:"require_number"`
+	RequireSpecial  bool               `bson:"require_special" json:"require_special"`
+	BannedPasswords []string           `bson:"banned_passwords" json:"banned_passwords"`                   // Compared ignoring case
+	HistoryCount    int                `bson:"history_count" json:"history_count" validate:"min=0,max=12"` // Previous passwords that cannot be used again, 0 to allow reuse
+	MaxAgeDays      int                `bson:"max_age_days" json:"max_age_days" validate:"min=0,max=3650"` // 0 if passwords do not expire
+	UpdatedBy       primitive.ObjectID `bson:"updatedBy" json:"updatedBy"`
+	UpdatedAt       int64              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Passwords banned until an admin saves its own list
+var DefaultBannedPasswords = []string{
+	"123456", "12345678", "123456789", "1234567890", "password", "password1", "password123",
+	"qwerty", "qwerty123", "abc123", "111111", "123123", "000000", "iloveyou", "admin",
+	"admin123", "welcome", "welcome1", "letmein", "monkey", "dragon", "football", "baseball",
+	"sunshine", "princess", "passw0rd", "p@ssw0rd", "p@ssword", "changeme", "secret",
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PasswordReset struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId primitive.ObjectID `bson:"account_id"`
+	TokenHash string             `bson:"token_hash"` // Only the hash is stored, the token itself is in the emailed link
+	Used      bool               `bson:"used"`
+	UsedAt    int64              `bson:"usedAt"`
+	ExpiresAt int64              `bson:"expiresAt"`
+	CreatedAt int64              `bson:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt"`
+}
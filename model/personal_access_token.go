@@ -0,0 +1,24 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prefix of personal access tokens, so they are recognizable in logs and secret scanners
+const PersonalAccessTokenPrefix = "pat_"
+
+type PersonalAccessToken struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId   primitive.ObjectID `bson:"account_id" owner:"account"`
+	Name        string             `bson:"name" validate:"required,max=100"`
+	Hint        string             `bson:"hint"` // First characters of the token, to tell tokens apart
+	TokenHash   string             `bson:"token_hash" json:"-"`
+	Permissions []string           `bson:"permissions"` // Permissions of the account that the token may use
+	ExpiresAt   int64              `bson:"expiresAt"`   // 0 if the token does not expire
+	LastUsedAt  int64              `bson:"lastUsedAt"`
+	LastUsedIP  string             `bson:"lastUsedIP" visibility:"owner"`
+	Revoked     bool               `bson:"revoked"`
+	RevokedAt   int64              `bson:"revokedAt"`
+	CreatedAt   int64              `bson:"createdAt"`
+	UpdatedAt   int64              `bson:"updatedAt"`
+}
@@ -11,7 +11,8 @@ type Project struct {
 	Leader      primitive.ObjectID `json:"leader,omitempty" bson:"leader,omitempty" validate:"required"`
 	Title       string             `json:"title,omitempty" bson:"title,omitempty" validate:"required"`
 	Description string             `json:"description,omitempty" bson:"description,omitempty"`
-	CreatedAt   time.Time          `bson:"createdAt"` // No update
+	Workflow    *Workflow          `json:"workflow,omitempty" bson:"workflow,omitempty"` // The default workflow when nil
+	CreatedAt   time.Time          `bson:"createdAt"`                                    // No update
 	UpdatedAt   time.Time          `bson:"updatedAt"`
 }
 
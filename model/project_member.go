@@ -0,0 +1,24 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles of an employee within a project
+const (
+	ProjectRoleLeader      = "leader"
+	ProjectRoleContributor = "contributor"
+	ProjectRoleViewer      = "viewer"
+)
+
+type ProjectMember struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Project   primitive.ObjectID `json:"project,omitempty" bson:"project,omitempty"`
+	Employee  primitive.ObjectID `json:"employee,omitempty" bson:"employee,omitempty" validate:"required"`
+	Role      string             `json:"role,omitempty" bson:"role,omitempty" validate:"required,oneof=leader contributor viewer"`
+	JoinedAt  int64              `json:"joinedAt" bson:"joinedAt"`
+	CreatedAt int64              `bson:"createdAt"`
+	UpdatedAt int64              `bson:"updatedAt"`
+}
+
+// Project ->> [ProjectMember] ->> Employee
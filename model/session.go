@@ -0,0 +1,22 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Session struct {
+	Id                primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId         primitive.ObjectID `bson:"account_id" owner:"account"`
+	RefreshTokenHash  string             `bson:"refresh_token_hash" json:"-"`
+	PreviousTokenHash string             `bson:"previous_token_hash" json:"-"` // Kept to detect reuse of a rotated refresh token
+	UserAgent         string             `bson:"userAgent"`
+	IP                string             `bson:"ip" visibility:"owner"` // IP the session was created from
+	LastSeenAt        int64              `bson:"lastSeenAt"`
+	LastSeenIP        string             `bson:"lastSeenIP" visibility:"owner"`
+	ImpersonatorId    primitive.ObjectID `bson:"impersonator_account_id,omitempty" visibility:"admin"` // Set when an admin acts as the account in this session
+	Revoked           bool               `bson:"revoked"`
+	RevokedAt         int64              `bson:"revokedAt"`
+	ExpiresAt         int64              `bson:"expiresAt"`
+	CreatedAt         int64              `bson:"createdAt"`
+	UpdatedAt         int64              `bson:"updatedAt"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Link between a user of the identity provider and an account
+type SSOIdentity struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId   primitive.ObjectID `bson:"account_id"`
+	Issuer      string             `bson:"issuer"`
+	Subject     string             `bson:"subject"` // Stable ID of the user at the provider, the email can change
+	Email       string             `bson:"email"`
+	LastLoginAt int64              `bson:"lastLoginAt"`
+	CreatedAt   int64              `bson:"createdAt"`
+	UpdatedAt   int64              `bson:"updatedAt"`
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A login started on the identity provider, waiting for its callback
+type SSOLogin struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash    string             `bson:"state_hash" json:"-"`
+	Nonce        string             `bson:"nonce" json:"-"`
+	CodeVerifier string             `bson:"code_verifier" json:"-"` // PKCE secret, only its hash was sent to the provider
+	Used         bool               `bson:"used"`
+	ExpiresAt    int64              `bson:"expiresAt"`
+	CreatedAt    int64              `bson:"createdAt"`
+	UpdatedAt    int64              `bson:"updatedAt"`
+}
@@ -6,17 +6,37 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Priorities of tasks, from the lowest to the highest
+const (
+	TaskPriorityLow    = "low"
+	TaskPriorityMedium = "medium"
+	TaskPriorityHigh   = "high"
+	TaskPriorityUrgent = "urgent"
+)
+
+// The priority scale in order, tasks sort by their position in it
+var TaskPriorities = []string{TaskPriorityLow, TaskPriorityMedium, TaskPriorityHigh, TaskPriorityUrgent}
+
 type Task struct {
-	Id          primitive.ObjectID   `bson:"_id,omitempty"`
-	Epic        primitive.ObjectID   `bson:"epic,omitempty" validate:"required"` // No update
-	Members     []primitive.ObjectID `bson:"members,omitempty"`
-	Status      string               `bson:"status,omitempty"`
-	Title       string               `bson:"title,omitempty" validate:"required"`
-	Description string               `bson:"description,omitempty"`
-	Note        string               `bson:"note,omitempty"`
-	Attachments []string             `bson:"attachments,omitempty"`
-	CreatedAt   time.Time            `bson:"createdAt"` // No update
-	UpdatedAt   time.Time            `bson:"updatedAt"`
+	Id            primitive.ObjectID   `bson:"_id,omitempty"`
+	Epic          primitive.ObjectID   `bson:"epic,omitempty" validate:"required"` // No update
+	Project       primitive.ObjectID   `bson:"project,omitempty"`                  // Project of the epic, set with the rank
+	Parent        primitive.ObjectID   `bson:"parent,omitempty"`                   // Task this one is a subtask of, no update
+	Members       []primitive.ObjectID `bson:"members,omitempty"`
+	Status        string               `bson:"status,omitempty"` // A status of the project workflow, changed through its transitions
+	Rank          string               `bson:"rank,omitempty"`   // Position on the board, compared as strings, unique within the project
+	Title         string               `bson:"title,omitempty" validate:"required"`
+	Description   string               `bson:"description,omitempty"`
+	Note          string               `bson:"note,omitempty"`
+	Attachments   []string             `bson:"attachments,omitempty"`
+	Checklist     []ChecklistItem      `bson:"checklist,omitempty"`
+	StartDate     time.Time            `bson:"startDate,omitempty"`
+	DueDate       time.Time            `bson:"dueDate,omitempty" validate:"omitempty,gtefield=StartDate"`
+	Priority      string               `bson:"priority,omitempty" validate:"omitempty,oneof=low medium high urgent"`
+	StoryPoints   float64              `bson:"storyPoints,omitempty" validate:"min=0,max=1000"`
+	EstimateHours float64              `bson:"estimateHours,omitempty" validate:"min=0,max=10000"`
+	CreatedAt     time.Time            `bson:"createdAt"` // No update
+	UpdatedAt     time.Time            `bson:"updatedAt"`
 }
 
 // Project ->> Epic ->> [Task]
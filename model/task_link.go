@@ -0,0 +1,25 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Types of links between tasks
+const (
+	TaskLinkBlocks     = "blocks"     // The target cannot start until the source is done
+	TaskLinkRelatesTo  = "relates_to" // No order between the tasks, either way
+	TaskLinkDuplicates = "duplicates" // The source repeats the target
+)
+
+// A typed link between two tasks of the same project, possibly of different epics
+type TaskLink struct {
+	Id        primitive.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
+	Project   primitive.ObjectID `json:"project" bson:"project"`
+	Source    primitive.ObjectID `json:"source" bson:"source"`
+	Target    primitive.ObjectID `json:"target" bson:"target" validate:"required"`
+	Type      string             `json:"type" bson:"type" validate:"required,oneof=blocks relates_to duplicates"`
+	CreatedBy primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	CreatedAt int64              `json:"createdAt" bson:"createdAt"`
+}
+
+// Task ->> [TaskLink] ->> Task
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TwoFactor struct {
+	Id                 primitive.ObjectID `bson:"_id,omitempty"`
+	AccountId          primitive.ObjectID `bson:"account_id"`
+	Secret             string             `bson:"secret" json:"-"` // TOTP secret encrypted with the keyring
+	Enabled            bool               `bson:"enabled"`
+	RecoveryCodeHashes []string           `bson:"recovery_code_hashes" json:"-"`
+	LastUsedStep       int64              `bson:"last_used_step" json:"-"` // Time step of the last accepted code, so a code cannot be replayed
+	EnabledAt          int64              `bson:"enabledAt"`
+	CreatedAt          int64              `bson:"createdAt"`
+	UpdatedAt          int64              `bson:"updatedAt"`
+}
@@ -5,7 +5,7 @@ import (
 )
 
 type UserInfor struct {
-	Id primitive.ObjectID `bson:"_id,omitempty"`
+	Id primitive.ObjectID `bson:"_id,omitempty" owner:"userinfor"`
 
 	FullName      string             `bson:"fullname,omitempty" validate:"required"`
 	Profile_Image string             `bson:"profile_image"`
@@ -13,10 +13,12 @@ type UserInfor struct {
 	Department    int                `bson:"department"`
 	Position      int                `bson:"position"`
 	Manager_ID    primitive.ObjectID `bson:"manager_id"`
-	Phone         string             `bson:"phone"`
-	Email         string             `bson:"email"`
+	Phone         string             `bson:"phone" visibility:"owner"`
+	Email         string             `bson:"email" visibility:"owner"`
+	EmailIndex    string             `bson:"email_index,omitempty" json:"-"` // Blind index of the email, for lookups while it is encrypted
+	PhoneIndex    string             `bson:"phone_index,omitempty" json:"-"`
 	Gender        int                `bson:"gender"`
-	Address       string             `bson:"address"`
+	Address       string             `bson:"address" visibility:"owner"`
 	CreatedAt     int64              `bson:"createdAt"`
 	UpdatedAt     int64              `bson:"updatedAt"`
 }
@@ -0,0 +1,17 @@
+package model
+
+// Who may see a model field in API responses, set with the visibility struct tag.
+// Fields without the tag are public, fields with json:"-" are secret
+const (
+	VisibilityPublic = "public"
+	VisibilityOwner  = "owner"  // The account the document belongs to, and admins
+	VisibilityAdmin  = "admin"  // Admins only
+	VisibilitySecret = "secret" // Never sent, like hashes and keys
+)
+
+// Accounts a document belongs to, set with the owner struct tag on the field holding their ID
+const (
+	OwnerAccount   = "account"
+	OwnerEmployee  = "employee"
+	OwnerUserInfor = "userinfor"
+)
@@ -0,0 +1,49 @@
+package model
+
+// Categories of workflow statuses, boards and reports group statuses by them
+const (
+	WorkflowCategoryTodo       = "todo"
+	WorkflowCategoryInProgress = "in_progress"
+	WorkflowCategoryDone       = "done"
+)
+
+// A status tasks of a project can be in
+type WorkflowStatus struct {
+	Name     string `json:"name" bson:"name" validate:"required,max=50"`
+	Category string `json:"category" bson:"category" validate:"required,oneof=todo in_progress done"`
+}
+
+// A move allowed between two statuses
+type WorkflowTransition struct {
+	From  string   `json:"from" bson:"from" validate:"required"`
+	To    string   `json:"to" bson:"to" validate:"required"`
+	Roles []string `json:"roles,omitempty" bson:"roles,omitempty" validate:"dive,oneof=leader contributor"` // Leaders and contributors when empty
+}
+
+// The statuses of the tasks of a project and the moves allowed between them
+type Workflow struct {
+	InitialStatus string               `json:"initialStatus" bson:"initialStatus" validate:"required"` // Status of new tasks
+	Statuses      []WorkflowStatus     `json:"statuses" bson:"statuses" validate:"required,min=1,dive"`
+	Transitions   []WorkflowTransition `json:"transitions" bson:"transitions" validate:"dive"`
+}
+
+// Workflow of new projects, and of existing ones after the workflow migration
+var DefaultWorkflow = Workflow{
+	InitialStatus: "To Do",
+	Statuses: []WorkflowStatus{
+		{Name: "To Do", Category: WorkflowCategoryTodo},
+		{Name: "In Progress", Category: WorkflowCategoryInProgress},
+		{Name: "In Review", Category: WorkflowCategoryInProgress},
+		{Name: "Done", Category: WorkflowCategoryDone},
+	},
+	Transitions: []WorkflowTransition{
+		{From: "To Do", To: "In Progress"},
+		{From: "In Progress", To: "To Do"},
+		{From: "In Progress", To: "In Review"},
+		{From: "In Review", To: "In Progress"},
+		{From: "In Review", To: "Done", Roles: []string{ProjectRoleLeader}},
+		{From: "Done", To: "In Progress", Roles: []string{ProjectRoleLeader}},
+	},
+}
+
+// Project ->> Workflow ->> [WorkflowStatus]
@@ -2,20 +2,37 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func AccountRoute(route *gin.Engine) {
-	route.GET("/accounts-get-all", controller.AccountGetAll())
+	route.GET("/accounts-get-all", middleware.RequirePermission(model.PermissionAccountAdmin), controller.AccountGetAll())
 	//route.GET("/get-account-to-update/:id", controller.GetAccountToUpdate())
 	//route.GET("/my-account", controller.MyAccount())
-	route.GET("/account-delete-all", controller.AccountDeleteAll())
+	route.DELETE("/account-delete-all", middleware.RequirePermission(model.PermissionAccountAdmin), controller.AccountDeleteAll())
 
-	route.DELETE("/account-delete-one/:id", controller.AccountDeleteOne())
-	route.POST("/account-update/:id", controller.AccountUpdate())
+	route.DELETE("/account-delete-one/:id", middleware.RequirePermission(model.PermissionAccountAdmin), controller.AccountDeleteOne())
+	route.POST("/account-update/:id", middleware.RequirePermission(model.PermissionAccountAdmin), controller.AccountUpdate())
 
-	route.POST("/account-add", controller.AccountAdd())
-	route.GET("/reset-password/:id", controller.ResetPassword())
-	route.POST("/change-password/:id", controller.ChangePassword())
+	route.POST("/account-add", middleware.RequirePermission(model.PermissionAccountAdmin), controller.AccountAdd())
+	route.POST("/reset-password/:id", middleware.RequirePermission(model.PermissionAccountAdmin), controller.ResetPassword())
+	route.POST("/change-password/:id", middleware.ForbidImpersonation(), controller.ChangePassword())
+
+	route.POST("/accounts/:id/impersonate", middleware.RequirePermission(model.PermissionAccountImpersonate), middleware.ForbidImpersonation(), controller.StartImpersonation())
+	route.GET("/accounts/:id/sessions", middleware.RequirePermission(model.PermissionAccountAdmin), controller.GetAccountSessions())
+	route.DELETE("/accounts/:id/sessions", middleware.RequirePermission(model.PermissionAccountAdmin), controller.RevokeAllAccountSessions())
+	route.DELETE("/accounts/:id/sessions/:sessionId", middleware.RequirePermission(model.PermissionAccountAdmin), controller.RevokeAccountSession())
+
+	route.GET("/login-lockouts", middleware.RequirePermission(model.PermissionAccountAdmin), controller.GetLoginLockouts())
+	route.POST("/login-lockouts/unlock", middleware.RequirePermission(model.PermissionAccountAdmin), controller.UnlockLogin())
+	route.GET("/lockout-events", middleware.RequirePermission(model.PermissionAccountAdmin), controller.GetLockoutEvents())
+
+	route.GET("/guests", middleware.RequirePermission(model.PermissionAccountAdmin), controller.GetGuests())
+	route.POST("/guests", middleware.RequirePermission(model.PermissionAccountAdmin), controller.CreateGuest())
+	route.PUT("/guests/:id", middleware.RequirePermission(model.PermissionAccountAdmin), controller.UpdateGuest())
+	route.DELETE("/guests/:id", middleware.RequirePermission(model.PermissionAccountAdmin), controller.DeleteGuest())
+	route.GET("/guest/projects", middleware.RequirePermission(model.PermissionProjectRead), controller.GetGuestProjects())
 }
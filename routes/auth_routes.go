@@ -2,6 +2,8 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,14 +11,65 @@ import (
 func AuthRoute(route *gin.Engine) {
 	//User authentication
 	route.POST("/login", controller.LoginHandler())
-	route.GET("/logout", controller.LogOutHandler())
+	route.POST("/login/two-factor", controller.LoginTwoFactor())
+	route.POST("/login/two-factor/setup", controller.LoginTwoFactorSetup())
+	route.POST("/login/two-factor/enable", controller.LoginTwoFactorEnable())
+	route.POST("/login/change-password", controller.LoginChangePassword())
+	route.POST("/logout", controller.LogOutHandler())
+	route.POST("/token/refresh", controller.RefreshToken())
+	route.GET("/csrf-token", controller.GetCSRFToken())
 	route.GET("/isAuthorized", controller.IsAuthorized())
+	route.POST("/forgot-password", controller.ForgotPassword())
+	route.POST("/forgot-password/confirm", controller.ConfirmPasswordReset())
+	route.POST("/invitation/accept", controller.AcceptInvitation())
+	route.POST("/magic-link", controller.RequestMagicLink())
+	route.POST("/magic-link/confirm", controller.ConfirmMagicLink())
+	route.GET("/sso/login", controller.SSOLogin())
+	route.GET("/sso/callback", controller.SSOCallback())
+	route.POST("/login-alert/confirm", controller.ConfirmLoginAlert())
+	route.POST("/login-alert/flag", controller.FlagLoginAlert())
 	//route.GET("/get-my-role-name", controller.GetMyRoleName())
 
+	//Two-factor authentication of the logged in account
+	route.GET("/two-factor", controller.GetTwoFactorStatus())
+	route.POST("/two-factor/setup", middleware.ForbidImpersonation(), controller.SetupTwoFactor())
+	route.POST("/two-factor/enable", middleware.ForbidImpersonation(), controller.EnableTwoFactor())
+	route.POST("/two-factor/disable", middleware.ForbidImpersonation(), controller.DisableTwoFactor())
+	route.POST("/two-factor/recovery-codes", middleware.ForbidImpersonation(), controller.RegenerateRecoveryCodes())
+
+	//Sessions of the logged in account
+	route.GET("/sessions", controller.GetSessions())
+	route.DELETE("/sessions/:id", middleware.ForbidImpersonation(), controller.RevokeSession())
+	route.POST("/sessions/revoke-others", middleware.ForbidImpersonation(), controller.RevokeOtherSessions())
+	route.GET("/devices", controller.GetKnownDevices())
+
+	//Impersonation, the owner-only actions above and below are forbidden while impersonating
+	route.POST("/impersonation/stop", controller.StopImpersonation())
+	route.GET("/impersonations", controller.GetImpersonations())
+
+	//Personal access tokens of the logged in account, used with the Authorization: Bearer header
+	route.GET("/access-tokens", controller.GetPersonalAccessTokens())
+	route.POST("/access-tokens", middleware.ForbidImpersonation(), controller.CreatePersonalAccessToken())
+	route.DELETE("/access-tokens/:id", middleware.ForbidImpersonation(), controller.RevokePersonalAccessToken())
+
 	//Authorization
-	route.POST("/authorization-add", controller.AuthorizationAdd())
-	route.GET("/authorization-get-all", controller.AuthorizationGetAll())
-	route.DELETE("/authorization/:id", controller.AuthorizationDelete())
-	route.GET("/authorization/:id", controller.GetAuthorizationById())
-	route.PUT("/authorization/:id", controller.UpdateAuthorization())
+	route.POST("/authorization-add", middleware.RequirePermission(model.PermissionAuthorizationAdmin), controller.AuthorizationAdd())
+	route.GET("/authorization-get-all", middleware.RequirePermission(model.PermissionAuthorizationRead), controller.AuthorizationGetAll())
+	route.GET("/authorization-permissions", middleware.RequirePermission(model.PermissionAuthorizationRead), controller.GetPermissions())
+	route.DELETE("/authorization/:id", middleware.RequirePermission(model.PermissionAuthorizationAdmin), controller.AuthorizationDelete())
+	route.GET("/authorization/:id", middleware.RequirePermission(model.PermissionAuthorizationRead), controller.GetAuthorizationById())
+	route.PUT("/authorization/:id", middleware.RequirePermission(model.PermissionAuthorizationAdmin), controller.UpdateAuthorization())
+
+	//Password policy, forms outside a session learn the broken rules from the violations of the response
+	route.GET("/password-policy", controller.GetPasswordPolicy())
+	route.PUT("/password-policy", middleware.RequirePermission(model.PermissionAccountAdmin), controller.UpdatePasswordPolicy())
+
+	//Signing and encryption keys
+	route.GET("/keys", middleware.RequirePermission(model.PermissionKeyAdmin), controller.GetKeyringKeys())
+	route.POST("/keys/rotate", middleware.RequirePermission(model.PermissionKeyAdmin), controller.RotateKeyringKey())
+	route.POST("/keys/:kid/retire", middleware.RequirePermission(model.PermissionKeyAdmin), controller.RetireKeyringKey())
+
+	//Audit log
+	route.GET("/audit-events", middleware.RequirePermission(model.PermissionAuditRead), controller.GetAuditEvents())
+	route.GET("/audit-events/export", middleware.RequirePermission(model.PermissionAuditRead), controller.ExportAuditEvents())
 }
@@ -2,20 +2,26 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func EmployeeRoute(route *gin.Engine) {
-	route.POST("/get-employee-id", controller.GetEmployeeIDByFullname())
-	route.POST("/employee-update-state", controller.EmployeeUpdateState())
-	route.POST("/search-employee-with-fullname", controller.EmployeeSearchFullName())
-	route.GET("/employee-get-all", controller.EmployeeGetAll())
-	route.GET("/get-employee-detail/:id", controller.GetEmployeeDetailWithID())
-	route.GET("/get-employee-by-role/:role", controller.GetEmployeeByRole())
-	route.GET("/get-employee-by-manager/:manager", controller.GetEmployeeByManager())
+	route.POST("/get-employee-id", middleware.RequirePermission(model.PermissionEmployeeRead), controller.GetEmployeeIDByFullname())
+	route.POST("/employee-update-state", middleware.RequirePermission(model.PermissionEmployeeWrite), controller.EmployeeUpdateState())
+	route.POST("/search-employee-with-fullname", middleware.RequirePermission(model.PermissionEmployeeRead), controller.EmployeeSearchFullName())
+	route.GET("/employee-get-all", middleware.RequirePermission(model.PermissionEmployeeRead), controller.EmployeeGetAll())
+	route.GET("/get-employee-detail/:id", middleware.RequirePermission(model.PermissionEmployeeRead), controller.GetEmployeeDetailWithID())
+	route.GET("/get-employee-by-role/:role", middleware.RequirePermission(model.PermissionEmployeeRead), controller.GetEmployeeByRole())
+	route.GET("/get-employee-by-manager/:manager", middleware.RequirePermission(model.PermissionEmployeeRead), controller.GetEmployeeByManager())
 
-	route.POST("/create-employee", controller.CreateEmployee())
-	route.PUT("/update-employee", controller.UpdateEmployee())
-	route.GET("/get-employee-by-project/:project", controller.GetEmployeeByProject())
+	route.POST("/create-employee", middleware.RequirePermission(model.PermissionEmployeeAdmin), controller.CreateEmployee())
+	route.PUT("/update-employee", middleware.RequirePermission(model.PermissionEmployeeAdmin), controller.UpdateEmployee())
+	route.GET("/get-employee-by-project/:project", middleware.RequirePermission(model.PermissionEmployeeRead), controller.GetEmployeeByProject())
+
+	route.GET("/invitations", middleware.RequirePermission(model.PermissionEmployeeAdmin), controller.GetInvitations())
+	route.POST("/invitation/:id/resend", middleware.RequirePermission(model.PermissionEmployeeAdmin), controller.ResendInvitation())
+	route.DELETE("/invitation/:id", middleware.RequirePermission(model.PermissionEmployeeAdmin), controller.RevokeInvitation())
 }
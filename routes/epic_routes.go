@@ -2,17 +2,21 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func EpicRoute(route *gin.Engine) {
-	route.POST("/epic", controller.CreateEpic())
-	route.GET("/epics", controller.GetEpics())
-	route.GET("/epic/:id", controller.GetEpicById())
-	route.GET("/epic/search", controller.SearchEpic())
-	route.GET("/epic-for-project/:id", controller.GetEpicForProject())
-	route.PUT("/epic", controller.UpdateEpic())
-	route.DELETE("/epic", controller.DeleteEpic())
-	route.GET("/get-leader-for-epic/:id", controller.GetLeaderForEpic())
+	route.POST("/epic", middleware.RequirePermission(model.PermissionEpicWrite), controller.CreateEpic())
+	route.GET("/epics", middleware.RequirePermission(model.PermissionEpicRead), controller.GetEpics())
+	route.GET("/epic/:id", middleware.RequirePermission(model.PermissionEpicRead), controller.GetEpicById())
+	route.GET("/epic/search", middleware.RequirePermission(model.PermissionEpicRead), controller.SearchEpic())
+	route.GET("/epic-for-project/:id", middleware.RequirePermission(model.PermissionEpicRead), controller.GetEpicForProject())
+	route.PUT("/epic", middleware.RequirePermission(model.PermissionEpicWrite), controller.UpdateEpic())
+	route.DELETE("/epic", middleware.RequirePermission(model.PermissionEpicWrite), controller.DeleteEpic())
+	route.GET("/get-leader-for-epic/:id", middleware.RequirePermission(model.PermissionEpicRead), controller.GetLeaderForEpic())
+	route.GET("/epic/:id/board", middleware.RequirePermission(model.PermissionTaskRead), controller.GetEpicBoard())
+	route.GET("/epic/:id/graph", middleware.RequirePermission(model.PermissionTaskRead), controller.GetEpicGraph())
 }
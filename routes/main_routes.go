@@ -2,6 +2,8 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,5 +12,5 @@ func MainRoute(route *gin.Engine) {
 	//route.GET("/home-employee", controller.HomeController())
 	//route.GET("/home-manager", controller.HomeController())
 	//route.POST("/home", controller.TestController())
-	route.POST("/upload-image", controller.UploadFile())
+	route.POST("/upload-image", middleware.RequirePermission(model.PermissionFileUpload), controller.UploadFile())
 }
@@ -2,12 +2,14 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func MessageRoute(route *gin.Engine) {
-	route.POST("/create-message", controller.CreateMessage())
-	route.GET("/get-message-by-id/:id", controller.GetMessageById())
-	route.GET("/get-message-by-project/:id", controller.GetMessageByProject())
+	route.POST("/create-message", middleware.RequirePermission(model.PermissionMessageWrite), controller.CreateMessage())
+	route.GET("/get-message-by-id/:id", middleware.RequirePermission(model.PermissionMessageRead), controller.GetMessageById())
+	route.GET("/get-message-by-project/:id", middleware.RequirePermission(model.PermissionMessageRead), controller.GetMessageByProject())
 }
@@ -2,17 +2,31 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func ProjectRoute(route *gin.Engine) {
-	route.POST("/project", controller.CreateProject())
-	route.GET("/projects", controller.GetProjects())
-	route.GET("/project/:id", controller.GetProjectById())
-	route.GET("/project/search/:query", controller.SearchProject())
-	route.PUT("/project/:id", controller.UpdateProject())
-	route.DELETE("/project/:id", controller.DeleteProject())
+	route.POST("/project", middleware.RequirePermission(model.PermissionProjectWrite), controller.CreateProject())
+	route.GET("/projects", middleware.RequirePermission(model.PermissionProjectRead), controller.GetProjects())
+	route.GET("/project/:id", middleware.RequirePermission(model.PermissionProjectRead), controller.GetProjectById())
+	route.GET("/project/search/:query", middleware.RequirePermission(model.PermissionProjectRead), controller.SearchProject())
+	route.PUT("/project/:id", middleware.RequirePermission(model.PermissionProjectWrite), controller.UpdateProject())
+	route.DELETE("/project/:id", middleware.RequirePermission(model.PermissionProjectWrite), controller.DeleteProject())
 
-	route.GET("/view-projects-for-manager/:id", controller.GetProjectsForManager())
+	route.GET("/project/:id/members", middleware.RequirePermission(model.PermissionProjectRead), controller.GetProjectMembers())
+	route.POST("/project/:id/members", middleware.RequirePermission(model.PermissionProjectWrite), controller.AddProjectMember())
+	route.PUT("/project/:id/members/:employee", middleware.RequirePermission(model.PermissionProjectWrite), controller.UpdateProjectMember())
+	route.DELETE("/project/:id/members/:employee", middleware.RequirePermission(model.PermissionProjectWrite), controller.RemoveProjectMember())
+
+	route.GET("/project/:id/workflow", middleware.RequirePermission(model.PermissionProjectRead), controller.GetProjectWorkflow())
+	route.PUT("/project/:id/workflow", middleware.RequirePermission(model.PermissionProjectWrite), controller.UpdateProjectWorkflow())
+	route.GET("/project/:id/board", middleware.RequirePermission(model.PermissionTaskRead), controller.GetProjectBoard())
+
+	route.PUT("/project/:id/guests/:guest", middleware.RequirePermission(model.PermissionProjectWrite), controller.ShareProjectWithGuest())
+	route.DELETE("/project/:id/guests/:guest", middleware.RequirePermission(model.PermissionProjectWrite), controller.UnshareProjectWithGuest())
+
+	route.GET("/view-projects-for-manager/:id", middleware.RequirePermission(model.PermissionProjectRead), controller.GetProjectsForManager())
 }
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"backend/config"
+	"backend/middleware"
+	"backend/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A password key in a JSON response, whatever its value
+var passwordKeyPattern = regexp.MustCompile(`"password"\s*:`)
+
+/*
+Call every route as an admin and check that no response carries a password, a hash, a TOTP secret or encrypted personal data.
+Needs a MongoDB that can be wiped: MONGOURI (a local one by default), and DBNAME ending with "test", since routes like DELETE /account-delete-all run too
+*/
+func TestRoutesNeverSendSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	if !strings.HasSuffix(os.Getenv("DBNAME"), "test") {
+		t.Skip("A DBNAME ending with \"test\" is needed to call the routes")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if pingErr := config.DB.Ping(ctx, nil); pingErr != nil {
+		t.Skip("MongoDB is not reachable: " + pingErr.Error())
+	}
+
+	ids, secrets := seedRedactionAccount(t)
+	currentAccount, accountErr := middleware.LoadCurrentAccount(ctx, ids["account"])
+	if accountErr != nil || currentAccount == nil {
+		t.Fatalf("Error loading the seeded account: %v", accountErr)
+	}
+
+	// The seeded admin is logged in on every request, in place of CookieAuth
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("currentAccount", currentAccount)
+		c.Set("currentSession", ids["session"])
+		c.Next()
+	})
+	Register(router)
+
+	// Reads first with each seeded ID, then the writes with unknown IDs so the seeded documents stay
+	var reads, writes []gin.RouteInfo
+	for _, route := range router.Routes() {
+		if route.Method == http.MethodGet {
+			reads = append(reads, route)
+		} else {
+			writes = append(writes, route)
+		}
+	}
+	for _, route := range reads {
+		for _, id := range ids {
+			checkRouteResponse(t, router, route, id, secrets)
+		}
+	}
+	for _, route := range writes {
+		checkRouteResponse(t, router, route, primitive.NewObjectID(), secrets)
+	}
+}
+
+/*
+Call a route with every path parameter set to an ID and an empty JSON body, and fail the test if the response carries a secret
+
+params: t *testing.T The test
+
+router *gin.Engine The router
+
+route gin.RouteInfo The route
+
+id primitive.ObjectID The ID to set the path parameters to
+
+secrets []string Values that must never be sent
+*/
+func checkRouteResponse(t *testing.T, router *gin.Engine, route gin.RouteInfo, id primitive.ObjectID, secrets []string) {
+	segments := strings.Split(route.Path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = id.Hex()
+		}
+	}
+	path := strings.Join(segments, "/")
+
+	request := httptest.NewRequest(route.Method, path, strings.NewReader("{}"))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	if passwordKeyPattern.MatchString(body) {
+		t.Errorf("%s %s sent a password field: %s", route.Method, path, body)
+	}
+	for _, secret := range secrets {
+		if secret != "" && strings.Contains(body, secret) {
+			t.Errorf("%s %s sent the secret %q: %s", route.Method, path, secret, body)
+		}
+	}
+}
+
+/*
+Store an admin account with an employee, user information, a session and two-factor authentication, deleted when the test ends
+
+params: t *testing.T The test
+
+return: map[string]primitive.ObjectID IDs of the seeded documents, by kind
+
+[]string The stored secrets: hashes, the TOTP secret, the encrypted personal data and its blind indexes
+*/
+func seedRedactionAccount(t *testing.T) (map[string]primitive.ObjectID, []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	suffix := primitive.NewObjectID().Hex()
+	ids := map[string]primitive.ObjectID{
+		"authorization": primitive.NewObjectID(),
+		"account":       primitive.NewObjectID(),
+		"userinfor":     primitive.NewObjectID(),
+		"employee":      primitive.NewObjectID(),
+		"session":       primitive.NewObjectID(),
+		"twoFactor":     primitive.NewObjectID(),
+	}
+	passwordHash := "$2a$10$redaction" + suffix
+	historyHash := "$2a$10$history" + suffix
+	totpSecret := "TOTPSECRET" + strings.ToUpper(suffix)
+	recoveryHash := "recovery" + suffix
+	refreshHash := "refresh" + suffix
+
+	// Personal data is stored encrypted when a key is available, only the plaintext may be sent
+	t.Setenv("PII_ENCRYPTION", "true")
+	email := "redaction-" + suffix + "@example.com"
+	storedEmail, encryptErr := config.EncryptPII("email", email)
+	if encryptErr != nil {
+		t.Log("Personal data is stored in plaintext: " + encryptErr.Error())
+		storedEmail = email
+	}
+	emailIndex := config.BlindIndex("email", email)
+	secrets := []string{passwordHash, historyHash, totpSecret, recoveryHash, refreshHash, emailIndex}
+	if storedEmail != email {
+		secrets = append(secrets, storedEmail)
+	}
+
+	now := time.Now().Unix()
+	seeds := []struct {
+		collection string
+		document   interface{}
+	}{
+		{"authorizations", model.Authorization{Id: ids["authorization"], LevelName: "redaction-" + suffix, Permissions: []string{model.PermissionAll}}},
+		{"accounts", model.Account{
+			Id:                       ids["account"],
+			Username:                 "redaction-" + suffix,
+			Password:                 passwordHash,
+			Account_Name:             "Redaction",
+			Account_Authorization_Id: ids["authorization"],
+			PasswordHistory:          []string{historyHash},
+			CreatedAt:                now,
+		}},
+		{"user_infor", model.UserInfor{Id: ids["userinfor"], FullName: "Redaction Test", Email: storedEmail, EmailIndex: emailIndex, CreatedAt: now}},
+		{"employee", model.Employee{Id: ids["employee"], AccountID: ids["account"], UserInforId: ids["userinfor"], CreatedAt: now}},
+		{"sessions", model.Session{Id: ids["session"], AccountId: ids["account"], RefreshTokenHash: refreshHash, ExpiresAt: now + 3600, CreatedAt: now}},
+		{"two_factors", model.TwoFactor{Id: ids["twoFactor"], AccountId: ids["account"], Secret: totpSecret, Enabled: true, RecoveryCodeHashes: []string{recoveryHash}, CreatedAt: now}},
+	}
+	for _, seed := range seeds {
+		if _, insertErr := config.GetCollection(config.DB, seed.collection).InsertOne(ctx, seed.document); insertErr != nil {
+			t.Fatalf("Error seeding %s: %v", seed.collection, insertErr)
+		}
+	}
+
+	t.Cleanup(func() {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cleanupCancel()
+		for _, seed := range seeds {
+			_, _ = config.GetCollection(config.DB, seed.collection).DeleteMany(cleanupCtx, bson.M{"_id": bson.M{"$in": []primitive.ObjectID{
+				ids["authorization"], ids["account"], ids["userinfor"], ids["employee"], ids["session"], ids["twoFactor"],
+			}}})
+		}
+	})
+
+	return ids, secrets
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Register every route of the API on the router, after its middleware
+func Register(router *gin.Engine) {
+	MainRoute(router)
+	TaskRoute(router)
+	ProjectRoute(router)
+	EpicRoute(router)
+	AuthRoute(router)
+	AccountRoute(router)
+	UserInforRoute(router)
+	EmployeeRoute(router)
+	MessageRoute(router)
+}
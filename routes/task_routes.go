@@ -2,14 +2,32 @@ package routes
 
 import (
 	"backend/controller"
+	"backend/middleware"
+	"backend/model"
 
 	"github.com/gin-gonic/gin"
 )
 
 func TaskRoute(route *gin.Engine) {
-	route.POST("/task", controller.CreateTask())
-	// route.GET("/task", controllers.GetTasks())
-	// route.GET("/task/:id", controllers.GetTask())
+	route.POST("/task", middleware.RequirePermission(model.PermissionTaskWrite), controller.CreateTask())
+	route.GET("/tasks", middleware.RequirePermission(model.PermissionTaskRead), controller.GetTasks())
+	route.GET("/task/search", middleware.RequirePermission(model.PermissionTaskRead), controller.SearchTask())
+	route.GET("/task/:id", middleware.RequirePermission(model.PermissionTaskRead), controller.GetTaskById())
 	// route.PUT("/task/:id", controllers.UpdateTask())
-	// route.DELETE("/task/:id", controllers.DeleteTask())
+	route.DELETE("/task/:id", middleware.RequirePermission(model.PermissionTaskWrite), controller.DeleteTask())
+	route.GET("/tasks/overdue", middleware.RequirePermission(model.PermissionTaskRead), controller.GetOverdueTasks())
+	route.GET("/tasks/due-this-week", middleware.RequirePermission(model.PermissionTaskRead), controller.GetTasksDueThisWeek())
+	route.GET("/tasks/my-upcoming", middleware.RequirePermission(model.PermissionTaskRead), controller.GetMyUpcomingTasks())
+
+	route.POST("/task/:id/transition", middleware.RequirePermission(model.PermissionTaskWrite), controller.TransitionTask())
+	route.POST("/task/:id/move", middleware.RequirePermission(model.PermissionTaskWrite), controller.MoveTask())
+
+	route.POST("/task/:id/links", middleware.RequirePermission(model.PermissionTaskWrite), controller.CreateTaskLink())
+	route.DELETE("/task/:id/links/:link", middleware.RequirePermission(model.PermissionTaskWrite), controller.DeleteTaskLink())
+	route.GET("/task/:id/graph", middleware.RequirePermission(model.PermissionTaskRead), controller.GetTaskGraph())
+
+	route.POST("/task/:id/checklist", middleware.RequirePermission(model.PermissionTaskWrite), controller.AddChecklistItem())
+	route.PUT("/task/:id/checklist", middleware.RequirePermission(model.PermissionTaskWrite), controller.ReorderChecklist())
+	route.PUT("/task/:id/checklist/:item", middleware.RequirePermission(model.PermissionTaskWrite), controller.UpdateChecklistItem())
+	route.DELETE("/task/:id/checklist/:item", middleware.RequirePermission(model.PermissionTaskWrite), controller.DeleteChecklistItem())
 }